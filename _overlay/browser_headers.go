@@ -0,0 +1,292 @@
+package http
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	tls "github.com/refraction-networking/utls"
+)
+
+// BrowserHeaders derives the User-Agent, client hint (sec-ch-ua*), Accept
+// and Accept-Language headers, and the header order, that the browser
+// parroted by a ClientHelloID sends, so that the HTTP layer agrees with
+// the TLS fingerprint. See Transport.BrowserHeaders.
+//
+// The zero value describes a desktop browser on Windows with an en-US
+// locale. A nil *BrowserHeaders is equivalent to the zero value.
+type BrowserHeaders struct {
+	// Platform is the operating system to claim: "Windows", "macOS",
+	// "Linux" or "Android". Empty means "Windows". Android implies a
+	// mobile browser. Safari and iOS parrots always claim macOS and
+	// iOS respectively.
+	Platform string
+
+	// AcceptLanguage overrides the browser's default Accept-Language.
+	AcceptLanguage string
+
+	// Inconsistent, if non-nil, is called by the Transport for each
+	// User-Agent or sec-ch-ua* header that a request sets to a value
+	// other than the one derived from its ClientHelloID. want is empty
+	// if the parroted browser does not send the header at all. The
+	// request is sent unchanged.
+	Inconsistent func(req *Request, key, got, want string)
+}
+
+// browserHeaderChecked lists the headers whose disagreement with the
+// ClientHello is reported to BrowserHeaders.Inconsistent. Accept and
+// Accept-Language vary between users of the same browser and are not.
+var browserHeaderChecked = []string{"User-Agent", "Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform"}
+
+const (
+	chromiumAccept = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,image/apng,*/*;q=0.8,application/signed-exchange;v=b3;q=0.7"
+	firefoxAccept  = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+	safariAccept   = "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8"
+)
+
+var (
+	chromiumHeaderOrder = []string{
+		"sec-ch-ua", "sec-ch-ua-mobile", "sec-ch-ua-platform",
+		"upgrade-insecure-requests", "user-agent", "accept",
+		"sec-fetch-site", "sec-fetch-mode", "sec-fetch-user", "sec-fetch-dest",
+		"referer", "accept-encoding", "accept-language", "cookie", "priority",
+	}
+	firefoxHeaderOrder = []string{
+		"user-agent", "accept", "accept-language", "accept-encoding",
+		"referer", "cookie", "upgrade-insecure-requests",
+		"sec-fetch-dest", "sec-fetch-mode", "sec-fetch-site", "sec-fetch-user",
+		"priority", "te",
+	}
+	safariHeaderOrder = []string{
+		"accept", "sec-fetch-site", "cookie", "sec-fetch-dest",
+		"accept-language", "sec-fetch-mode", "user-agent", "referer",
+		"accept-encoding",
+	}
+
+	chromiumPHeaderOrder = []string{":method", ":authority", ":scheme", ":path"}
+	firefoxPHeaderOrder  = []string{":method", ":path", ":authority", ":scheme"}
+	safariPHeaderOrder   = []string{":method", ":scheme", ":path", ":authority"}
+)
+
+// Header returns the headers the browser parroted by id sends on a
+// top-level navigation, including HeaderOrderKey and PHeaderOrderKey.
+// Accept-Encoding is listed in the order but not set, so that the
+// Transport still adds and decodes it. It reports an error for
+// ClientHelloIDs that do not name a browser version, such as HelloCustom
+// and the randomized parrots.
+func (b *BrowserHeaders) Header(id tls.ClientHelloID) (Header, error) {
+	var platform, lang string
+	if b != nil {
+		platform, lang = b.Platform, b.AcceptLanguage
+	}
+	if platform == "" {
+		platform = "Windows"
+	}
+	major, _ := strconv.Atoi(leadingDigits(id.Version))
+	if major == 0 {
+		return nil, noBrowserHeadersError{id}
+	}
+
+	h := make(Header)
+	switch id.Client {
+	case "Chrome", "Edge":
+		os, ok := chromiumPlatforms[platform]
+		if !ok {
+			return nil, fmt.Errorf("http: unknown BrowserHeaders.Platform %q", platform)
+		}
+		mobile := platform == "Android"
+		v := strconv.Itoa(major)
+		ua := "Mozilla/5.0 (" + os + ") AppleWebKit/537.36 (KHTML, like Gecko) Chrome/" + v + ".0.0.0 "
+		if mobile {
+			ua += "Mobile "
+		}
+		ua += "Safari/537.36"
+		brand := "Google Chrome"
+		if id.Client == "Edge" {
+			ua += " Edg/" + v + ".0.0.0"
+			brand = "Microsoft Edge"
+		}
+		h["User-Agent"] = []string{ua}
+		// Client hints are sent by default since Chromium 89.
+		if major >= 89 {
+			h["Sec-Ch-Ua"] = []string{chromiumBrands(major, brand)}
+			h["Sec-Ch-Ua-Mobile"] = []string{map[bool]string{false: "?0", true: "?1"}[mobile]}
+			h["Sec-Ch-Ua-Platform"] = []string{strconv.Quote(platform)}
+		}
+		h["Accept"] = []string{chromiumAccept}
+		h["Accept-Language"] = []string{"en-US,en;q=0.9"}
+		h[HeaderOrderKey] = slices.Clone(chromiumHeaderOrder)
+		h[PHeaderOrderKey] = slices.Clone(chromiumPHeaderOrder)
+	case "Firefox":
+		os, ok := firefoxPlatforms[platform]
+		if !ok {
+			return nil, fmt.Errorf("http: unknown BrowserHeaders.Platform %q", platform)
+		}
+		v := strconv.Itoa(major) + ".0"
+		gecko := "20100101"
+		if platform == "Android" {
+			gecko = v
+		}
+		h["User-Agent"] = []string{"Mozilla/5.0 (" + os + "; rv:" + v + ") Gecko/" + gecko + " Firefox/" + v}
+		h["Accept"] = []string{firefoxAccept}
+		h["Accept-Language"] = []string{"en-US,en;q=0.5"}
+		h[HeaderOrderKey] = slices.Clone(firefoxHeaderOrder)
+		h[PHeaderOrderKey] = slices.Clone(firefoxPHeaderOrder)
+	case "Safari":
+		v := strings.ReplaceAll(id.Version, "_", ".")
+		h["User-Agent"] = []string{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/" + v + " Safari/605.1.15"}
+		h["Accept"] = []string{safariAccept}
+		h["Accept-Language"] = []string{"en-US,en;q=0.9"}
+		h[HeaderOrderKey] = slices.Clone(safariHeaderOrder)
+		h[PHeaderOrderKey] = slices.Clone(safariPHeaderOrder)
+	case "iOS":
+		v := iosVersion(id.Version)
+		h["User-Agent"] = []string{"Mozilla/5.0 (iPhone; CPU iPhone OS " + strings.ReplaceAll(v, ".", "_") + " like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/" + v + " Mobile/15E148 Safari/604.1"}
+		h["Accept"] = []string{safariAccept}
+		h["Accept-Language"] = []string{"en-US,en;q=0.9"}
+		h[HeaderOrderKey] = slices.Clone(safariHeaderOrder)
+		h[PHeaderOrderKey] = slices.Clone(safariPHeaderOrder)
+	default:
+		return nil, noBrowserHeadersError{id}
+	}
+	if lang != "" {
+		h["Accept-Language"] = []string{lang}
+	}
+	return h, nil
+}
+
+// noBrowserHeadersError is the error of BrowserHeaders.Header for a
+// ClientHelloID that names no browser version.
+type noBrowserHeadersError struct {
+	id tls.ClientHelloID
+}
+
+func (e noBrowserHeadersError) Error() string {
+	return "http: no browser headers for ClientHelloID " + e.id.Str()
+}
+
+var chromiumPlatforms = map[string]string{
+	"Windows": "Windows NT 10.0; Win64; x64",
+	"macOS":   "Macintosh; Intel Mac OS X 10_15_7",
+	"Linux":   "X11; Linux x86_64",
+	"Android": "Linux; Android 10; K",
+}
+
+var firefoxPlatforms = map[string]string{
+	"Windows": "Windows NT 10.0; Win64; x64",
+	"macOS":   "Macintosh; Intel Mac OS X 10.15",
+	"Linux":   "X11; Linux x86_64",
+	"Android": "Android 10; Mobile",
+}
+
+// chromiumBrands returns the sec-ch-ua brand list of a Chromium browser,
+// including the GREASE brand, permuted as Chromium does for major.
+func chromiumBrands(major int, brand string) string {
+	const greaseChars = " (:-./);=?_"
+	greaseVersions := []string{"8", "99", "24"}
+	orders := [6][3]int{{0, 1, 2}, {0, 2, 1}, {1, 0, 2}, {1, 2, 0}, {2, 0, 1}, {2, 1, 0}}
+
+	v := strconv.Itoa(major)
+	grease := "Not" + greaseChars[major%11:major%11+1] + "A" + greaseChars[(major+1)%11:(major+1)%11+1] + "Brand"
+	list := []string{
+		`"` + grease + `";v="` + greaseVersions[major%3] + `"`,
+		`"Chromium";v="` + v + `"`,
+		`"` + brand + `";v="` + v + `"`,
+	}
+	var out [3]string
+	for i, j := range orders[major%6] {
+		out[j] = list[i]
+	}
+	return strings.Join(out[:], ", ")
+}
+
+func leadingDigits(s string) string {
+	for i, c := range s {
+		if c < '0' || c > '9' {
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// iosVersion returns the dotted iOS version of a utls iOS ClientHelloID
+// version, which is "111" for 11.1, "12.1" or "13".
+func iosVersion(v string) string {
+	if v == "111" {
+		return "11.1"
+	}
+	if !strings.Contains(v, ".") {
+		v += ".0"
+	}
+	return v
+}
+
+// withBrowserHeaders returns req with the headers of t.BrowserHeaders
+// that it does not set itself, derived from the ClientHelloID of the
+// connection method cm. Headers that req does set are reported to
+// BrowserHeaders.Inconsistent if they disagree. req itself is not
+// modified. Client hints are left out of requests to insecure origins,
+// as browsers only send them over https. A ClientHelloID that names no
+// browser leaves req unchanged; other errors, such as an unknown
+// Platform, fail the request.
+func (t *Transport) withBrowserHeaders(req *Request, cm *connectMethod) (*Request, error) {
+	b := t.BrowserHeaders
+	if b == nil {
+		return req, nil
+	}
+	id := t.ClientHelloSettings.HelloID
+	if cm.fingerprint != nil {
+		id = cm.fingerprint.fp.ClientHello.HelloID
+	}
+	if id.Client == "" {
+		id = tls.HelloChrome_Auto
+	}
+	want, err := b.Header(id)
+	if _, ok := err.(noBrowserHeadersError); ok {
+		return req, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme != "https" {
+		delete(want, "Sec-Ch-Ua")
+		delete(want, "Sec-Ch-Ua-Mobile")
+		delete(want, "Sec-Ch-Ua-Platform")
+	}
+
+	if b.Inconsistent != nil {
+		for _, k := range browserHeaderChecked {
+			name, ok := req.Header.contains(k)
+			if !ok {
+				continue
+			}
+			got := req.Header[name]
+			w := strings.Join(want[k], ", ")
+			if g := strings.Join(got, ", "); g != w {
+				b.Inconsistent(req, k, g, w)
+			}
+		}
+	}
+
+	var h Header
+	for k, vv := range want {
+		if _, ok := req.Header[k]; ok {
+			continue
+		}
+		if _, ok := req.Header.contains(k); ok {
+			continue // set under a key of another case
+		}
+		if h == nil {
+			h = req.Header.Clone()
+		}
+		h[k] = vv
+	}
+	if h == nil {
+		return req, nil
+	}
+	r2 := new(Request)
+	*r2 = *req
+	r2.Header = h
+	return r2, nil
+}
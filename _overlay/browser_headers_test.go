@@ -0,0 +1,214 @@
+package http_test
+
+import (
+	"slices"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	tls "github.com/refraction-networking/utls"
+)
+
+func TestBrowserHeaders(t *testing.T) {
+	tests := []struct {
+		id       tls.ClientHelloID
+		b        *BrowserHeaders
+		ua       string
+		secChUa  string
+		platform string
+	}{
+		{
+			id:       tls.HelloChrome_133,
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/133.0.0.0 Safari/537.36",
+			secChUa:  `"Not(A:Brand";v="99", "Google Chrome";v="133", "Chromium";v="133"`,
+			platform: `"Windows"`,
+		},
+		{
+			id:       tls.HelloChrome_131,
+			b:        &BrowserHeaders{Platform: "macOS"},
+			ua:       "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/131.0.0.0 Safari/537.36",
+			secChUa:  `"Google Chrome";v="131", "Chromium";v="131", "Not_A Brand";v="24"`,
+			platform: `"macOS"`,
+		},
+		{
+			id:       tls.HelloChrome_120_PQ,
+			b:        &BrowserHeaders{Platform: "Android"},
+			ua:       "Mozilla/5.0 (Linux; Android 10; K) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36",
+			secChUa:  `"Not_A Brand";v="8", "Chromium";v="120", "Google Chrome";v="120"`,
+			platform: `"Android"`,
+		},
+		{
+			id: tls.HelloChrome_83,
+			ua: "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/83.0.0.0 Safari/537.36",
+		},
+		{
+			id:       tls.HelloEdge_106,
+			ua:       "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/106.0.0.0 Safari/537.36 Edg/106.0.0.0",
+			secChUa:  `"Chromium";v="106", "Microsoft Edge";v="106", "Not;A=Brand";v="99"`,
+			platform: `"Windows"`,
+		},
+		{
+			id: tls.HelloFirefox_120,
+			b:  &BrowserHeaders{Platform: "Linux"},
+			ua: "Mozilla/5.0 (X11; Linux x86_64; rv:120.0) Gecko/20100101 Firefox/120.0",
+		},
+		{
+			id: tls.HelloSafari_16_0,
+			ua: "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.0 Safari/605.1.15",
+		},
+		{
+			id: tls.HelloIOS_11_1,
+			ua: "Mozilla/5.0 (iPhone; CPU iPhone OS 11_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/11.1 Mobile/15E148 Safari/604.1",
+		},
+	}
+	for _, tt := range tests {
+		h, err := tt.b.Header(tt.id)
+		if err != nil {
+			t.Errorf("%s: %v", tt.id.Str(), err)
+			continue
+		}
+		if got := h.Get("User-Agent"); got != tt.ua {
+			t.Errorf("%s: User-Agent = %q, want %q", tt.id.Str(), got, tt.ua)
+		}
+		if got := h.Get("Sec-Ch-Ua"); got != tt.secChUa {
+			t.Errorf("%s: sec-ch-ua = %q, want %q", tt.id.Str(), got, tt.secChUa)
+		}
+		if got := h.Get("Sec-Ch-Ua-Platform"); got != tt.platform {
+			t.Errorf("%s: sec-ch-ua-platform = %q, want %q", tt.id.Str(), got, tt.platform)
+		}
+		if h.Get("Accept") == "" || h.Get("Accept-Language") == "" || len(h[HeaderOrderKey]) == 0 {
+			t.Errorf("%s: missing Accept, Accept-Language or header order: %v", tt.id.Str(), h)
+		}
+	}
+
+	for _, id := range []tls.ClientHelloID{tls.HelloCustom, tls.HelloRandomized, tls.HelloGolang} {
+		if _, err := (*BrowserHeaders)(nil).Header(id); err == nil {
+			t.Errorf("%s: no error", id.Str())
+		}
+	}
+	if _, err := (&BrowserHeaders{Platform: "Plan 9"}).Header(tls.HelloChrome_133); err == nil {
+		t.Error("unknown platform: no error")
+	}
+
+	// The header order is the caller's to modify.
+	h, _ := (*BrowserHeaders)(nil).Header(tls.HelloChrome_133)
+	h[HeaderOrderKey][0] = "x-changed"
+	if h, _ = (*BrowserHeaders)(nil).Header(tls.HelloChrome_133); h[HeaderOrderKey][0] != "sec-ch-ua" {
+		t.Errorf("header order starts with %q after a caller modified it", h[HeaderOrderKey][0])
+	}
+}
+
+func TestTransportBrowserHeaders(t *testing.T) {
+	got := make(chan Header, 1)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		got <- r.Header
+	}))
+	defer ts.Close()
+
+	type mismatch struct{ key, got, want string }
+	var mismatches []mismatch
+	tr := &Transport{
+		ClientHelloSettings: ClientHelloSettings{HelloID: tls.HelloFirefox_120},
+		BrowserHeaders: &BrowserHeaders{
+			AcceptLanguage: "de-DE,de;q=0.8",
+			Inconsistent: func(req *Request, key, got, want string) {
+				mismatches = append(mismatches, mismatch{key, got, want})
+			},
+		},
+	}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req.Header.Set("Sec-Ch-Ua-Mobile", "?0")
+	req.Header.Set("Accept", "*/*")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	h := <-got
+
+	want, _ := (&BrowserHeaders{}).Header(tls.HelloFirefox_120)
+	if ua := h.Get("User-Agent"); ua != want.Get("User-Agent") {
+		t.Errorf("User-Agent = %q, want %q", ua, want.Get("User-Agent"))
+	}
+	if a := h.Get("Accept"); a != "*/*" {
+		t.Errorf("Accept = %q; the request's own value should be kept", a)
+	}
+	if l := h.Get("Accept-Language"); l != "de-DE,de;q=0.8" {
+		t.Errorf("Accept-Language = %q", l)
+	}
+	if !slices.Contains(h.Values("Accept-Encoding"), "gzip, deflate, br, zstd") {
+		t.Errorf("Accept-Encoding = %q; Transport default should be kept", h.Values("Accept-Encoding"))
+	}
+	if len(req.Header) != 2 {
+		t.Errorf("caller's request header was modified: %v", req.Header)
+	}
+	if wantM := []mismatch{{"Sec-Ch-Ua-Mobile", "?0", ""}}; !slices.Equal(mismatches, wantM) {
+		t.Errorf("Inconsistent calls = %v, want %v", mismatches, wantM)
+	}
+
+	// Header keys match whatever their case.
+	mismatches = nil
+	req, _ = NewRequest("GET", ts.URL, nil)
+	req.Header["user-agent"] = []string{"custom"}
+	resp, err = c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	h = <-got
+	if ua := h.Values("User-Agent"); !slices.Equal(ua, []string{"custom"}) {
+		t.Errorf("User-Agent = %q, want only the request's own", ua)
+	}
+	if wantM := []mismatch{{"User-Agent", "custom", want.Get("User-Agent")}}; !slices.Equal(mismatches, wantM) {
+		t.Errorf("Inconsistent calls = %v, want %v", mismatches, wantM)
+	}
+}
+
+func TestTransportBrowserHeadersClientHints(t *testing.T) {
+	got := make(chan Header, 1)
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		got <- r.Header
+	})
+	plain := httptest.NewServer(handler)
+	defer plain.Close()
+	secure := httptest.NewTLSServer(handler)
+	defer secure.Close()
+
+	tr := secure.Client().Transport.(*Transport)
+	tr.ClientHelloSettings = ClientHelloSettings{HelloID: tls.HelloChrome_133}
+	tr.BrowserHeaders = &BrowserHeaders{}
+	c := &Client{Transport: tr}
+
+	for _, tt := range []struct {
+		url   string
+		hints bool
+	}{
+		{plain.URL, false},
+		{secure.URL, true},
+	} {
+		resp, err := c.Get(tt.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		h := <-got
+		for _, k := range []string{"Sec-Ch-Ua", "Sec-Ch-Ua-Mobile", "Sec-Ch-Ua-Platform"} {
+			if _, ok := h[k]; ok != tt.hints {
+				t.Errorf("%s: %s sent = %v, want %v", tt.url, k, ok, tt.hints)
+			}
+		}
+		if h.Get("User-Agent") == "" {
+			t.Errorf("%s: no User-Agent", tt.url)
+		}
+	}
+
+	// A BrowserHeaders that cannot describe the browser fails the request.
+	tr.BrowserHeaders = &BrowserHeaders{Platform: "Plan 9"}
+	if resp, err := c.Get(secure.URL); err == nil {
+		resp.Body.Close()
+		t.Error("request with an unknown Platform succeeded")
+	}
+}
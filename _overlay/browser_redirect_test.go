@@ -0,0 +1,181 @@
+package http_test
+
+import (
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/cookiejar"
+	"github.com/dteh/dhttp/httptest"
+)
+
+// newRedirectServer returns a TLS server whose /redir endpoint redirects
+// to the "to" query parameter with status "code", optionally setting the
+// Referrer-Policy and Set-Cookie headers from "policy" and "cookie", and
+// whose /echo endpoint sends its request headers to got.
+func newRedirectServer(t *testing.T, got chan<- Header) *httptest.Server {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		q := r.URL.Query()
+		switch r.URL.Path {
+		case "/redir":
+			if p := q.Get("policy"); p != "" {
+				w.Header().Set("Referrer-Policy", p)
+			}
+			if c := q.Get("cookie"); c != "" {
+				w.Header().Add("Set-Cookie", c)
+			}
+			code, _ := strconv.Atoi(q.Get("code"))
+			Redirect(w, r, q.Get("to"), code)
+		case "/echo":
+			got <- r.Header.Clone()
+		}
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+func redir(base, to string, code int, extra ...string) string {
+	q := url.Values{"to": {to}, "code": {strconv.Itoa(code)}}
+	for i := 0; i+1 < len(extra); i += 2 {
+		q.Set(extra[i], extra[i+1])
+	}
+	return base + "/redir?" + q.Encode()
+}
+
+func TestClientBrowserRedirects(t *testing.T) {
+	got := make(chan Header, 1)
+	a := newRedirectServer(t, got)
+	b := newRedirectServer(t, got)
+
+	tests := []struct {
+		name   string
+		method string
+		url    string
+		header Header
+		want   map[string]string // "" means absent
+	}{
+		{
+			name:   "referer_from_initiator",
+			method: "GET",
+			url:    redir(a.URL, b.URL+"/echo", 302),
+			header: Header{"Referer": {"https://ref.example/page?q=1#frag"}},
+			want:   map[string]string{"Referer": "https://ref.example/"},
+		},
+		{
+			name:   "referer_policy_from_response",
+			method: "GET",
+			url:    redir(a.URL, b.URL+"/echo", 302, "policy", "foo, unsafe-url"),
+			header: Header{"Referer": {"https://ref.example/page?q=1#frag"}},
+			want:   map[string]string{"Referer": "https://ref.example/page?q=1"},
+		},
+		{
+			name:   "no_initial_referer",
+			method: "GET",
+			url:    redir(a.URL, a.URL+"/echo", 302),
+			want:   map[string]string{"Referer": ""},
+		},
+		{
+			name:   "origin_kept",
+			method: "POST",
+			url:    redir(a.URL, b.URL+"/echo", 307),
+			header: Header{"Origin": {a.URL}, "Sec-Fetch-Site": {"same-origin"}},
+			want:   map[string]string{"Origin": a.URL, "Sec-Fetch-Site": "same-site"},
+		},
+		{
+			name:   "origin_tainted",
+			method: "POST",
+			url:    redir(a.URL, redir(b.URL, a.URL+"/echo", 307), 307),
+			header: Header{"Origin": {a.URL}},
+			want:   map[string]string{"Origin": "null"},
+		},
+		{
+			name:   "origin_dropped_for_get",
+			method: "POST",
+			url:    redir(a.URL, a.URL+"/echo", 303),
+			header: Header{"Origin": {a.URL}, "Sec-Fetch-Site": {"same-origin"}},
+			want:   map[string]string{"Origin": "", "Sec-Fetch-Site": "same-origin"},
+		},
+		{
+			name:   "sec_fetch_site_none_kept",
+			method: "GET",
+			url:    redir(a.URL, b.URL+"/echo", 302),
+			header: Header{"Sec-Fetch-Site": {"none"}, "Sec-Fetch-Mode": {"navigate"}},
+			want:   map[string]string{"Sec-Fetch-Site": "none", "Sec-Fetch-Mode": "navigate"},
+		},
+		{
+			name:   "authorization_cross_origin",
+			method: "GET",
+			url:    redir(a.URL, redir(b.URL, a.URL+"/echo", 302), 302),
+			header: Header{"Authorization": {"Bearer x"}},
+			want:   map[string]string{"Authorization": ""},
+		},
+		{
+			name:   "authorization_same_origin",
+			method: "GET",
+			url:    redir(a.URL, a.URL+"/echo", 302),
+			header: Header{"Authorization": {"Bearer x"}},
+			want:   map[string]string{"Authorization": "Bearer x"},
+		},
+		{
+			name:   "lowercase_keys",
+			method: "POST",
+			url:    redir(a.URL, redir(b.URL, a.URL+"/echo", 307), 307),
+			header: Header{
+				"referer":        {"https://ref.example/page?q=1"},
+				"origin":         {a.URL},
+				"authorization":  {"Bearer x"},
+				"sec-fetch-site": {"same-origin"},
+			},
+			want: map[string]string{
+				"Referer":        "https://ref.example/",
+				"Origin":         "null",
+				"Authorization":  "",
+				"Sec-Fetch-Site": "same-site",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := &Client{Transport: a.Client().Transport, BrowserRedirects: &BrowserRedirects{}}
+			req, _ := NewRequest(tt.method, tt.url, nil)
+			if tt.method == "POST" {
+				req, _ = NewRequest(tt.method, tt.url, strings.NewReader("x"))
+			}
+			for k, vv := range tt.header {
+				req.Header[k] = vv
+			}
+			req.Header[HeaderOrderKey] = []string{"origin", "referer", "sec-fetch-site"}
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			h := <-got
+			for k, want := range tt.want {
+				if g := strings.Join(h.Values(k), ", "); g != want {
+					t.Errorf("%s = %q, want %q", k, g, want)
+				}
+			}
+		})
+	}
+}
+
+func TestClientBrowserRedirectsCookieOrder(t *testing.T) {
+	got := make(chan Header, 1)
+	a := newRedirectServer(t, got)
+
+	jar, _ := cookiejar.New(nil)
+	c := &Client{Transport: a.Client().Transport, Jar: jar, BrowserRedirects: &BrowserRedirects{}}
+	req, _ := NewRequest("GET", redir(a.URL, a.URL+"/echo", 302, "cookie", "c=9"), nil)
+	req.Header.Set("Cookie", "b=1; a=2; c=3")
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if g, want := (<-got).Get("Cookie"), "b=1; a=2; c=9"; g != want {
+		t.Errorf("Cookie = %q, want %q", g, want)
+	}
+}
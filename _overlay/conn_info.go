@@ -0,0 +1,82 @@
+package http
+
+import (
+	"net"
+	"net/url"
+)
+
+// ConnInfo describes the connection a client response was received on,
+// as Response.Conn: how the Transport reached the server, and through
+// which proxy. Responses received on the same connection share one
+// ConnInfo.
+type ConnInfo struct {
+	// Proxy is the proxy the connection goes through, as chosen by
+	// Transport.Proxy or, for a proxy chain, the last proxy of the
+	// chain. It is nil without a proxy. Passwords are removed from its
+	// userinfo and from those of ProxyChain.
+	Proxy *url.URL
+
+	// ProxyChain are the proxies before Proxy, from
+	// Transport.ProxyChain.
+	ProxyChain []*url.URL
+
+	// ProxyConnectStatus and ProxyConnectHeader are the status code
+	// and header of Proxy's response to the CONNECT request that opened
+	// the tunnel to the server. They are zero when there was none, as
+	// for SOCKS proxies and for requests an "http" or "https" proxy
+	// forwards.
+	ProxyConnectStatus int
+	ProxyConnectHeader Header
+
+	conn net.Conn
+}
+
+// LocalAddr returns the local address of the network connection the
+// Transport dialed.
+func (ci *ConnInfo) LocalAddr() net.Addr {
+	return ci.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote address of the network connection the
+// Transport dialed: the first proxy's when the connection goes through
+// proxies.
+func (ci *ConnInfo) RemoteAddr() net.Addr {
+	return ci.conn.RemoteAddr()
+}
+
+// newConnInfo returns the ConnInfo of a connection of cm on conn, set
+// up with the CONNECT response connectResp, if any.
+func newConnInfo(cm *connectMethod, conn net.Conn, connectResp *Response) *ConnInfo {
+	ci := &ConnInfo{
+		Proxy: withoutPassword(cm.proxyURL),
+		conn:  conn,
+	}
+	for _, hop := range cm.proxyChain {
+		ci.ProxyChain = append(ci.ProxyChain, withoutPassword(hop))
+	}
+	if connectResp != nil {
+		ci.ProxyConnectStatus = connectResp.StatusCode
+		ci.ProxyConnectHeader = connectResp.Header
+	}
+	return ci
+}
+
+// withoutPassword returns u, or a copy of u without the password of its
+// userinfo.
+func withoutPassword(u *url.URL) *url.URL {
+	if u == nil || u.User == nil {
+		return u
+	}
+	if _, ok := u.User.Password(); !ok {
+		return u
+	}
+	u2 := *u
+	u2.User = url.User(u.User.Username())
+	return &u2
+}
+
+func (t *Transport) connInfoOf(c net.Conn) *ConnInfo {
+	v, _ := t.connInfos.Load(c)
+	ci, _ := v.(*ConnInfo)
+	return ci
+}
@@ -0,0 +1,102 @@
+package http_test
+
+import (
+	"io"
+	"net/url"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	tls "github.com/refraction-networking/utls"
+)
+
+func TestResponseConnInfo(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "h1"
+		if h2 {
+			name = "h2"
+		}
+		t.Run(name, func(t *testing.T) {
+			origin := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+				io.WriteString(w, r.Proto)
+			}))
+			origin.EnableHTTP2 = h2
+			origin.StartTLS()
+			defer origin.Close()
+
+			proxies := map[string]*url.URL{
+				"a": newTestProxy(t, "a", false, nil).URL,
+				"b": newTestProxy(t, "b", false, nil).URL,
+			}
+			for _, u := range proxies {
+				u.User = url.UserPassword("u", "secret")
+			}
+			tr := &Transport{
+				TLSClientConfig:   &tls.Config{InsecureSkipVerify: true},
+				ForceAttemptHTTP2: h2,
+				Proxy: func(r *Request) (*url.URL, error) {
+					return proxies[r.Header.Get("X-Use")], nil
+				},
+			}
+			defer tr.CloseIdleConnections()
+			get := func(proxy string) *Response {
+				t.Helper()
+				req, _ := NewRequest("GET", origin.URL, nil)
+				req.Header.Set("X-Use", proxy)
+				resp, err := tr.RoundTrip(req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				io.ReadAll(resp.Body)
+				resp.Body.Close()
+				if resp.ProtoMajor == 2 != h2 {
+					t.Fatalf("response over %s", resp.Proto)
+				}
+				return resp
+			}
+
+			first := get("a")
+			ci := first.Conn
+			if ci == nil {
+				t.Fatal("Response.Conn is nil")
+			}
+			if ci.Proxy == nil || ci.Proxy.Host != proxies["a"].Host || ci.Proxy.User.String() != "u" {
+				t.Errorf("Conn.Proxy = %v, want %v without its password", ci.Proxy, proxies["a"])
+			}
+			if ci.ProxyConnectStatus != StatusOK || ci.ProxyConnectHeader.Get("X-Proxy") != "a" {
+				t.Errorf("CONNECT response %d %v, want 200 from a", ci.ProxyConnectStatus, ci.ProxyConnectHeader)
+			}
+			if ci.RemoteAddr().String() != proxies["a"].Host || ci.LocalAddr() == nil {
+				t.Errorf("Conn addresses %v -> %v, want a connection to %s", ci.LocalAddr(), ci.RemoteAddr(), proxies["a"].Host)
+			}
+			if again := get("a"); again.Conn != ci {
+				t.Errorf("reused connection reported %+v, want %+v", again.Conn, ci)
+			}
+
+			ci = get("b").Conn
+			if ci == nil || ci.Proxy == nil || ci.Proxy.Host != proxies["b"].Host ||
+				ci.ProxyConnectHeader.Get("X-Proxy") != "b" || ci.RemoteAddr().String() != proxies["b"].Host {
+				t.Errorf("request through b reported %+v", ci)
+			}
+
+			ci = get("").Conn
+			if ci == nil || ci.Proxy != nil || ci.ProxyConnectStatus != 0 || ci.RemoteAddr().String() != origin.Listener.Addr().String() {
+				t.Errorf("request without proxy reported %+v", ci)
+			}
+		})
+	}
+}
+
+func TestResponseConnInfoForwarded(t *testing.T) {
+	proxy := newTestProxy(t, "a", false, nil).URL
+	tr := &Transport{Proxy: ProxyURL(proxy)}
+	defer tr.CloseIdleConnections()
+	resp, err := (&Client{Transport: tr}).Get("http://example.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if ci := resp.Conn; ci == nil || ci.Proxy.String() != proxy.String() || ci.ProxyConnectStatus != 0 || ci.ProxyConnectHeader != nil {
+		t.Errorf("forwarded request reported %+v, want proxy %v and no CONNECT response", ci, proxy)
+	}
+}
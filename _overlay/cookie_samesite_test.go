@@ -0,0 +1,117 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/cookiejar"
+	"github.com/dteh/dhttp/httptest"
+)
+
+func TestClientSameSite(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/login" {
+			SetCookie(w, &Cookie{Name: "lax", Value: "1", SameSite: SameSiteLaxMode})
+			SetCookie(w, &Cookie{Name: "strict", Value: "1", SameSite: SameSiteStrictMode})
+		}
+		io.WriteString(w, r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(&cookiejar.Options{EnforceSameSite: true})
+	c := ts.Client()
+	c.Jar = jar
+
+	do := func(method, path, initiator string, typ RequestType) string {
+		t.Helper()
+		ctx := WithInitiator(context.Background(), initiator, typ)
+		req, _ := NewRequestWithContext(ctx, method, ts.URL+path, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	// A cross-site subresource cannot set Lax or Strict cookies.
+	do("GET", "/login", "https://other.example", RequestSubresource)
+	if got := do("GET", "/", "", RequestNavigation); got != "" {
+		t.Fatalf("cookies set by a cross-site subresource: %q", got)
+	}
+
+	do("GET", "/login", "", RequestNavigation)
+	for _, tt := range []struct {
+		method, initiator string
+		typ               RequestType
+		want              string
+	}{
+		{"GET", "", RequestNavigation, "lax=1; strict=1"},
+		{"GET", "https://other.example", RequestNavigation, "lax=1"},
+		{"POST", "https://other.example", RequestNavigation, ""},
+		{"GET", "https://other.example", RequestSubresource, ""},
+	} {
+		if got := do(tt.method, "/", tt.initiator, tt.typ); got != tt.want {
+			t.Errorf("%s from %q (type %d): Cookie = %q, want %q", tt.method, tt.initiator, tt.typ, got, tt.want)
+		}
+	}
+}
+
+func TestClientSameSiteRedirect(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/login":
+			SetCookie(w, &Cookie{Name: "lax", Value: "1", SameSite: SameSiteLaxMode})
+			SetCookie(w, &Cookie{Name: "strict", Value: "1", SameSite: SameSiteStrictMode})
+		case "/redir":
+			Redirect(w, r, r.URL.Query().Get("to"), StatusFound)
+			return
+		}
+		io.WriteString(w, r.Header.Get("Cookie"))
+	}))
+	defer ts.Close()
+
+	// ts serves both sites: example.com and 127.0.0.1.
+	tr := ts.Client().Transport.(*Transport)
+	tr.DialContext = func(ctx context.Context, network, _ string) (net.Conn, error) {
+		return (&net.Dialer{}).DialContext(ctx, network, ts.Listener.Addr().String())
+	}
+	_, port, _ := net.SplitHostPort(ts.Listener.Addr().String())
+	a, b := "https://example.com:"+port, ts.URL
+	redir := func(from, to string) string {
+		return from + "/redir?" + url.Values{"to": {to}}.Encode()
+	}
+
+	jar, _ := cookiejar.New(&cookiejar.Options{EnforceSameSite: true})
+	c := &Client{Transport: tr, Jar: jar}
+	do := func(u string) string {
+		t.Helper()
+		ctx := WithInitiator(context.Background(), "", RequestNavigation)
+		req, _ := NewRequestWithContext(ctx, "GET", u, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return string(b)
+	}
+
+	do(a + "/login")
+	for _, tt := range []struct {
+		name, url, want string
+	}{
+		{"direct", a + "/", "lax=1; strict=1"},
+		{"same_site", redir(a, a+"/"), "lax=1; strict=1"},
+		{"through_other_site", redir(a, redir(b, a+"/")), "lax=1"},
+	} {
+		if got := do(tt.url); got != tt.want {
+			t.Errorf("%s: Cookie = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package cookiejar
+
+import (
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	http "github.com/dteh/dhttp"
+)
+
+// CookiesFor is like Cookies for a request made by a document whose
+// top-level site is topLevelSite, such as an iframe or a subresource of
+// another site's page. Besides the unpartitioned cookies, it returns the
+// partitioned (CHIPS) cookies set under that site only. topLevelSite is
+// an origin or URL such as "https://example.com"; a bare host is taken
+// to be https. If it is empty, u itself is the top-level site.
+//
+// CookiesFor implements [http.PartitionedCookieJar].
+func (j *Jar) CookiesFor(u *url.URL, topLevelSite string) []*http.Cookie {
+	return j.cookiesFor(u, &http.CookieRequest{TopLevelSite: topLevelSite}, time.Now())
+}
+
+// SetCookiesFor is like SetCookies for a response to a request made by
+// a document whose top-level site is topLevelSite, as for CookiesFor.
+// Cookies with the Partitioned attribute are stored in the partition of
+// that site. As in browsers, they are rejected unless they also have the
+// Secure attribute and u is a secure origin.
+//
+// SetCookiesFor implements [http.PartitionedCookieJar].
+func (j *Jar) SetCookiesFor(u *url.URL, topLevelSite string, cookies []*http.Cookie) {
+	j.setCookiesFor(u, &http.CookieRequest{TopLevelSite: topLevelSite}, cookies, time.Now())
+}
+
+// partitionKey returns the schemeful site, such as "https://example.com",
+// that partitions the cookies of requests to scheme://host made under
+// topLevelSite. It returns "" if topLevelSite is not a valid site.
+func (j *Jar) partitionKey(topLevelSite, scheme, host string) string {
+	if topLevelSite == "" {
+		return strings.ToLower(scheme) + "://" + jarKey(host, j.psList)
+	}
+	return j.site(topLevelSite)
+}
+
+// site returns the schemeful site of s, an origin or URL such as
+// "https://www.example.com/page" or a bare host taken to be https, or ""
+// if s is not valid.
+func (j *Jar) site(s string) string {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	u, err := url.Parse(s)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	h, err := canonicalHost(u.Host)
+	if err != nil || h == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme) + "://" + jarKey(h, j.psList)
+}
+
+// topLevelSite returns the TopLevelSite of r, which may be nil.
+func topLevelSite(r *http.CookieRequest) string {
+	if r == nil {
+		return ""
+	}
+	return r.TopLevelSite
+}
+
+// isSecureOrigin reports whether scheme://host is a secure origin:
+// https, or a loopback host as in secureMatch.
+func isSecureOrigin(scheme, host string) bool {
+	if scheme == "https" || isLocalhost(host) {
+		return true
+	}
+	ip, err := netip.ParseAddr(host)
+	return err == nil && ip.IsLoopback()
+}
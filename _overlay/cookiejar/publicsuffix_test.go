@@ -0,0 +1,30 @@
+package cookiejar
+
+import (
+	"testing"
+
+	http "github.com/dteh/dhttp"
+)
+
+func TestUseBuiltinPublicSuffixList(t *testing.T) {
+	u := mustParseURL("https://www.example.co.uk/")
+	cookies := []*http.Cookie{
+		{Name: "suffix", Value: "1", Domain: "co.uk"},
+		{Name: "site", Value: "2", Domain: "example.co.uk"},
+	}
+	for _, tt := range []struct {
+		o    *Options
+		want string
+	}{
+		{nil, "suffix=1 site=2"},
+		{&Options{}, "suffix=1 site=2"},
+		{&Options{UseBuiltinPublicSuffixList: true}, "site=2"},
+		{&Options{UseBuiltinPublicSuffixList: true, PublicSuffixList: testPSL{}}, "site=2"},
+	} {
+		jar, _ := New(tt.o)
+		jar.SetCookies(u, cookies)
+		if got := cookiesToString(jar.Cookies(u)); got != tt.want {
+			t.Errorf("New(%+v): Cookies = %q, want %q", tt.o, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package cookiejar
+
+import (
+	"net/url"
+	"strings"
+	"time"
+
+	http "github.com/dteh/dhttp"
+)
+
+// laxAllowingUnsafeWindow is how long after its creation a cookie without
+// a SameSite attribute is still sent on cross-site top-level navigations
+// with unsafe methods, such as a POST, as in Chromium's
+// "Lax-allowing-unsafe" mode.
+const laxAllowingUnsafeWindow = 2 * time.Minute
+
+// CookiesForRequest is like CookiesFor for a request described by r. If
+// the jar was created with Options.EnforceSameSite and r makes the
+// request cross-site, it leaves out the cookies a browser would:
+//
+//   - SameSite=Strict cookies, always;
+//   - SameSite=Lax cookies, unless r is a top-level navigation with a
+//     safe method such as GET;
+//   - cookies without a SameSite attribute, which count as Lax, except
+//     that a top-level navigation with an unsafe method such as POST
+//     still carries them for two minutes after they were created.
+//
+// SameSite=None cookies are sent to any request. A request is cross-site
+// if r.Initiator, or for subresources r.TopLevelSite, is not the same
+// schemeful site as u, or if r.CrossSiteRedirect is set.
+//
+// CookiesForRequest implements [http.SameSiteCookieJar].
+func (j *Jar) CookiesForRequest(u *url.URL, r *http.CookieRequest) []*http.Cookie {
+	return j.cookiesFor(u, r, time.Now())
+}
+
+// SetCookiesForRequest is like SetCookiesFor for the response to a
+// request described by r. If the jar was created with
+// Options.EnforceSameSite, it ignores SameSite=None cookies that are not
+// Secure and, if r is a cross-site request other than a top-level
+// navigation, all cookies but SameSite=None ones, as browsers do.
+//
+// SetCookiesForRequest implements [http.SameSiteCookieJar].
+func (j *Jar) SetCookiesForRequest(u *url.URL, r *http.CookieRequest, cookies []*http.Cookie) {
+	j.setCookiesFor(u, r, cookies, time.Now())
+}
+
+// sameSiteContext is what the SameSite rules need to know about a
+// request. Its zero value enforces nothing.
+type sameSiteContext struct {
+	enforce    bool // Options.EnforceSameSite and the request is described
+	crossSite  bool
+	navigation bool
+	safeMethod bool
+}
+
+// sameSiteContext returns the sameSiteContext of a request to
+// scheme://host described by r, which may be nil.
+func (j *Jar) sameSiteContext(scheme, host string, r *http.CookieRequest) sameSiteContext {
+	if !j.enforceSameSite || r == nil {
+		return sameSiteContext{}
+	}
+	target := strings.ToLower(scheme) + "://" + jarKey(host, j.psList)
+	sc := sameSiteContext{
+		enforce:    true,
+		navigation: r.Type == http.RequestNavigation,
+	}
+	if r.Initiator != "" && j.site(r.Initiator) != target {
+		sc.crossSite = true
+	}
+	if !sc.navigation && r.TopLevelSite != "" && j.site(r.TopLevelSite) != target {
+		sc.crossSite = true
+	}
+	if r.CrossSiteRedirect {
+		sc.crossSite = true
+	}
+	switch strings.ToUpper(r.Method) {
+	case "", "GET", "HEAD", "OPTIONS", "TRACE":
+		sc.safeMethod = true
+	}
+	return sc
+}
+
+// sameSiteAllows reports whether e may be sent on a request in sc.
+func (e *entry) sameSiteAllows(sc sameSiteContext, now time.Time) bool {
+	if !sc.crossSite {
+		return true
+	}
+	switch e.SameSite {
+	case "SameSite=None":
+		return true
+	case "SameSite=Strict":
+		return false
+	case "SameSite=Lax":
+		return sc.navigation && sc.safeMethod
+	}
+	// No (valid) SameSite attribute: Lax by default.
+	return sc.navigation && (sc.safeMethod || now.Sub(e.Creation) <= laxAllowingUnsafeWindow)
+}
+
+// allowsSet reports whether c may be set by the response to a request in
+// sc.
+func (sc sameSiteContext) allowsSet(c *http.Cookie) bool {
+	if !sc.enforce {
+		return true
+	}
+	if c.SameSite == http.SameSiteNoneMode {
+		return c.Secure
+	}
+	return !sc.crossSite || sc.navigation
+}
@@ -0,0 +1,83 @@
+package cookiejar
+
+import (
+	"testing"
+	"time"
+
+	http "github.com/dteh/dhttp"
+)
+
+func TestSameSiteEnforcement(t *testing.T) {
+	u := mustParseURL("https://www.example.com/")
+	cookies := []*http.Cookie{
+		{Name: "strict", Value: "1", SameSite: http.SameSiteStrictMode},
+		{Name: "lax", Value: "1", SameSite: http.SameSiteLaxMode},
+		{Name: "none", Value: "1", SameSite: http.SameSiteNoneMode, Secure: true},
+		{Name: "unset", Value: "1"},
+	}
+	nav := func(initiator, method string) *http.CookieRequest {
+		return &http.CookieRequest{Method: method, Initiator: initiator, Type: http.RequestNavigation}
+	}
+	sub := func(initiator, top string) *http.CookieRequest {
+		return &http.CookieRequest{Method: "GET", Initiator: initiator, Type: http.RequestSubresource, TopLevelSite: top}
+	}
+
+	tests := []struct {
+		name    string
+		enforce bool
+		r       *http.CookieRequest
+		age     time.Duration
+		want    string
+	}{
+		{"off", false, sub("https://evil.com", ""), time.Hour, "strict=1 lax=1 none=1 unset=1"},
+		{"no_request", true, nil, time.Hour, "strict=1 lax=1 none=1 unset=1"},
+		{"user_initiated", true, nav("", "GET"), time.Hour, "strict=1 lax=1 none=1 unset=1"},
+		{"same_site_nav", true, nav("https://static.example.com", "POST"), time.Hour, "strict=1 lax=1 none=1 unset=1"},
+		{"same_site_sub", true, sub("https://example.com/x", ""), time.Hour, "strict=1 lax=1 none=1 unset=1"},
+		{"schemeful", true, nav("http://example.com", "GET"), time.Hour, "lax=1 none=1 unset=1"},
+		{"cross_nav_get", true, nav("https://other.com", "GET"), time.Hour, "lax=1 none=1 unset=1"},
+		{"cross_nav_post", true, nav("https://other.com", "POST"), time.Hour, "none=1"},
+		{"cross_nav_post_new", true, nav("https://other.com", "POST"), time.Minute, "none=1 unset=1"},
+		{"cross_sub", true, sub("https://other.com", ""), time.Minute, "none=1"},
+		{"cross_top_level", true, sub("https://example.com", "https://other.com"), time.Hour, "none=1"},
+		{"cross_site_redirect", true, &http.CookieRequest{Method: "GET", Initiator: "https://example.com", CrossSiteRedirect: true}, time.Hour, "lax=1 none=1 unset=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jar, _ := New(&Options{PublicSuffixList: testPSL{}, EnforceSameSite: tt.enforce})
+			jar.setCookies(u, cookies, tNow)
+			got := cookiesToString(jar.cookiesFor(u, tt.r, tNow.Add(tt.age)))
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameSiteSetCookies(t *testing.T) {
+	u := mustParseURL("https://www.example.com/")
+	cookies := []*http.Cookie{
+		{Name: "lax", Value: "1", SameSite: http.SameSiteLaxMode},
+		{Name: "none", Value: "1", SameSite: http.SameSiteNoneMode, Secure: true},
+		{Name: "insecure_none", Value: "1", SameSite: http.SameSiteNoneMode},
+		{Name: "unset", Value: "1"},
+	}
+	tests := []struct {
+		name string
+		r    *http.CookieRequest
+		want string
+	}{
+		{"same_site", &http.CookieRequest{Initiator: "https://example.com", Type: http.RequestSubresource}, "lax=1 none=1 unset=1"},
+		{"cross_nav", &http.CookieRequest{Initiator: "https://other.com", Type: http.RequestNavigation}, "lax=1 none=1 unset=1"},
+		{"cross_sub", &http.CookieRequest{Initiator: "https://other.com", Type: http.RequestSubresource}, "none=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jar, _ := New(&Options{PublicSuffixList: testPSL{}, EnforceSameSite: true})
+			jar.setCookiesFor(u, tt.r, cookies, tNow)
+			if got := cookiesToString(jar.cookies(u, tNow)); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,577 @@
+package cookiejar
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	http "github.com/dteh/dhttp"
+)
+
+// An Entry is a cookie held by a Jar, with the attributes that RFC 6265
+// section 5.3 stores alongside it. Entries are returned by All and
+// ForDomain and accepted by Add, so that a jar can be inspected, saved
+// and restored.
+type Entry struct {
+	Name   string
+	Value  string
+	Quoted bool // Value was enclosed in double quotes
+
+	// Domain is the host that set a host-only cookie, or the cookie's
+	// Domain attribute without its leading dot.
+	Domain   string
+	HostOnly bool
+	Path     string
+
+	Secure   bool
+	HttpOnly bool
+	SameSite http.SameSite
+
+	// Partitioned reports a CHIPS cookie, which is only sent to requests
+	// made under the top-level site PartitionKey, such as
+	// "https://example.com". An Entry given to Add with Partitioned set
+	// and no PartitionKey is put in the partition of its own Domain.
+	Partitioned  bool
+	PartitionKey string
+
+	// Expires is the zero Time for session cookies.
+	Expires    time.Time
+	Creation   time.Time
+	LastAccess time.Time
+}
+
+// entry returns e in the internal representation of j, or an error if
+// e cannot be stored.
+func (j *Jar) entry(e *Entry, now time.Time) (entry, error) {
+	domain, err := canonicalHost(strings.TrimPrefix(e.Domain, "."))
+	if err != nil {
+		return entry{}, fmt.Errorf("cookiejar: entry %q: %v", e.Name, err)
+	}
+	if e.Name == "" || domain == "" {
+		return entry{}, fmt.Errorf("cookiejar: entry %q for domain %q: missing name or domain", e.Name, e.Domain)
+	}
+	path := e.Path
+	if path == "" || path[0] != '/' {
+		path = "/"
+	}
+	ie := entry{
+		Name:        e.Name,
+		Value:       e.Value,
+		Quoted:      e.Quoted,
+		Domain:      domain,
+		Path:        path,
+		Secure:      e.Secure,
+		HttpOnly:    e.HttpOnly,
+		HostOnly:    e.HostOnly || isIP(domain),
+		Partitioned: e.Partitioned,
+		Persistent:  !e.Expires.IsZero(),
+		Expires:     e.Expires,
+		Creation:    e.Creation,
+		LastAccess:  e.LastAccess,
+	}
+	if !ie.Persistent {
+		ie.Expires = endOfTime
+	}
+	if ie.Creation.IsZero() {
+		ie.Creation = now
+	}
+	if ie.LastAccess.IsZero() {
+		ie.LastAccess = ie.Creation
+	}
+	if e.Partitioned || e.PartitionKey != "" {
+		if !e.Secure {
+			return entry{}, fmt.Errorf("cookiejar: entry %q: partitioned but not secure", e.Name)
+		}
+		ie.Partitioned = true
+		ie.PartitionKey = j.partitionKey(e.PartitionKey, "https", domain)
+		if ie.PartitionKey == "" {
+			return entry{}, fmt.Errorf("cookiejar: entry %q: bad partition key %q", e.Name, e.PartitionKey)
+		}
+	}
+	switch e.SameSite {
+	case http.SameSiteDefaultMode:
+		ie.SameSite = "SameSite"
+	case http.SameSiteStrictMode:
+		ie.SameSite = "SameSite=Strict"
+	case http.SameSiteLaxMode:
+		ie.SameSite = "SameSite=Lax"
+	case http.SameSiteNoneMode:
+		ie.SameSite = "SameSite=None"
+	}
+	return ie, nil
+}
+
+// export returns e as an Entry.
+func (e *entry) export() Entry {
+	x := Entry{
+		Name:         e.Name,
+		Value:        e.Value,
+		Quoted:       e.Quoted,
+		Domain:       e.Domain,
+		HostOnly:     e.HostOnly,
+		Path:         e.Path,
+		Secure:       e.Secure,
+		HttpOnly:     e.HttpOnly,
+		Partitioned:  e.Partitioned,
+		PartitionKey: e.PartitionKey,
+		Creation:     e.Creation,
+		LastAccess:   e.LastAccess,
+	}
+	if e.Persistent {
+		x.Expires = e.Expires
+	}
+	switch e.SameSite {
+	case "SameSite":
+		x.SameSite = http.SameSiteDefaultMode
+	case "SameSite=Strict":
+		x.SameSite = http.SameSiteStrictMode
+	case "SameSite=Lax":
+		x.SameSite = http.SameSiteLaxMode
+	case "SameSite=None":
+		x.SameSite = http.SameSiteNoneMode
+	}
+	return x
+}
+
+// All returns the unexpired cookies in the jar, ordered by domain, then
+// path, then creation.
+func (j *Jar) All() []Entry {
+	return j.all("", time.Now())
+}
+
+// ForDomain returns the unexpired cookies whose Domain is domain or one
+// of its subdomains, in the order of All. It lists what a site and its
+// subdomains have set, not what would be sent to it: a cookie for
+// example.com is not returned by ForDomain("www.example.com").
+func (j *Jar) ForDomain(domain string) []Entry {
+	domain, err := canonicalHost(strings.TrimPrefix(domain, "."))
+	if err != nil || domain == "" {
+		return nil
+	}
+	return j.all(domain, time.Now())
+}
+
+// all is like All but takes the current time as a parameter and, if
+// domain is not empty, returns only the cookies of domain and its
+// subdomains.
+func (j *Jar) all(domain string, now time.Time) []Entry {
+	j.mu.Lock()
+	var selected []entry
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			if domain != "" && e.Domain != domain && !hasDotSuffix(e.Domain, domain) {
+				continue
+			}
+			selected = append(selected, e)
+		}
+	}
+	j.mu.Unlock()
+
+	slices.SortFunc(selected, func(a, b entry) int {
+		if r := cmp.Compare(a.Domain, b.Domain); r != 0 {
+			return r
+		}
+		if r := cmp.Compare(a.Path, b.Path); r != 0 {
+			return r
+		}
+		return cmp.Compare(a.seqNum, b.seqNum)
+	})
+	entries := make([]Entry, len(selected))
+	for i := range selected {
+		entries[i] = selected[i].export()
+	}
+	return entries
+}
+
+// Add stores entries in the jar, replacing cookies with the same domain,
+// path, name and partition. Unlike SetCookies it does not check that a domain may
+// set the cookie; it is meant for restoring entries obtained from All or
+// one of the Read methods. Entries that have already expired are
+// skipped. If any entry is invalid, Add reports an error and stores
+// none of them.
+func (j *Jar) Add(entries ...Entry) error {
+	return j.add(entries, time.Now())
+}
+
+// add is like Add but takes the current time as a parameter.
+func (j *Jar) add(entries []Entry, now time.Time) error {
+	ies := make([]entry, 0, len(entries))
+	for i := range entries {
+		e, err := j.entry(&entries[i], now)
+		if err != nil {
+			return err
+		}
+		if e.Persistent && !e.Expires.After(now) {
+			continue
+		}
+		ies = append(ies, e)
+	}
+	// Keep the relative order of entries created at the same time.
+	slices.SortStableFunc(ies, func(a, b entry) int {
+		return a.Creation.Compare(b.Creation)
+	})
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.entries == nil {
+		j.entries = make(map[string]map[string]entry)
+	}
+	for _, e := range ies {
+		key := jarKey(e.Domain, j.psList)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]entry)
+			j.entries[key] = submap
+		}
+		id := e.id()
+		if old, ok := submap[id]; ok {
+			e.seqNum = old.seqNum
+		} else {
+			e.seqNum = j.nextSeqNum
+			j.nextSeqNum++
+		}
+		submap[id] = e
+	}
+	return nil
+}
+
+// Delete removes the cookie with the given domain, path and name, as
+// reported in an Entry, from every partition, and reports whether it was
+// present.
+func (j *Jar) Delete(domain, path, name string) bool {
+	domain, err := canonicalHost(strings.TrimPrefix(domain, "."))
+	if err != nil {
+		return false
+	}
+	key := jarKey(domain, j.psList)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	submap := j.entries[key]
+	deleted := false
+	for id, e := range submap {
+		if e.Domain == domain && e.Path == path && e.Name == name {
+			delete(submap, id)
+			deleted = true
+		}
+	}
+	if deleted && len(submap) == 0 {
+		delete(j.entries, key)
+	}
+	return deleted
+}
+
+// Clear removes all cookies from the jar.
+func (j *Jar) Clear() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.entries = make(map[string]map[string]entry)
+}
+
+// jsonEntry is the JSON form of an Entry.
+type jsonEntry struct {
+	Name         string     `json:"name"`
+	Value        string     `json:"value"`
+	Quoted       bool       `json:"quoted,omitempty"`
+	Domain       string     `json:"domain"`
+	HostOnly     bool       `json:"hostOnly"`
+	Path         string     `json:"path"`
+	Secure       bool       `json:"secure"`
+	HttpOnly     bool       `json:"httpOnly"`
+	SameSite     string     `json:"sameSite,omitempty"`
+	Partitioned  bool       `json:"partitioned,omitempty"`
+	PartitionKey string     `json:"partitionKey,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	Creation     time.Time  `json:"creation"`
+	LastAccess   time.Time  `json:"lastAccess"`
+}
+
+// sameSiteNames maps the SameSite modes to the names used by the JSON
+// and HAR formats.
+var sameSiteNames = map[http.SameSite]string{
+	http.SameSiteDefaultMode: "Default",
+	http.SameSiteLaxMode:     "Lax",
+	http.SameSiteStrictMode:  "Strict",
+	http.SameSiteNoneMode:    "None",
+}
+
+func parseSameSite(s string) (http.SameSite, error) {
+	if s == "" {
+		return 0, nil
+	}
+	for m, name := range sameSiteNames {
+		if strings.EqualFold(s, name) {
+			return m, nil
+		}
+	}
+	return 0, fmt.Errorf("cookiejar: unknown SameSite value %q", s)
+}
+
+// MarshalJSON implements [json.Marshaler]. It encodes the entries of All
+// as a JSON array of objects with the fields name, value, quoted, domain,
+// hostOnly, path, secure, httpOnly, sameSite ("Default", "Lax", "Strict"
+// or "None"), partitioned, partitionKey, expires (absent for session
+// cookies),
+// creation and lastAccess. Times use RFC 3339.
+func (j *Jar) MarshalJSON() ([]byte, error) {
+	all := j.All()
+	out := make([]jsonEntry, len(all))
+	for i, e := range all {
+		out[i] = jsonEntry{
+			Name:         e.Name,
+			Value:        e.Value,
+			Quoted:       e.Quoted,
+			Domain:       e.Domain,
+			HostOnly:     e.HostOnly,
+			Path:         e.Path,
+			Secure:       e.Secure,
+			HttpOnly:     e.HttpOnly,
+			SameSite:     sameSiteNames[e.SameSite],
+			Partitioned:  e.Partitioned,
+			PartitionKey: e.PartitionKey,
+			Creation:     e.Creation,
+			LastAccess:   e.LastAccess,
+		}
+		if !e.Expires.IsZero() {
+			out[i].Expires = &all[i].Expires
+		}
+	}
+	return json.Marshal(out)
+}
+
+// UnmarshalJSON implements [json.Unmarshaler]. It adds the entries
+// encoded by MarshalJSON to the jar with Add.
+func (j *Jar) UnmarshalJSON(data []byte) error {
+	var in []jsonEntry
+	if err := json.Unmarshal(data, &in); err != nil {
+		return err
+	}
+	entries := make([]Entry, len(in))
+	for i, je := range in {
+		ss, err := parseSameSite(je.SameSite)
+		if err != nil {
+			return err
+		}
+		entries[i] = Entry{
+			Name:         je.Name,
+			Value:        je.Value,
+			Quoted:       je.Quoted,
+			Domain:       je.Domain,
+			HostOnly:     je.HostOnly,
+			Path:         je.Path,
+			Secure:       je.Secure,
+			HttpOnly:     je.HttpOnly,
+			SameSite:     ss,
+			Partitioned:  je.Partitioned,
+			PartitionKey: je.PartitionKey,
+			Creation:     je.Creation,
+			LastAccess:   je.LastAccess,
+		}
+		if je.Expires != nil {
+			entries[i].Expires = *je.Expires
+		}
+	}
+	return j.Add(entries...)
+}
+
+// netscapeHttpOnlyPrefix marks HttpOnly cookies in a cookies.txt file,
+// as written by curl and browser export extensions.
+const netscapeHttpOnlyPrefix = "#HttpOnly_"
+
+// WriteNetscape writes the entries of All to w in the Netscape
+// cookies.txt format read by curl and wget: one line per cookie with the
+// tab-separated fields domain, include-subdomains flag, path, secure
+// flag, expiry in Unix seconds (0 for session cookies), name and value.
+// Host-only cookies are written without a leading dot on the domain and
+// HttpOnly cookies with a "#HttpOnly_" prefix.
+//
+// The format has no place for SameSite, partitions or the creation and
+// last access times; use the JSON or HAR forms to keep them. Partitioned
+// cookies are left out, as they would be read back unpartitioned.
+func (j *Jar) WriteNetscape(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("# Netscape HTTP Cookie File\n\n")
+	for _, e := range j.All() {
+		if e.Partitioned {
+			continue
+		}
+		domain, sub := "."+e.Domain, "TRUE"
+		if e.HostOnly {
+			domain, sub = e.Domain, "FALSE"
+		}
+		if e.HttpOnly {
+			domain = netscapeHttpOnlyPrefix + domain
+		}
+		var expires int64
+		if !e.Expires.IsZero() {
+			expires = e.Expires.Unix()
+		}
+		value := e.Value
+		if e.Quoted {
+			value = `"` + value + `"`
+		}
+		fmt.Fprintf(bw, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n",
+			domain, sub, e.Path, strings.ToUpper(strconv.FormatBool(e.Secure)), expires, e.Name, value)
+	}
+	return bw.Flush()
+}
+
+// ReadNetscape reads a Netscape cookies.txt file, as written by
+// WriteNetscape, curl or a browser export extension, and adds its
+// cookies to the jar with Add.
+func (j *Jar) ReadNetscape(r io.Reader) error {
+	var entries []Entry
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimRight(sc.Text(), "\r")
+		httpOnly := strings.HasPrefix(line, netscapeHttpOnlyPrefix)
+		if httpOnly {
+			line = line[len(netscapeHttpOnlyPrefix):]
+		} else if line == "" || line[0] == '#' {
+			continue
+		}
+		f := strings.Split(line, "\t")
+		if len(f) == 6 {
+			f = append(f, "") // a cookie with an empty value
+		}
+		if len(f) != 7 {
+			return fmt.Errorf("cookiejar: cookies.txt line %d: %d fields, want 7", n, len(f))
+		}
+		expires, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			return fmt.Errorf("cookiejar: cookies.txt line %d: bad expiry %q", n, f[4])
+		}
+		e := Entry{
+			Name:     f[5],
+			Value:    f[6],
+			Domain:   f[0],
+			HostOnly: !strings.EqualFold(f[1], "TRUE"),
+			Path:     f[2],
+			Secure:   strings.EqualFold(f[3], "TRUE"),
+			HttpOnly: httpOnly,
+		}
+		if expires > 0 {
+			e.Expires = time.Unix(expires, 0)
+		}
+		if len(e.Value) > 1 && e.Value[0] == '"' && e.Value[len(e.Value)-1] == '"' {
+			e.Value, e.Quoted = e.Value[1:len(e.Value)-1], true
+		}
+		entries = append(entries, e)
+	}
+	if err := sc.Err(); err != nil {
+		return err
+	}
+	return j.Add(entries...)
+}
+
+// harCookie is a cookie object of the HTTP Archive (HAR) 1.2 format.
+// sameSite is the field written by browser HAR exports; fields starting
+// with an underscore are extensions, as the format permits.
+type harCookie struct {
+	Name         string     `json:"name"`
+	Value        string     `json:"value"`
+	Path         string     `json:"path,omitempty"`
+	Domain       string     `json:"domain,omitempty"`
+	Expires      *time.Time `json:"expires,omitempty"`
+	HttpOnly     bool       `json:"httpOnly"`
+	Secure       bool       `json:"secure"`
+	SameSite     string     `json:"sameSite,omitempty"`
+	Partitioned  bool       `json:"_partitioned,omitempty"`
+	PartitionKey string     `json:"_partitionKey,omitempty"`
+	Quoted       bool       `json:"_quoted,omitempty"`
+	Creation     *time.Time `json:"_creation,omitempty"`
+	LastAccess   *time.Time `json:"_lastAccess,omitempty"`
+}
+
+// WriteHAR writes the entries of All to w as a JSON array of HAR 1.2
+// cookie objects, suitable for the cookies field of a HAR request or
+// response. As in browser exports, domain cookies have a leading dot on
+// their domain and host-only cookies do not, and expires is absent for
+// session cookies. The partition, the quoting of the value and the
+// creation and last access times are kept in underscore-prefixed
+// extension fields.
+func (j *Jar) WriteHAR(w io.Writer) error {
+	all := j.All()
+	out := make([]harCookie, len(all))
+	for i := range all {
+		e := &all[i]
+		domain := "." + e.Domain
+		if e.HostOnly {
+			domain = e.Domain
+		}
+		out[i] = harCookie{
+			Name:         e.Name,
+			Value:        e.Value,
+			Path:         e.Path,
+			Domain:       domain,
+			HttpOnly:     e.HttpOnly,
+			Secure:       e.Secure,
+			SameSite:     sameSiteNames[e.SameSite],
+			Partitioned:  e.Partitioned,
+			PartitionKey: e.PartitionKey,
+			Quoted:       e.Quoted,
+			Creation:     &e.Creation,
+			LastAccess:   &e.LastAccess,
+		}
+		if !e.Expires.IsZero() {
+			out[i].Expires = &e.Expires
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// ReadHAR reads a JSON array of HAR cookie objects, as written by
+// WriteHAR or taken from the cookies field of a HAR entry, and adds the
+// cookies to the jar with Add. Cookies without a domain are rejected, as
+// a HAR cookie list does not say which host set them.
+func (j *Jar) ReadHAR(r io.Reader) error {
+	var in []harCookie
+	if err := json.NewDecoder(r).Decode(&in); err != nil {
+		return err
+	}
+	entries := make([]Entry, len(in))
+	for i, hc := range in {
+		if hc.Domain == "" {
+			return errors.New("cookiejar: HAR cookie " + strconv.Quote(hc.Name) + " has no domain")
+		}
+		ss, err := parseSameSite(hc.SameSite)
+		if err != nil {
+			return err
+		}
+		entries[i] = Entry{
+			Name:         hc.Name,
+			Value:        hc.Value,
+			Quoted:       hc.Quoted,
+			Domain:       hc.Domain,
+			HostOnly:     !strings.HasPrefix(hc.Domain, "."),
+			Path:         hc.Path,
+			Secure:       hc.Secure,
+			HttpOnly:     hc.HttpOnly,
+			SameSite:     ss,
+			Partitioned:  hc.Partitioned,
+			PartitionKey: hc.PartitionKey,
+		}
+		if hc.Expires != nil {
+			entries[i].Expires = *hc.Expires
+		}
+		if hc.Creation != nil {
+			entries[i].Creation = *hc.Creation
+		}
+		if hc.LastAccess != nil {
+			entries[i].LastAccess = *hc.LastAccess
+		}
+	}
+	return j.Add(entries...)
+}
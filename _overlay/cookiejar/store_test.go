@@ -0,0 +1,243 @@
+package cookiejar
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	http "github.com/dteh/dhttp"
+)
+
+// newStoreTestJar returns a jar holding cookies that exercise every
+// attribute an Entry keeps.
+func newStoreTestJar(t *testing.T) *Jar {
+	t.Helper()
+	jar, _ := New(&Options{PublicSuffixList: testPSL{}})
+	future := time.Now().Add(time.Hour).Truncate(time.Second)
+	jar.SetCookies(mustParseURL("https://www.example.com/a/b"), []*http.Cookie{
+		{Name: "host", Value: "1", Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode},
+		{Name: "dom", Value: "2", Domain: "example.com", Path: "/", Expires: future, SameSite: http.SameSiteLaxMode},
+		{Name: "chips", Value: "3", Path: "/", Secure: true, SameSite: http.SameSiteNoneMode, Partitioned: true, MaxAge: 3600},
+		{Name: "quoted", Value: "a b", Quoted: true, SameSite: http.SameSiteDefaultMode},
+	})
+	jar.SetCookies(mustParseURL("http://other.co.uk/"), []*http.Cookie{
+		{Name: "o", Value: "4"},
+	})
+	return jar
+}
+
+func TestJarAllAndForDomain(t *testing.T) {
+	jar := newStoreTestJar(t)
+
+	names := func(es []Entry) []string {
+		var s []string
+		for _, e := range es {
+			s = append(s, e.Domain+e.Path+";"+e.Name)
+		}
+		return s
+	}
+	all := jar.All()
+	want := []string{"example.com/;dom", "other.co.uk/;o", "www.example.com/;chips", "www.example.com/a;host", "www.example.com/a;quoted"}
+	if got := names(all); !slices.Equal(got, want) {
+		t.Errorf("All = %q, want %q", got, want)
+	}
+	if got, want := names(jar.ForDomain("example.com")), slices.Concat(want[:1], want[2:]); !slices.Equal(got, want) {
+		t.Errorf("ForDomain(example.com) = %q, want %q", got, want)
+	}
+	if got, want := names(jar.ForDomain("WWW.example.com.")), want[2:]; !slices.Equal(got, want) {
+		t.Errorf("ForDomain(WWW.example.com.) = %q, want %q", got, want)
+	}
+	if got := jar.ForDomain("ample.com"); len(got) != 0 {
+		t.Errorf("ForDomain(ample.com) = %v, want none", got)
+	}
+
+	host := all[3]
+	if !host.HostOnly || !host.Secure || !host.HttpOnly || host.SameSite != http.SameSiteStrictMode || !host.Expires.IsZero() {
+		t.Errorf("host-only session cookie = %+v", host)
+	}
+	if dom := all[0]; dom.HostOnly || dom.Expires.IsZero() {
+		t.Errorf("domain cookie = %+v", dom)
+	}
+	if chips := all[2]; !chips.Partitioned || chips.SameSite != http.SameSiteNoneMode {
+		t.Errorf("partitioned cookie = %+v", chips)
+	}
+}
+
+func TestJarDeleteAndClear(t *testing.T) {
+	jar := newStoreTestJar(t)
+	u := mustParseURL("https://www.example.com/a/")
+
+	if jar.Delete("www.example.com", "/", "host") {
+		t.Error("Delete with the wrong path reported success")
+	}
+	if !jar.Delete("www.example.com", "/a", "host") {
+		t.Error("Delete(host) = false")
+	}
+	if !jar.Delete(".example.com", "/", "dom") {
+		t.Error("Delete(dom) = false")
+	}
+	if got := cookiesToString(jar.Cookies(u)); got != `quoted="a b" chips=3` {
+		t.Errorf("after Delete, Cookies = %q", got)
+	}
+
+	jar.Clear()
+	if all := jar.All(); len(all) != 0 {
+		t.Errorf("after Clear, All = %v", all)
+	}
+	if got := jar.Cookies(u); len(got) != 0 {
+		t.Errorf("after Clear, Cookies = %v", got)
+	}
+}
+
+func TestJarAdd(t *testing.T) {
+	jar, _ := New(nil)
+	now := time.Now()
+	err := jar.Add(
+		Entry{Name: "a", Value: "1", Domain: "example.com", HostOnly: true, Path: "/"},
+		Entry{Name: "old", Value: "2", Domain: "example.com", Path: "/", Expires: now.Add(-time.Hour)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := cookiesToString(jar.Cookies(mustParseURL("http://example.com/"))); got != "a=1" {
+		t.Errorf("Cookies = %q, want a=1", got)
+	}
+	if got := jar.Cookies(mustParseURL("http://www.example.com/")); len(got) != 0 {
+		t.Errorf("host-only cookie sent to subdomain: %v", got)
+	}
+
+	if err := jar.Add(Entry{Name: "b", Domain: "example.com"}, Entry{Domain: "example.com"}); err == nil {
+		t.Error("Add with an unnamed entry succeeded")
+	}
+	if n := len(jar.All()); n != 1 {
+		t.Errorf("failed Add stored entries: %d in jar, want 1", n)
+	}
+}
+
+// sameEntries reports whether got and want are equal, comparing times
+// with Equal after truncating them to prec.
+func sameEntries(got, want []Entry, prec time.Duration) bool {
+	return slices.EqualFunc(got, want, func(a, b Entry) bool {
+		ta := []*time.Time{&a.Expires, &a.Creation, &a.LastAccess}
+		tb := []*time.Time{&b.Expires, &b.Creation, &b.LastAccess}
+		for i := range ta {
+			if !ta[i].Truncate(prec).Equal(tb[i].Truncate(prec)) {
+				return false
+			}
+			*ta[i], *tb[i] = time.Time{}, time.Time{}
+		}
+		return a == b
+	})
+}
+
+func TestJarJSONRoundTrip(t *testing.T) {
+	jar := newStoreTestJar(t)
+	data, err := json.Marshal(jar)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jar2, _ := New(&Options{PublicSuffixList: testPSL{}})
+	if err := json.Unmarshal(data, jar2); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jar2.All(), jar.All(); !sameEntries(got, want, 0) {
+		t.Errorf("after JSON round trip:\n got %+v\nwant %+v", got, want)
+	}
+	if !strings.Contains(string(data), `"sameSite":"Strict"`) || strings.Count(string(data), `"expires"`) != 2 {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+	if err := json.Unmarshal([]byte(`[{"name":"a","domain":"example.com","sameSite":"Sometimes"}]`), jar2); err == nil {
+		t.Error("bad sameSite accepted")
+	}
+}
+
+func TestJarHARRoundTrip(t *testing.T) {
+	jar := newStoreTestJar(t)
+	var buf bytes.Buffer
+	if err := jar.WriteHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var har []map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &har); err != nil {
+		t.Fatal(err)
+	}
+	if har[0]["domain"] != ".example.com" || har[3]["domain"] != "www.example.com" || har[2]["_partitioned"] != true {
+		t.Errorf("unexpected HAR: %s", buf.Bytes())
+	}
+
+	jar2, _ := New(&Options{PublicSuffixList: testPSL{}})
+	if err := jar2.ReadHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := jar2.All(), jar.All(); !sameEntries(got, want, 0) {
+		t.Errorf("after HAR round trip:\n got %+v\nwant %+v", got, want)
+	}
+	if err := jar2.ReadHAR(strings.NewReader(`[{"name":"a","value":"1"}]`)); err == nil {
+		t.Error("HAR cookie without domain accepted")
+	}
+}
+
+func TestJarNetscapeRoundTrip(t *testing.T) {
+	jar := newStoreTestJar(t)
+	var buf bytes.Buffer
+	if err := jar.WriteNetscape(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "#HttpOnly_www.example.com\tFALSE\t/a\tTRUE\t0\thost\t1\n") {
+		t.Errorf("unexpected cookies.txt:\n%s", buf.String())
+	}
+
+	jar2, _ := New(&Options{PublicSuffixList: testPSL{}})
+	if err := jar2.ReadNetscape(&buf); err != nil {
+		t.Fatal(err)
+	}
+	// cookies.txt keeps neither SameSite nor the access times, stores
+	// expiry in seconds and leaves out partitioned cookies.
+	var want []Entry
+	for _, e := range jar.All() {
+		if !e.Partitioned {
+			e.SameSite = 0
+			want = append(want, e)
+		}
+	}
+	got := jar2.All()
+	for i := range got {
+		got[i].Creation, got[i].LastAccess = want[i].Creation, want[i].LastAccess
+	}
+	if !sameEntries(got, want, time.Second) {
+		t.Errorf("after cookies.txt round trip:\n got %+v\nwant %+v", got, want)
+	}
+}
+
+func TestJarReadNetscape(t *testing.T) {
+	const txt = "# Netscape HTTP Cookie File\r\n" +
+		"# a comment\n" +
+		"\n" +
+		".example.com\tTRUE\t/\tFALSE\t0\tempty\n" +
+		"example.com\tFALSE\t/p\tTRUE\t1\tgone\tx\n" +
+		"#HttpOnly_.example.com\tTRUE\t/\tFALSE\t4102444800\th\tv\r\n"
+	jar, _ := New(nil)
+	if err := jar.ReadNetscape(strings.NewReader(txt)); err != nil {
+		t.Fatal(err)
+	}
+	all := jar.All()
+	if len(all) != 2 || all[0].Name != "empty" || all[0].Value != "" || all[1].Name != "h" || !all[1].HttpOnly ||
+		all[1].Expires.Unix() != 4102444800 || all[1].HostOnly {
+		t.Errorf("All = %+v", all)
+	}
+	if err := jar.ReadNetscape(strings.NewReader("example.com\tFALSE\t/\n")); err == nil {
+		t.Error("short line accepted")
+	}
+}
+
+// cookiesToString serializes cookies as "name1=val1 name2=val2".
+func cookiesToString(cookies []*http.Cookie) string {
+	var s []string
+	for _, c := range cookies {
+		s = append(s, c.String())
+	}
+	return strings.Join(s, " ")
+}
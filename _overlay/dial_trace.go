@@ -0,0 +1,102 @@
+package http
+
+import (
+	"context"
+	"net"
+
+	"github.com/dteh/dhttp/internal/nettrace"
+)
+
+// dialTraced dials addr with dial, reporting connection attempts and,
+// if resolve is set, DNS lookups to the nettrace.Trace in ctx, which
+// httptrace.WithClientTrace installs for the DNSStart, DNSDone,
+// ConnectStart and ConnectDone hooks.
+//
+// net.Dialer reports these events to the standard library's own internal
+// nettrace package, which this module's httptrace cannot reach, so the
+// hooks would never fire. By default the dial is left to dial and
+// reported as a single connection attempt to addr. With resolve, the
+// host is resolved here and its addresses are dialed in turn, without
+// Happy Eyeballs racing between address families; see
+// Transport.TraceDNS. Tests' alternate resolvers, set with
+// nettrace.LookupIPAltResolverKey, are honored the same way.
+func dialTraced(ctx context.Context, dial func(context.Context, string, string) (net.Conn, error), network, addr string, resolve bool) (net.Conn, error) {
+	nt, _ := ctx.Value(nettrace.TraceKey{}).(*nettrace.Trace)
+	alt, _ := ctx.Value(nettrace.LookupIPAltResolverKey{}).(func(context.Context, string, string) ([]net.IPAddr, error))
+	if nt == nil && alt == nil {
+		return dial(ctx, network, addr)
+	}
+	if alt == nil && !resolve {
+		if nt.ConnectStart != nil {
+			nt.ConnectStart(network, addr)
+		}
+		c, err := dial(ctx, network, addr)
+		if nt.ConnectDone != nil {
+			nt.ConnectDone(network, addr, err)
+		}
+		return c, err
+	}
+	if nt == nil {
+		nt = &nettrace.Trace{}
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return dial(ctx, network, addr)
+	}
+
+	var addrs []net.IPAddr
+	if ip := net.ParseIP(host); ip != nil {
+		addrs = []net.IPAddr{{IP: ip}}
+	} else {
+		lookup := net.DefaultResolver.LookupIPAddr
+		if alt != nil {
+			lookup = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+				return alt(ctx, "ip", host)
+			}
+		}
+		if nt.DNSStart != nil {
+			nt.DNSStart(host)
+		}
+		addrs, err = lookup(ctx, host)
+		if nt.DNSDone != nil {
+			ips := make([]any, len(addrs))
+			for i, a := range addrs {
+				ips[i] = a
+			}
+			nt.DNSDone(ips, false, err)
+		}
+		if err != nil {
+			return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+		}
+	}
+
+	var firstErr error
+	for _, a := range addrs {
+		switch {
+		case network == "tcp4" && a.IP.To4() == nil,
+			network == "tcp6" && a.IP.To4() != nil:
+			continue
+		}
+		ipAddr := net.JoinHostPort(a.String(), port)
+		if nt.ConnectStart != nil {
+			nt.ConnectStart(network, ipAddr)
+		}
+		c, err := dial(ctx, network, ipAddr)
+		if nt.ConnectDone != nil {
+			nt.ConnectDone(network, ipAddr, err)
+		}
+		if err == nil {
+			return c, nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	if firstErr == nil {
+		firstErr = &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	return nil, firstErr
+}
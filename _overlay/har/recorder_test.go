@@ -0,0 +1,204 @@
+package har_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"testing"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/har"
+	"github.com/dteh/dhttp/httptest"
+)
+
+func get(t *testing.T, c *http.Client, req *http.Request) {
+	t.Helper()
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func names(hs []har.NameValue) []string {
+	var ns []string
+	for _, h := range hs {
+		ns = append(ns, h.Name)
+	}
+	return ns
+}
+
+// TestRecordWireOrderHTTP1 checks that headers are recorded in the order
+// they were sent, using a raw server that writes its header fields in an
+// order net/http would not.
+func TestRecordWireOrderHTTP1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		br := bufio.NewReader(c)
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			return
+		}
+		io.Copy(io.Discard, req.Body)
+		io.WriteString(c, "HTTP/1.1 201 Created\r\n"+
+			"Zeta: 1\r\n"+
+			"Set-Cookie: a=1; Path=/; HttpOnly; SameSite=Lax\r\n"+
+			"alpha: 2\r\n"+
+			"Zeta: 3\r\n"+
+			"Content-Type: text/plain\r\n"+
+			"Content-Length: 5\r\n"+
+			"\r\n"+
+			"hello")
+	}()
+
+	rec := &har.Recorder{Transport: &http.Transport{}}
+	c := &http.Client{Transport: rec}
+	req, _ := http.NewRequest("POST", "http://"+ln.Addr().String()+"/p?b=2&a=x%20y", strings.NewReader("body"))
+	req.Header.Set("Cookie", "s=1; t=2")
+	req.Header.Set("Content-Type", "text/plain")
+	req.Header[http.HeaderOrderKey] = []string{"cookie", "user-agent", "content-type"}
+	get(t, c, req)
+
+	entries := rec.HAR().Log.Entries
+	if len(entries) != 1 {
+		t.Fatalf("got %d entries, want 1", len(entries))
+	}
+	e := entries[0]
+
+	if got, want := strings.Join(names(e.Request.Headers), ","), "Host,Cookie,User-Agent,Content-Type,Accept-Encoding,Content-Length"; got != want {
+		t.Errorf("request headers = %s, want %s", got, want)
+	}
+	if got, want := strings.Join(names(e.Response.Headers), ","), "Zeta,Set-Cookie,alpha,Zeta,Content-Type,Content-Length"; got != want {
+		t.Errorf("response headers = %s, want %s", got, want)
+	}
+	if v := e.Response.Headers[3].Value; v != "3" {
+		t.Errorf("second Zeta = %q, want 3", v)
+	}
+	if len(e.Request.Cookies) != 2 || e.Request.Cookies[1].Name != "t" {
+		t.Errorf("request cookies = %+v", e.Request.Cookies)
+	}
+	if cs := e.Response.Cookies; len(cs) != 1 || cs[0].Name != "a" || !cs[0].HTTPOnly || cs[0].SameSite != "Lax" {
+		t.Errorf("response cookies = %+v", cs)
+	}
+	if q := e.Request.QueryString; len(q) != 2 || q[0] != (har.NameValue{"b", "2"}) || q[1] != (har.NameValue{"a", "x y"}) {
+		t.Errorf("query string = %v", q)
+	}
+	if pd := e.Request.PostData; pd == nil || pd.Text != "body" || pd.MimeType != "text/plain" || e.Request.BodySize != 4 {
+		t.Errorf("post data = %+v, body size %d", pd, e.Request.BodySize)
+	}
+	if e.Response.Status != 201 || e.Response.StatusText != "Created" || e.Response.HTTPVersion != "HTTP/1.1" {
+		t.Errorf("status = %d %q %s", e.Response.Status, e.Response.StatusText, e.Response.HTTPVersion)
+	}
+	if c := e.Response.Content; c.Text != "hello" || c.Size != 5 || e.Response.BodySize != 5 {
+		t.Errorf("content = %+v, body size %d", c, e.Response.BodySize)
+	}
+	if e.TLSFingerprint != nil {
+		t.Errorf("TLS fingerprint = %+v for a plain HTTP exchange", e.TLSFingerprint)
+	}
+	tm := e.Timings
+	if tm.DNS != -1 || tm.SSL != -1 || tm.Connect < 0 || tm.Send < 0 || tm.Wait < 0 || tm.Receive < 0 {
+		t.Errorf("timings = %+v", tm)
+	}
+	if e.ServerIPAddress != "127.0.0.1" || e.Connection == "" {
+		t.Errorf("server address %q, connection %q", e.ServerIPAddress, e.Connection)
+	}
+}
+
+func TestRecordHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0x00})
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	// The Transport dials itself, unlike ts.Client's, so that connect
+	// events are traced.
+	tr := &http.Transport{
+		TLSClientConfig:   ts.Client().Transport.(*http.Transport).TLSClientConfig,
+		ForceAttemptHTTP2: true,
+	}
+	defer tr.CloseIdleConnections()
+	rec := &har.Recorder{Transport: tr}
+	c := &http.Client{Transport: rec}
+	req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+	get(t, c, req)
+	req, _ = http.NewRequest("GET", ts.URL+"/again", nil)
+	get(t, c, req)
+
+	entries := rec.HAR().Log.Entries
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	e := entries[0]
+	if e.Request.HTTPVersion != "HTTP/2.0" {
+		t.Errorf("httpVersion = %q", e.Request.HTTPVersion)
+	}
+	if hs := names(e.Request.Headers); len(hs) < 4 || hs[0][0] != ':' {
+		t.Errorf("request headers = %v, want pseudo-headers first", hs)
+	}
+	if hs := names(e.Response.Headers); len(hs) == 0 || hs[0] != ":status" {
+		t.Errorf("response headers = %v, want :status first", hs)
+	}
+	if c := e.Response.Content; c.Encoding != "base64" || c.Text != "/wA=" {
+		t.Errorf("binary content = %+v", c)
+	}
+	fp := e.TLSFingerprint
+	if fp == nil || fp.ALPN != "h2" || fp.Version == "" || fp.CipherSuite == "" || fp.ClientHello == "" {
+		t.Errorf("TLS fingerprint = %+v", fp)
+	}
+	if e.Timings.SSL < 0 || e.Timings.Connect < e.Timings.SSL {
+		t.Errorf("first timings = %+v, want SSL within Connect", e.Timings)
+	}
+	if tm := entries[1].Timings; tm.Connect != -1 || tm.SSL != -1 {
+		t.Errorf("reused connection timings = %+v", tm)
+	}
+}
+
+func TestRecordError(t *testing.T) {
+	errFail := errors.New("no route")
+	rec := &har.Recorder{Transport: failingTransport{errFail}}
+	req, _ := http.NewRequest("GET", "http://example.com/", nil)
+	if _, err := rec.RoundTrip(req); err != errFail {
+		t.Fatalf("RoundTrip error = %v", err)
+	}
+	var buf bytes.Buffer
+	if err := rec.WriteHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var doc struct {
+		Log struct {
+			Version string
+			Entries []map[string]any
+		}
+	}
+	if err := json.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.Log.Version != "1.2" || len(doc.Log.Entries) != 1 || doc.Log.Entries[0]["_error"] != "no route" {
+		t.Errorf("HAR = %s", buf.Bytes())
+	}
+
+	rec.Reset()
+	if n := len(rec.HAR().Log.Entries); n != 0 {
+		t.Errorf("%d entries after Reset", n)
+	}
+}
+
+type failingTransport struct{ err error }
+
+func (f failingTransport) RoundTrip(*http.Request) (*http.Response, error) { return nil, f.err }
@@ -0,0 +1,314 @@
+package httputil
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	http "github.com/dteh/dhttp"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// A ForwardProxy is an HTTP handler that serves as a forward proxy. It
+// sends requests whose target is in absolute form ("GET http://host/
+// HTTP/1.1") on to their target with Transport, and tunnels CONNECT
+// requests to theirs. Hop-by-hop headers are removed in both directions.
+//
+// Egress goes through Transport, so a dhttp Transport with
+// ClientHelloSettings, BrowserHeaders and the like makes the proxy's
+// forwarded requests look like a browser's. Tunnels carry the client's
+// own bytes; they are dialed with DialContext.
+type ForwardProxy struct {
+	// Transport sends forwarded requests. If nil, http.DefaultTransport
+	// is used.
+	Transport http.RoundTripper
+
+	// DialContext dials the targets of CONNECT requests. If nil,
+	// net.Dialer.DialContext is used.
+	DialContext func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// Authenticate, if non-nil, is called for every request and reports
+	// whether it may be served. Requests it refuses are answered with
+	// 407 (Proxy Authentication Required). ProxyBasicAuth parses Basic
+	// credentials.
+	Authenticate func(*http.Request) bool
+
+	// Challenge is the Proxy-Authenticate value of 407 responses, such
+	// as `Basic realm="proxy"`. If empty, none is sent.
+	Challenge string
+
+	// Allow, if non-nil, reports whether a request may reach target, a
+	// "host:port" pair. Requests it refuses are answered with 403
+	// (Forbidden).
+	Allow func(r *http.Request, target string) bool
+
+	// OriginForm, if non-nil, serves requests that are not proxy
+	// requests, whose target is in origin form ("GET / HTTP/1.1"). If
+	// nil, they are answered with 400 (Bad Request).
+	OriginForm http.Handler
+
+	// OnTunnelClose, if non-nil, is called when a CONNECT tunnel
+	// closes, from the handler serving it.
+	OnTunnelClose func(*http.Request, TunnelStats)
+
+	// ErrorLog specifies an optional logger for errors
+	// that occur when attempting to proxy the request.
+	// If nil, logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+}
+
+// TunnelStats describes a CONNECT tunnel served by a [ForwardProxy].
+type TunnelStats struct {
+	Target   string        // "host:port" the tunnel led to
+	Sent     int64         // bytes from the client to the target
+	Received int64         // bytes from the target to the client
+	Duration time.Duration // from the 200 response to the close
+}
+
+// ProxyBasicAuth returns the username and password of the request's
+// Proxy-Authorization header, if it uses Basic authentication.
+func ProxyBasicAuth(r *http.Request) (username, password string, ok bool) {
+	auth := r.Header.Get("Proxy-Authorization")
+	scheme, enc, found := strings.Cut(auth, " ")
+	if !found || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	dec, err := base64.StdEncoding.DecodeString(strings.TrimSpace(enc))
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok = strings.Cut(string(dec), ":")
+	return username, password, ok
+}
+
+func (p *ForwardProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" && !req.URL.IsAbs() {
+		if p.OriginForm != nil {
+			p.OriginForm.ServeHTTP(rw, req)
+			return
+		}
+		http.Error(rw, "not a proxy request", http.StatusBadRequest)
+		return
+	}
+	if p.Authenticate != nil && !p.Authenticate(req) {
+		if p.Challenge != "" {
+			rw.Header().Set("Proxy-Authenticate", p.Challenge)
+		}
+		http.Error(rw, "proxy authentication required", http.StatusProxyAuthRequired)
+		return
+	}
+
+	var target string
+	if req.Method == "CONNECT" {
+		target = req.Host
+		if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+			http.Error(rw, "CONNECT target must be host:port", http.StatusBadRequest)
+			return
+		}
+	} else {
+		switch req.URL.Scheme {
+		case "http", "https":
+		default:
+			http.Error(rw, "unsupported scheme "+req.URL.Scheme, http.StatusBadRequest)
+			return
+		}
+		port := req.URL.Port()
+		if port == "" {
+			port = "80"
+			if req.URL.Scheme == "https" {
+				port = "443"
+			}
+		}
+		target = net.JoinHostPort(req.URL.Hostname(), port)
+	}
+	if p.Allow != nil && !p.Allow(req, target) {
+		http.Error(rw, "forbidden target", http.StatusForbidden)
+		return
+	}
+
+	if req.Method == "CONNECT" {
+		p.serveConnect(rw, req, target)
+	} else {
+		p.serveForward(rw, req)
+	}
+}
+
+// serveForward sends req on to its absolute-form target and copies the
+// response back.
+func (p *ForwardProxy) serveForward(rw http.ResponseWriter, req *http.Request) {
+	transport := p.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+
+	outreq := req.Clone(req.Context())
+	if req.ContentLength == 0 {
+		outreq.Body = nil // Issue 16036: nil Body for http.Transport retries
+	}
+	if outreq.Body != nil {
+		defer outreq.Body.Close()
+	}
+	outreq.RequestURI = ""
+	outreq.Host = ""
+	outreq.Close = false
+	removeHopByHopHeaders(outreq.Header)
+	if httpguts.HeaderValuesContainsToken(req.Header["Te"], "trailers") {
+		outreq.Header.Set("Te", "trailers")
+	}
+
+	res, err := transport.RoundTrip(outreq)
+	if err != nil {
+		p.logf("http: forward proxy error: %v", err)
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer res.Body.Close()
+
+	removeHopByHopHeaders(res.Header)
+	copyHeader(rw.Header(), res.Header)
+	announcedTrailers := len(res.Trailer)
+	if announcedTrailers > 0 {
+		trailerKeys := make([]string, 0, len(res.Trailer))
+		for k := range res.Trailer {
+			trailerKeys = append(trailerKeys, k)
+		}
+		rw.Header().Add("Trailer", strings.Join(trailerKeys, ", "))
+	}
+	rw.WriteHeader(res.StatusCode)
+
+	var dst io.Writer = rw
+	if res.ContentLength == -1 {
+		dst = flushWriter{rw} // streamed response
+	}
+	if _, err := io.Copy(dst, res.Body); err != nil {
+		p.logf("http: forward proxy error copying response: %v", err)
+		if shouldPanicOnCopyError(req) {
+			panic(http.ErrAbortHandler)
+		}
+		return
+	}
+	if len(res.Trailer) == announcedTrailers {
+		copyHeader(rw.Header(), res.Trailer)
+		return
+	}
+	for k, vv := range res.Trailer {
+		k = http.TrailerPrefix + k
+		for _, v := range vv {
+			rw.Header().Add(k, v)
+		}
+	}
+}
+
+// serveConnect tunnels the CONNECT request req to target. HTTP/1
+// connections are hijacked; on HTTP/2, the tunnel is the request and
+// response bodies.
+func (p *ForwardProxy) serveConnect(rw http.ResponseWriter, req *http.Request, target string) {
+	dial := p.DialContext
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	upstream, err := dial(req.Context(), "tcp", target)
+	if err != nil {
+		p.logf("http: forward proxy error: %v", err)
+		rw.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	defer upstream.Close()
+
+	var (
+		client    io.ReadWriteCloser
+		fromBytes io.Reader // what the client sends
+	)
+	rc := http.NewResponseController(rw)
+	if req.ProtoMajor == 1 {
+		conn, brw, err := rc.Hijack()
+		if err != nil {
+			p.logf("http: forward proxy error: hijacking CONNECT: %v", err)
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+			return
+		}
+		client, fromBytes = conn, brw
+	} else {
+		rw.WriteHeader(http.StatusOK)
+		if err := rc.Flush(); err != nil {
+			return
+		}
+		client = struct {
+			io.Reader
+			io.Writer
+			io.Closer
+		}{req.Body, flushWriter{rw}, req.Body}
+		fromBytes = req.Body
+	}
+
+	stats := TunnelStats{Target: target}
+	start := time.Now()
+	var sent, received atomic.Int64
+	var once sync.Once
+	closeBoth := func() {
+		once.Do(func() {
+			client.Close()
+			upstream.Close()
+		})
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		io.Copy(countingWriter{upstream, &sent}, fromBytes)
+		closeBoth()
+	}()
+	io.Copy(countingWriter{client, &received}, upstream)
+	closeBoth()
+	<-done
+
+	if p.OnTunnelClose != nil {
+		stats.Sent, stats.Received = sent.Load(), received.Load()
+		stats.Duration = time.Since(start)
+		p.OnTunnelClose(req, stats)
+	}
+}
+
+func (p *ForwardProxy) logf(format string, args ...any) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// countingWriter adds the bytes written to w to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+// flushWriter flushes each write to an http.ResponseWriter, for streamed
+// responses and tunnels.
+type flushWriter struct {
+	rw http.ResponseWriter
+}
+
+func (f flushWriter) Write(p []byte) (int, error) {
+	n, err := f.rw.Write(p)
+	if err == nil {
+		err = http.NewResponseController(f.rw).Flush()
+	}
+	return n, err
+}
@@ -0,0 +1,118 @@
+package httputil
+
+import (
+	"io"
+	"net/url"
+	"strings"
+	"testing"
+
+	http "github.com/dteh/dhttp"
+
+	"github.com/dteh/dhttp/httptest"
+)
+
+func TestForwardProxy(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "" || r.Header.Get("X-Hop") != "" {
+			t.Errorf("origin got hop-by-hop headers: %v", r.Header)
+		}
+		w.Header().Set("Connection", "X-Back-Hop")
+		w.Header().Set("X-Back-Hop", "1")
+		io.WriteString(w, "origin "+r.Header.Get("X-End"))
+	}))
+	defer origin.Close()
+
+	var egress int
+	fp := &ForwardProxy{
+		Transport: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+			egress++
+			return http.DefaultTransport.RoundTrip(r)
+		}),
+		Authenticate: func(r *http.Request) bool {
+			u, p, ok := ProxyBasicAuth(r)
+			return ok && u == "u" && p == "p"
+		},
+		Challenge: `Basic realm="test"`,
+	}
+	proxy := httptest.NewServer(fp)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	tr := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	req, _ := http.NewRequest("GET", origin.URL, nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusProxyAuthRequired || resp.Header.Get("Proxy-Authenticate") != `Basic realm="test"` {
+		t.Fatalf("without credentials: %s %v, want 407 with a challenge", resp.Status, resp.Header)
+	}
+
+	proxyURL.User = url.UserPassword("u", "p")
+	req, _ = http.NewRequest("GET", origin.URL, nil)
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("X-End", "1")
+	resp, err = tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "origin 1" || resp.Header.Get("X-Back-Hop") != "" || egress != 1 {
+		t.Errorf("got %q with header %v after %d egress requests, want origin 1 without X-Back-Hop after 1", b, resp.Header, egress)
+	}
+
+	// Requests that are not proxy requests are refused.
+	resp, err = http.Get(proxy.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("origin-form request: %s, want 400", resp.Status)
+	}
+}
+
+func TestForwardProxyConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "secure origin")
+	}))
+	defer origin.Close()
+
+	stats := make(chan TunnelStats, 1)
+	fp := &ForwardProxy{
+		Allow: func(r *http.Request, target string) bool {
+			return !strings.HasPrefix(target, "forbidden.test:")
+		},
+		OnTunnelClose: func(r *http.Request, st TunnelStats) { stats <- st },
+	}
+	proxy := httptest.NewServer(fp)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	tr := origin.Client().Transport.(*http.Transport)
+	tr.Proxy = http.ProxyURL(proxyURL)
+	c := &http.Client{Transport: tr}
+	resp, err := c.Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "secure origin" {
+		t.Errorf("body = %q, want secure origin", b)
+	}
+	tr.CloseIdleConnections()
+
+	st := <-stats
+	if want := strings.TrimPrefix(origin.URL, "https://"); st.Target != want || st.Sent == 0 || st.Received == 0 {
+		t.Errorf("TunnelStats = %+v, want bytes both ways to %s", st, want)
+	}
+
+	if _, err := c.Get("https://forbidden.test/"); err == nil || !strings.Contains(err.Error(), "Forbidden") {
+		t.Errorf("CONNECT to a forbidden target: %v, want Forbidden", err)
+	}
+}
@@ -0,0 +1,690 @@
+package httputil
+
+import (
+	"bufio"
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	http "github.com/dteh/dhttp"
+
+	tls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2/hpack"
+)
+
+// A MITMProxy is an HTTP proxy handler that intercepts the HTTPS traffic
+// tunneled through it, for debugging what clients send. It terminates
+// the TLS of each CONNECT tunnel with a certificate for the target minted
+// on the fly from CA, refusing clients whose SNI names another host, reads the HTTP/1.1 or HTTP/2 requests inside, and
+// sends them on with Transport, so that the upstream connections parrot
+// the Transport's ClientHelloSettings rather than the client's TLS
+// stack. Plain requests in absolute form are forwarded the same way.
+//
+// With MirrorClientHello and MirrorHeaderOrder, the upstream requests
+// instead reproduce the client's own ClientHello and header order, as
+// seen on the wire.
+//
+// Clients must trust CA. Use MITMProxy only on traffic you are entitled
+// to inspect.
+type MITMProxy struct {
+	// CA signs the minted certificates. Its Leaf, if nil, is parsed
+	// from Certificate[0].
+	CA *tls.Certificate
+
+	// Transport sends the intercepted requests. If nil,
+	// http.DefaultTransport is used.
+	Transport *http.Transport
+
+	// HeaderOrder and PHeaderOrder, if set, are the HeaderOrderKey and
+	// PHeaderOrderKey of requests sent upstream that do not mirror the
+	// client's order.
+	HeaderOrder, PHeaderOrder []string
+
+	// MirrorClientHello makes each tunnel's upstream connections parrot
+	// the ClientHello the client sent to the proxy. If the ClientHello
+	// cannot be reproduced, the Transport's is used.
+	MirrorClientHello bool
+
+	// MirrorHeaderOrder makes each request sent upstream keep the
+	// header and pseudo-header order the client sent it with.
+	MirrorHeaderOrder bool
+
+	// Intercept, if non-nil, reports whether to intercept a CONNECT
+	// tunnel to target, a "host:port" pair. Tunnels it declines are
+	// passed through untouched, as by a ForwardProxy.
+	Intercept func(target string) bool
+
+	// OnRequest, if non-nil, is called with each intercepted request
+	// before it is sent upstream, and may modify it. If it returns a
+	// non-nil response, that response is sent to the client instead.
+	OnRequest func(*http.Request) *http.Response
+
+	// OnResponse, if non-nil, is called with each upstream response
+	// before it is sent to the client, and may modify it. If it returns
+	// an error, the client gets a 502 (Bad Gateway) response instead.
+	OnResponse func(*http.Response) error
+
+	// MaxCerts bounds the number of minted certificates kept for reuse.
+	// Beyond it, the least recently used one is dropped, to be minted
+	// again if its host is intercepted again. Zero means 1024.
+	MaxCerts int
+
+	// ErrorLog specifies an optional logger for errors
+	// that occur when attempting to proxy the request.
+	// If nil, logging is done via the log package's standard logger.
+	ErrorLog *log.Logger
+
+	certOnce sync.Once
+	certErr  error
+	certKey  *ecdsa.PrivateKey
+	certMu   sync.Mutex
+	certs    map[string]*list.Element // of *mitmCert, in certLRU
+	certLRU  list.List                // most recently used at the front
+}
+
+// defaultMaxMITMCerts is the default MITMProxy.MaxCerts.
+const defaultMaxMITMCerts = 1024
+
+// mitmCert is a certificate minted by a MITMProxy.
+type mitmCert struct {
+	host string // in MITMProxy.certs
+	cert *tls.Certificate
+}
+
+// mitmTunnel is the state of one intercepted CONNECT tunnel.
+type mitmTunnel struct {
+	target string // CONNECT target, "host:port"
+	scheme string // "https", or "http" for a tunnel without TLS
+	tr     *http.Transport
+
+	mu     sync.Mutex
+	orders map[string][]wireOrder // HTTP/2 field orders, by mitmOrderKey
+}
+
+// wireOrder is the order of a request's fields on the wire.
+type wireOrder struct {
+	header, pseudo []string
+}
+
+// mitmOrderKey identifies the requests an HTTP/2 field order is for.
+func mitmOrderKey(method, authority, path string) string {
+	return method + " " + authority + " " + path
+}
+
+// popOrder returns the field order recorded for an HTTP/2 request.
+func (tun *mitmTunnel) popOrder(key string) (wireOrder, bool) {
+	tun.mu.Lock()
+	defer tun.mu.Unlock()
+	q := tun.orders[key]
+	if len(q) == 0 {
+		return wireOrder{}, false
+	}
+	tun.orders[key] = q[1:]
+	return q[0], true
+}
+
+func (p *MITMProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	if req.Method != "CONNECT" {
+		if !req.URL.IsAbs() || (req.URL.Scheme != "http" && req.URL.Scheme != "https") {
+			http.Error(rw, "not a proxy request", http.StatusBadRequest)
+			return
+		}
+		p.serveRequest(rw, req, &mitmTunnel{scheme: req.URL.Scheme, tr: p.transport()}, wireOrder{})
+		return
+	}
+	target := req.Host
+	if _, port, err := net.SplitHostPort(target); err != nil || port == "" {
+		http.Error(rw, "CONNECT target must be host:port", http.StatusBadRequest)
+		return
+	}
+	if p.Intercept != nil && !p.Intercept(target) {
+		(&ForwardProxy{ErrorLog: p.ErrorLog}).serveConnect(rw, req, target)
+		return
+	}
+	if req.ProtoMajor != 1 {
+		http.Error(rw, "interception needs an HTTP/1 CONNECT", http.StatusHTTPVersionNotSupported)
+		return
+	}
+	if err := p.initCerts(); err != nil {
+		p.logf("http: MITM proxy error: %v", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	conn, brw, err := http.NewResponseController(rw).Hijack()
+	if err != nil {
+		p.logf("http: MITM proxy error: hijacking CONNECT: %v", err)
+		rw.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+	if _, err := io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n"); err != nil {
+		return
+	}
+	if err := p.intercept(req.Context(), &bufferedConn{conn, brw.Reader}, target); err != nil {
+		p.logf("http: MITM proxy error: %s: %v", target, err)
+	}
+}
+
+// intercept serves the requests the client sends through its tunnel to
+// target on conn.
+func (p *MITMProxy) intercept(ctx context.Context, conn net.Conn, target string) error {
+	tun := &mitmTunnel{target: target, scheme: "https", tr: p.transport(), orders: make(map[string][]wireOrder)}
+
+	raw, hello, err := readClientHello(conn)
+	if err != nil {
+		return err
+	}
+	conn = &bufferedConn{conn, io.MultiReader(bytes.NewReader(raw), conn)}
+	if hello == nil {
+		// Not TLS: plain HTTP through the tunnel.
+		tun.scheme = "http"
+		return p.serveHTTP1(conn, tun)
+	}
+
+	if p.MirrorClientHello {
+		spec, err := (&tls.Fingerprinter{AllowBluntMimicry: true}).FingerprintClientHello(hello)
+		if err == nil {
+			tun.tr = tun.tr.Clone()
+			tun.tr.ClientHelloSettings = http.ClientHelloSettings{HelloID: tls.HelloCustom, Override: *spec}
+			defer tun.tr.CloseIdleConnections()
+		} else {
+			p.logf("http: MITM proxy: cannot mirror the ClientHello for %s: %v", target, err)
+		}
+	}
+
+	// Certificates are only minted for the CONNECT target, not for
+	// whatever name the client sends.
+	host, _, _ := net.SplitHostPort(target)
+	host = strings.ToLower(host)
+	tlsConn := tls.Server(conn, &tls.Config{
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(h *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if h.ServerName != "" && !strings.EqualFold(h.ServerName, host) {
+				return nil, fmt.Errorf("SNI %q does not match the CONNECT target", h.ServerName)
+			}
+			return p.cert(host)
+		},
+	})
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return err
+	}
+	if tlsConn.ConnectionState().NegotiatedProtocol == "h2" {
+		return p.serveHTTP2(tlsConn, tun)
+	}
+	return p.serveHTTP1(tlsConn, tun)
+}
+
+// serveHTTP1 reads HTTP/1.1 requests from conn, answering each in turn.
+func (p *MITMProxy) serveHTTP1(conn net.Conn, tun *mitmTunnel) error {
+	br := bufio.NewReaderSize(conn, 64<<10)
+	for {
+		order := wireOrder{header: peekHeaderOrder(br)}
+		req, err := http.ReadRequest(br)
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		resp := p.roundTrip(req, tun, order)
+		resp.Proto, resp.ProtoMajor, resp.ProtoMinor = "HTTP/1.1", 1, 1
+		if resp.ContentLength < 0 && resp.Body != nil && resp.Body != http.NoBody {
+			resp.TransferEncoding = []string{"chunked"}
+		}
+		err = resp.Write(conn)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		io.Copy(io.Discard, req.Body)
+		if req.Close || resp.Close {
+			return nil
+		}
+	}
+}
+
+// serveHTTP2 serves the HTTP/2 connection conn, recording the field
+// order of its requests.
+func (p *MITMProxy) serveHTTP2(conn net.Conn, tun *mitmTunnel) error {
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true) // conn is already decrypted
+	ln := &oneConnListener{conn: &h2OrderConn{Conn: conn, tun: tun}, done: make(chan struct{})}
+	srv := &http.Server{
+		Protocols: &protocols,
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			order, _ := tun.popOrder(mitmOrderKey(req.Method, req.Host, req.URL.RequestURI()))
+			p.serveRequest(rw, req, tun, order)
+		}),
+		ConnState: func(c net.Conn, st http.ConnState) {
+			if st == http.StateClosed || st == http.StateHijacked {
+				ln.Close()
+			}
+		},
+		ErrorLog: p.ErrorLog,
+	}
+	if err := srv.Serve(ln); err != errListenerDone {
+		return err
+	}
+	return nil
+}
+
+// serveRequest answers req, read by an http.Server, through p.roundTrip.
+func (p *MITMProxy) serveRequest(rw http.ResponseWriter, req *http.Request, tun *mitmTunnel, order wireOrder) {
+	resp := p.roundTrip(req, tun, order)
+	defer resp.Body.Close()
+	removeHopByHopHeaders(resp.Header)
+	copyHeader(rw.Header(), resp.Header)
+	rw.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(flushWriter{rw}, resp.Body); err != nil {
+		p.logf("http: MITM proxy error copying response: %v", err)
+		return
+	}
+	copyHeader(rw.Header(), resp.Trailer)
+}
+
+// roundTrip sends the intercepted request req on through the tunnel's
+// transport and returns the response for the client, running the hooks.
+// Failures are reported as 502 responses.
+func (p *MITMProxy) roundTrip(req *http.Request, tun *mitmTunnel, order wireOrder) *http.Response {
+	outreq := req.Clone(req.Context())
+	if req.ContentLength == 0 {
+		outreq.Body = nil
+	}
+	outreq.RequestURI = ""
+	outreq.Close = false
+	if !outreq.URL.IsAbs() {
+		outreq.URL.Scheme = tun.scheme
+		outreq.URL.Host = req.Host
+		if outreq.URL.Host == "" {
+			outreq.URL.Host = tun.target
+		}
+	}
+	outreq.Host = ""
+	removeHopByHopHeaders(outreq.Header)
+	if p.MirrorHeaderOrder && order.header != nil {
+		outreq.Header[http.HeaderOrderKey] = order.header
+		if order.pseudo != nil {
+			outreq.Header[http.PHeaderOrderKey] = order.pseudo
+		}
+	} else {
+		if p.HeaderOrder != nil {
+			outreq.Header[http.HeaderOrderKey] = p.HeaderOrder
+		}
+		if p.PHeaderOrder != nil {
+			outreq.Header[http.PHeaderOrderKey] = p.PHeaderOrder
+		}
+	}
+
+	if p.OnRequest != nil {
+		if resp := p.OnRequest(outreq); resp != nil {
+			if resp.Body == nil {
+				resp.Body = http.NoBody
+			}
+			resp.Request = req
+			return resp
+		}
+	}
+	resp, err := tun.tr.RoundTrip(outreq)
+	if err == nil && p.OnResponse != nil {
+		if err = p.OnResponse(resp); err != nil {
+			resp.Body.Close()
+		}
+	}
+	if err != nil {
+		p.logf("http: MITM proxy error: %v", err)
+		return &http.Response{
+			StatusCode: http.StatusBadGateway,
+			Header:     make(http.Header),
+			Body:       http.NoBody,
+			Request:    req,
+		}
+	}
+	removeHopByHopHeaders(resp.Header)
+	resp.Request = req
+	return resp
+}
+
+func (p *MITMProxy) transport() *http.Transport {
+	if p.Transport != nil {
+		return p.Transport
+	}
+	return http.DefaultTransport.(*http.Transport)
+}
+
+func (p *MITMProxy) initCerts() error {
+	p.certOnce.Do(func() {
+		if p.CA == nil || len(p.CA.Certificate) == 0 {
+			p.certErr = errors.New("MITMProxy has no CA")
+			return
+		}
+		if p.CA.Leaf == nil {
+			if p.CA.Leaf, p.certErr = x509.ParseCertificate(p.CA.Certificate[0]); p.certErr != nil {
+				return
+			}
+		}
+		p.certKey, p.certErr = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		p.certs = make(map[string]*list.Element)
+	})
+	return p.certErr
+}
+
+// cert returns a certificate for host signed by p.CA, minting it the
+// first time.
+func (p *MITMProxy) cert(host string) (*tls.Certificate, error) {
+	p.certMu.Lock()
+	defer p.certMu.Unlock()
+	if e := p.certs[host]; e != nil {
+		if c := e.Value.(*mitmCert).cert; time.Until(c.Leaf.NotAfter) > time.Hour {
+			p.certLRU.MoveToFront(e)
+			return c, nil
+		}
+		p.certLRU.Remove(e)
+		delete(p.certs, host)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: host},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(7 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		tmpl.IPAddresses = []net.IP{ip}
+	} else {
+		tmpl.DNSNames = []string{host}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, p.CA.Leaf, &p.certKey.PublicKey, p.CA.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	c := &tls.Certificate{Certificate: [][]byte{der, p.CA.Certificate[0]}, PrivateKey: p.certKey, Leaf: leaf}
+	p.certs[host] = p.certLRU.PushFront(&mitmCert{host, c})
+	maxCerts := p.MaxCerts
+	if maxCerts <= 0 {
+		maxCerts = defaultMaxMITMCerts
+	}
+	for p.certLRU.Len() > maxCerts {
+		delete(p.certs, p.certLRU.Remove(p.certLRU.Back()).(*mitmCert).host)
+	}
+	return c, nil
+}
+
+func (p *MITMProxy) logf(format string, args ...any) {
+	if p.ErrorLog != nil {
+		p.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
+
+// readClientHello reads the TLS records carrying the ClientHello that
+// starts r. It returns the bytes read, to be replayed to the TLS server,
+// and the ClientHello as a single record, as utls fingerprints it. If r
+// does not start with a TLS handshake record, hello is nil and raw holds
+// the first byte.
+func readClientHello(r io.Reader) (raw, hello []byte, err error) {
+	const (
+		recordHeaderLen     = 5
+		recordTypeHandshake = 0x16
+		maxHelloLen         = 64 << 10
+	)
+	var hdr [recordHeaderLen]byte
+	if _, err := io.ReadFull(r, hdr[:1]); err != nil {
+		return nil, nil, err
+	}
+	if hdr[0] != recordTypeHandshake {
+		return hdr[:1:1], nil, nil
+	}
+	if _, err := io.ReadFull(r, hdr[1:]); err != nil {
+		return nil, nil, err
+	}
+	raw = append(raw, hdr[:]...)
+	var msg []byte // the handshake message, reassembled
+	for {
+		n := int(binary.BigEndian.Uint16(hdr[3:]))
+		start := len(raw)
+		raw = append(raw, make([]byte, n)...)
+		if _, err := io.ReadFull(r, raw[start:]); err != nil {
+			return nil, nil, err
+		}
+		msg = append(msg, raw[start:]...)
+		if len(msg) >= 4 {
+			want := 4 + (int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3]))
+			if msg[0] != 1 || want > maxHelloLen {
+				return nil, nil, errors.New("tls: first handshake message is not a ClientHello")
+			}
+			if len(msg) >= want {
+				msg = msg[:want]
+				break
+			}
+		}
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, nil, err
+		}
+		if hdr[0] != recordTypeHandshake {
+			return nil, nil, errors.New("tls: ClientHello interrupted by another record")
+		}
+		raw = append(raw, hdr[:]...)
+	}
+	hello = make([]byte, recordHeaderLen, recordHeaderLen+len(msg))
+	copy(hello, raw[:3])
+	binary.BigEndian.PutUint16(hello[3:], uint16(len(msg)))
+	return raw, append(hello, msg...), nil
+}
+
+// peekHeaderOrder returns the lower-cased names of the header fields of
+// the HTTP/1 request buffered at the start of br, in their order on the
+// wire, without consuming them. It returns nil if the header does not
+// fit in the buffer.
+func peekHeaderOrder(br *bufio.Reader) []string {
+	var head []byte
+	for {
+		b, _ := br.Peek(br.Buffered())
+		if i := bytes.Index(b, []byte("\r\n\r\n")); i >= 0 {
+			head = b[:i]
+			break
+		}
+		if len(b) == br.Size() {
+			return nil
+		}
+		if _, err := br.Peek(len(b) + 1); err != nil {
+			return nil
+		}
+	}
+	lines := strings.Split(string(head), "\r\n")[1:]
+	order := make([]string, 0, len(lines))
+	for _, line := range lines {
+		if name, _, ok := strings.Cut(line, ":"); ok {
+			order = append(order, strings.ToLower(strings.TrimSpace(name)))
+		}
+	}
+	return order
+}
+
+// h2OrderConn is an HTTP/2 server connection recording the field order
+// of the requests the client sends on it into tun.
+type h2OrderConn struct {
+	net.Conn
+	tun *mitmTunnel
+
+	buf      []byte
+	preface  bool
+	failed   bool // stopped sniffing on a malformed frame
+	dec      *hpack.Decoder
+	block    []byte // header block of the HEADERS frame being continued
+	fields   []hpack.HeaderField
+	streamID uint32
+}
+
+func (c *h2OrderConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 && !c.failed {
+		c.sniff(p[:n])
+	}
+	return n, err
+}
+
+// sniff parses the frames in b, the next bytes read from the client.
+// It gives up on the first malformed frame.
+func (c *h2OrderConn) sniff(b []byte) {
+	const (
+		prefaceLen        = 24 // "PRI * HTTP/2.0\r\n\r\nSM\r\n\r\n"
+		frameHeaderLen    = 9
+		frameHeaders      = 0x1
+		frameContinuation = 0x9
+		flagEndHeaders    = 0x4
+		flagPadded        = 0x8
+		flagPriority      = 0x20
+	)
+	if c.dec == nil {
+		c.dec = hpack.NewDecoder(4096, func(f hpack.HeaderField) { c.fields = append(c.fields, f) })
+		c.dec.SetMaxStringLength(64 << 10)
+	}
+	c.buf = append(c.buf, b...)
+	if !c.preface {
+		if len(c.buf) < prefaceLen {
+			return
+		}
+		c.buf, c.preface = c.buf[prefaceLen:], true
+	}
+	for len(c.buf) >= frameHeaderLen {
+		length := int(c.buf[0])<<16 | int(c.buf[1])<<8 | int(c.buf[2])
+		if len(c.buf) < frameHeaderLen+length {
+			return
+		}
+		typ, flags := c.buf[3], c.buf[4]
+		payload := c.buf[frameHeaderLen : frameHeaderLen+length]
+		streamID := binary.BigEndian.Uint32(c.buf[5:]) & (1<<31 - 1)
+		switch typ {
+		case frameHeaders:
+			if flags&flagPadded != 0 {
+				if len(payload) < 1 || int(payload[0]) > len(payload)-1 {
+					c.failed = true
+					return
+				}
+				payload = payload[1 : len(payload)-int(payload[0])]
+			}
+			if flags&flagPriority != 0 {
+				if len(payload) < 5 {
+					c.failed = true
+					return
+				}
+				payload = payload[5:]
+			}
+			c.streamID, c.block = streamID, append(c.block[:0], payload...)
+		case frameContinuation:
+			if streamID != c.streamID {
+				c.failed = true
+				return
+			}
+			c.block = append(c.block, payload...)
+		}
+		if (typ == frameHeaders || typ == frameContinuation) && flags&flagEndHeaders != 0 {
+			c.fields = c.fields[:0]
+			if _, err := c.dec.Write(c.block); err != nil {
+				c.failed = true
+				return
+			}
+			c.record(c.fields)
+		}
+		c.buf = c.buf[frameHeaderLen+length:]
+	}
+	c.buf = append([]byte(nil), c.buf...)
+}
+
+// record queues the field order of a request's header fields. Trailers,
+// which have no pseudo-header fields, are ignored.
+func (c *h2OrderConn) record(fields []hpack.HeaderField) {
+	var order wireOrder
+	var method, authority, path string
+	for _, f := range fields {
+		if !f.IsPseudo() {
+			order.header = append(order.header, f.Name)
+			continue
+		}
+		order.pseudo = append(order.pseudo, f.Name)
+		switch f.Name {
+		case ":method":
+			method = f.Value
+		case ":authority":
+			authority = f.Value
+		case ":path":
+			path = f.Value
+		}
+	}
+	if method == "" {
+		return
+	}
+	if order.header == nil {
+		order.header = []string{}
+	}
+	key := mitmOrderKey(method, authority, path)
+	c.tun.mu.Lock()
+	c.tun.orders[key] = append(c.tun.orders[key], order)
+	c.tun.mu.Unlock()
+}
+
+// bufferedConn is a net.Conn whose reads come from r, which holds bytes
+// already read from the Conn ahead of the rest.
+type bufferedConn struct {
+	net.Conn
+	r io.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.r.Read(p) }
+
+var errListenerDone = errors.New("httputil: connection served")
+
+// oneConnListener is a net.Listener accepting conn once, then blocking
+// until it is closed.
+type oneConnListener struct {
+	conn net.Conn
+	once sync.Once
+	done chan struct{}
+}
+
+func (l *oneConnListener) Accept() (net.Conn, error) {
+	if c := l.conn; c != nil {
+		l.conn = nil
+		return c, nil
+	}
+	<-l.done
+	return nil, errListenerDone
+}
+
+func (l *oneConnListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *oneConnListener) Addr() net.Addr { return dummyAddr{} }
+
+type dummyAddr struct{}
+
+func (dummyAddr) Network() string { return "tcp" }
+func (dummyAddr) String() string  { return "mitm" }
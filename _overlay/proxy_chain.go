@@ -0,0 +1,279 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/dteh/dhttp/httptrace"
+)
+
+// proxiesForRequest returns the proxies req goes through according to
+// Transport.ProxyChain and Transport.Proxy: the last one, which the
+// connection method handles like a single proxy, and the hops before it.
+func (t *Transport) proxiesForRequest(req *Request) (proxyURL *url.URL, chain []*url.URL, err error) {
+	if t.ProxyChain != nil {
+		if chain, err = t.ProxyChain(req); err != nil {
+			return nil, nil, err
+		}
+		for _, hop := range chain {
+			if hop == nil {
+				return nil, nil, errors.New("net/http: nil URL in proxy chain")
+			}
+			switch hop.Scheme {
+			case "http", "https", "socks4", "socks4a", "socks5", "socks5h":
+			default:
+				return nil, nil, fmt.Errorf("net/http: unsupported proxy scheme %q in proxy chain", hop.Scheme)
+			}
+		}
+	}
+	if t.Proxy != nil {
+		if proxyURL, err = t.Proxy(req); err != nil {
+			return nil, nil, err
+		}
+	}
+	if proxyURL == nil && len(chain) > 0 {
+		proxyURL, chain = chain[len(chain)-1], chain[:len(chain)-1]
+	}
+	if len(chain) == 0 {
+		chain = nil
+	}
+	return proxyURL, chain, nil
+}
+
+// proxyChainKey returns the connection pool key component naming the
+// hops of a proxy chain before its last proxy.
+func proxyChainKey(chain []*url.URL) string {
+	var b strings.Builder
+	for _, hop := range chain {
+		b.WriteString(hop.String())
+		b.WriteByte(' ')
+	}
+	return b.String()
+}
+
+// http2ProxyContextKey carries the proxy part of a request's connection
+// method key from Transport.roundTrip to the HTTP/2 connection pool.
+type http2ProxyContextKey struct{}
+
+// withHTTP2Proxy returns req with its context naming the proxies of cm,
+// so that the HTTP/2 connection pool, which keys connections by
+// authority, does not send it over a connection through other proxies or
+// none. req itself is not modified.
+func (cm *connectMethod) withHTTP2Proxy(req *Request) *Request {
+	if cm.proxyURL == nil {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), http2ProxyContextKey{}, cm.key().proxy))
+}
+
+// http2ProxyPoolSuffix returns the HTTP/2 connection pool key suffix
+// naming the proxies of a request, or of a connection with proxy key
+// proxy from Transport.connProxyKey.
+func http2ProxyPoolSuffix(proxy string) string {
+	if proxy == "" {
+		return ""
+	}
+	return "|proxy " + proxy
+}
+
+func http2RequestProxy(ctx context.Context) string {
+	proxy, _ := ctx.Value(http2ProxyContextKey{}).(string)
+	return proxy
+}
+
+func (t *Transport) connProxyKey(c net.Conn) string {
+	v, _ := t.proxyConns.Load(c)
+	proxy, _ := v.(string)
+	return proxy
+}
+
+// dialProxyChain dials the first hop of cm: the target, its proxy or, for
+// a proxy chain, the first proxy of the chain, through which it tunnels
+// to the last proxy. The header of Transport.ProxyProtocolHeader goes to
+// the first hop. The returned connection is to cm.addr().
+func (t *Transport) dialProxyChain(ctx context.Context, pconn *persistConn, cm connectMethod, trace *httptrace.ClientTrace) (net.Conn, error) {
+	addr := cm.addr()
+	hops := append(cm.proxyChain[:len(cm.proxyChain):len(cm.proxyChain)], cm.proxyURL)
+	if len(cm.proxyChain) > 0 {
+		addr = canonicalAddr(hops[0])
+	}
+	conn, err := t.dial(ctx, "tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.writeProxyHeader(ctx, conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(cm.proxyChain) == 0 {
+		return conn, nil
+	}
+	for i, hop := range hops[:len(hops)-1] {
+		if hop.Scheme == "https" {
+			pconn.conn = conn
+			if err := pconn.addProxyTLS(ctx, hop.Hostname(), trace, true); err != nil {
+				return nil, fmt.Errorf("proxy chain hop %d: %w", i, err)
+			}
+			conn = pconn.conn
+		}
+		if _, err := t.proxyTunnel(ctx, conn, hop, canonicalAddr(hops[i+1])); err != nil {
+			return nil, fmt.Errorf("proxy chain hop %d: %w", i, err)
+		}
+	}
+	return conn, nil
+}
+
+// proxyTunnel asks the proxy proxyURL, to which conn is connected, to
+// tunnel conn to targetAddr, with a SOCKS4 or SOCKS5 handshake or a
+// CONNECT request, and returns the response to the CONNECT request. It
+// closes conn if it fails.
+func (t *Transport) proxyTunnel(ctx context.Context, conn net.Conn, proxyURL *url.URL, targetAddr string) (*Response, error) {
+	switch proxyURL.Scheme {
+	case "socks4", "socks4a":
+		if err := socks4Tunnel(ctx, conn, proxyURL, targetAddr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return nil, nil
+	case "socks5", "socks5h":
+		d := newSOCKS5Dialer(conn, proxyURL)
+		if _, err := d.DialWithConn(ctx, conn, "tcp", targetAddr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		return nil, nil
+	}
+
+	var hdr Header
+	if t.GetProxyConnectHeader != nil {
+		var err error
+		hdr, err = t.GetProxyConnectHeader(ctx, proxyURL, targetAddr)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	} else {
+		hdr = t.ProxyConnectHeader
+	}
+	// Request.write adds to the header; work on a copy.
+	hdr = hdr.Clone()
+	if hdr == nil {
+		hdr = make(Header)
+	}
+	connectReq := &Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: targetAddr},
+		Host:   targetAddr,
+		Header: hdr,
+	}
+
+	// Set a (long) timeout here to make sure we don't block forever
+	// and leak a goroutine if the connection stops replying after
+	// the TCP connect.
+	connectCtx, cancel := testHookProxyConnectTimeout(ctx, 1*time.Minute)
+	defer cancel()
+
+	auth := t.ProxyAuthenticator
+	if auth != nil {
+		v, err := auth.ProxyAuthorization(connectCtx, proxyURL, connectReq, nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		if v != "" {
+			hdr.Set("Proxy-Authorization", v)
+		}
+	} else if u := proxyURL.User; u != nil {
+		password, _ := u.Password()
+		hdr.Set("Proxy-Authorization", "Basic "+basicAuth(u.Username(), password))
+	}
+
+	didReadResponse := make(chan struct{}) // closed after CONNECT write+read is done or fails
+	var (
+		resp *Response
+		err  error // write or read error
+	)
+	// Write the CONNECT request & read the response, answering
+	// authentication challenges on the same connection.
+	go func() {
+		defer close(didReadResponse)
+		// Okay to use and discard buffered reader here, because
+		// neither a TLS server nor a further proxy will speak until
+		// spoken to. Each response, and each challenge's body, gets
+		// the full limit.
+		lr := &io.LimitedReader{R: conn}
+		br := bufio.NewReader(lr)
+		for round := 0; ; round++ {
+			if err = connectReq.Write(conn); err != nil {
+				return
+			}
+			lr.N = t.maxHeaderResponseSize()
+			if resp, err = ReadResponse(br, connectReq); err != nil {
+				return
+			}
+			if resp.StatusCode != StatusProxyAuthRequired || auth == nil || resp.Close || round == maxProxyAuthRounds {
+				return
+			}
+			v, aerr := auth.ProxyAuthorization(connectCtx, proxyURL, connectReq, resp)
+			if aerr != nil || v == "" {
+				err = aerr
+				return
+			}
+			// The next response follows this one's body.
+			lr.N = t.maxHeaderResponseSize()
+			if _, err = io.Copy(io.Discard, resp.Body); err != nil {
+				return
+			}
+			resp.Body.Close()
+			connectReq.Header.Set("Proxy-Authorization", v)
+		}
+	}()
+	select {
+	case <-connectCtx.Done():
+		conn.Close()
+		<-didReadResponse
+		return nil, connectCtx.Err()
+	case <-didReadResponse:
+		// resp or err now set
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.OnProxyConnectResponse != nil {
+		err = t.OnProxyConnectResponse(ctx, proxyURL, connectReq, resp)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != 200 {
+		_, text, ok := strings.Cut(resp.Status, " ")
+		conn.Close()
+		if !ok {
+			return nil, errors.New("unknown status code")
+		}
+		return nil, errors.New(text)
+	}
+	return resp, nil
+}
+
+// addProxyTLS negotiates TLS with an "https" proxy on pconn.conn,
+// parroting Transport.ProxyClientHelloSettings if set. If tunnel is set,
+// the proxy is asked to CONNECT next, which is HTTP/1.1 only.
+func (pconn *persistConn) addProxyTLS(ctx context.Context, name string, trace *httptrace.ClientTrace, tunnel bool) error {
+	hello := pconn.clientHelloSettings
+	if p := pconn.t.ProxyClientHelloSettings; p != nil {
+		hello = *p
+	}
+	return pconn.addTLSHello(ctx, name, trace, hello, tunnel || pconn.raw)
+}
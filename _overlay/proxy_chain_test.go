@@ -0,0 +1,111 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/socks5"
+)
+
+// tunnel copies between a and b until either side closes, then closes
+// both.
+func tunnel(wg *sync.WaitGroup, a, b io.ReadWriteCloser) {
+	wg.Add(2)
+	for _, p := range [][2]io.ReadWriteCloser{{a, b}, {b, a}} {
+		go func() {
+			defer wg.Done()
+			io.Copy(p[0], p[1])
+			p[0].Close()
+			p[1].Close()
+		}()
+	}
+}
+
+// newSOCKS5Proxy starts a socks5.TestServer and returns its URL and a
+// count of the connections it tunneled.
+func newSOCKS5Proxy(t *testing.T) (*url.URL, func() int) {
+	var n atomic.Int32
+	ts := socks5.NewTestServer(&socks5.Server{
+		Allow: func(_ context.Context, r *socks5.Request) bool {
+			if r.Command == socks5.CmdConnect {
+				n.Add(1)
+			}
+			return true
+		},
+	})
+	t.Cleanup(ts.Close)
+	return ts.URL, func() int { return int(n.Load()) }
+}
+
+func TestProxyChainConnectThenSOCKS5(t *testing.T) {
+	origin := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+	connect := newTestProxy(t, "connect", false, nil)
+	connectURL := *connect.URL
+	connectURL.User = url.UserPassword("u", "p")
+	socksURL, socksConns := newSOCKS5Proxy(t)
+
+	tr := origin.Client().Transport.(*Transport)
+	defer tr.CloseIdleConnections()
+	tr.ProxyChain = func(*Request) ([]*url.URL, error) {
+		return []*url.URL{&connectURL, socksURL}, nil
+	}
+	tr.GetProxyConnectHeader = func(ctx context.Context, proxyURL *url.URL, target string) (Header, error) {
+		return Header{"X-Hop": {proxyURL.Host + ">" + target}}, nil
+	}
+	c := &Client{Transport: tr}
+	for range 2 {
+		resp, err := c.Get(origin.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "origin" {
+			t.Fatalf("body = %q, want origin", b)
+		}
+	}
+
+	want := connectRecord{socksURL.Host, "Basic dTpw", connectURL.Host + ">" + socksURL.Host}
+	if got := connect.connects(); len(got) != 1 || got[0] != want {
+		t.Errorf("CONNECT proxy saw %+v, want one %+v", got, want)
+	}
+	if n := socksConns(); n != 1 {
+		t.Errorf("SOCKS5 proxy tunneled %d connections, want 1", n)
+	}
+}
+
+func TestProxyChainBeforeProxy(t *testing.T) {
+	socksURL, socksConns := newSOCKS5Proxy(t)
+	final := newTestProxy(t, "final", false, nil).URL
+	tr := &Transport{
+		Proxy:      ProxyURL(final),
+		ProxyChain: func(*Request) ([]*url.URL, error) { return []*url.URL{socksURL}, nil },
+	}
+	defer tr.CloseIdleConnections()
+
+	resp, err := (&Client{Transport: tr}).Get("http://example.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "final" || socksConns() != 1 {
+		t.Errorf("got %q through %d SOCKS5 tunnels, want the final proxy's answer through 1", b, socksConns())
+	}
+
+	tr2 := &Transport{ProxyChain: func(*Request) ([]*url.URL, error) {
+		return []*url.URL{{Scheme: "ftp", Host: "x"}, final}, nil
+	}}
+	if _, err := (&Client{Transport: tr2}).Get("http://example.test/"); err == nil {
+		t.Error("chain with an ftp proxy succeeded, want error")
+	}
+}
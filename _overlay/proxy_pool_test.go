@@ -0,0 +1,278 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	tls "github.com/refraction-networking/utls"
+)
+
+// connectRecord is a CONNECT request seen by a testProxy.
+type connectRecord struct {
+	target, auth, hop string
+}
+
+// A testProxy is a forward proxy that answers plain HTTP requests itself
+// with its name, and records and tunnels CONNECT requests unless refuse
+// is set.
+type testProxy struct {
+	URL *url.URL
+
+	name   string
+	refuse bool
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	seen   []connectRecord
+}
+
+// newTestProxy starts a testProxy, served over TLS with tlsConfig if it
+// is non-nil.
+func newTestProxy(t *testing.T, name string, refuse bool, tlsConfig *tls.Config) *testProxy {
+	p := &testProxy{name: name, refuse: refuse}
+	ts := httptest.NewUnstartedServer(p)
+	if tlsConfig != nil {
+		ts.TLS = tlsConfig
+		ts.StartTLS()
+	} else {
+		ts.Start()
+	}
+	t.Cleanup(func() {
+		ts.CloseClientConnections()
+		ts.Close()
+		p.wg.Wait()
+	})
+	p.URL, _ = url.Parse(ts.URL)
+	return p
+}
+
+func (p *testProxy) ServeHTTP(w ResponseWriter, r *Request) {
+	if r.Method != "CONNECT" {
+		io.WriteString(w, p.name)
+		return
+	}
+	p.mu.Lock()
+	p.seen = append(p.seen, connectRecord{r.Host, r.Header.Get("Proxy-Authorization"), r.Header.Get("X-Hop")})
+	p.mu.Unlock()
+	if p.refuse {
+		w.WriteHeader(StatusProxyAuthRequired)
+		return
+	}
+	backend, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		w.WriteHeader(StatusBadGateway)
+		return
+	}
+	w.Header().Set("X-Proxy", p.name)
+	w.WriteHeader(StatusOK)
+	c, _, err := w.(Hijacker).Hijack()
+	if err != nil {
+		backend.Close()
+		return
+	}
+	tunnel(&p.wg, c, backend)
+}
+
+// connects returns the CONNECT requests p has seen.
+func (p *testProxy) connects() []connectRecord {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]connectRecord(nil), p.seen...)
+}
+
+// deadProxy returns the URL of a proxy that refuses connections.
+func deadProxy(t *testing.T) *url.URL {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	return &url.URL{Scheme: "http", Host: ln.Addr().String()}
+}
+
+func newProxyPoolClient(t *testing.T, pool *ProxyPool) *Client {
+	tr := &Transport{Proxy: pool.Proxy, OnProxyConnectResponse: pool.OnProxyConnectResponse}
+	t.Cleanup(tr.CloseIdleConnections)
+	pool.Transport = tr
+	return &Client{Transport: pool}
+}
+
+func poolGet(t *testing.T, c *Client, ctx context.Context, url string) string {
+	t.Helper()
+	req, _ := NewRequestWithContext(ctx, "GET", url, nil)
+	resp, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return string(b)
+}
+
+func TestProxyPoolRoundRobinAndSessions(t *testing.T) {
+	a, b := newTestProxy(t, "a", false, nil), newTestProxy(t, "b", false, nil)
+	pool := &ProxyPool{Proxies: []PooledProxy{{URL: a.URL}, {URL: b.URL}}}
+	c := newProxyPoolClient(t, pool)
+
+	var got []string
+	for range 4 {
+		got = append(got, poolGet(t, c, context.Background(), "http://example.test/"))
+	}
+	if s := strings.Join(got, ","); s != "a,b,a,b" {
+		t.Errorf("round robin used %s, want a,b,a,b", s)
+	}
+
+	ctx := WithProxySession(context.Background(), "user-1")
+	first := poolGet(t, c, ctx, "http://example.test/")
+	for range 3 {
+		if p := poolGet(t, c, ctx, "http://example.test/"); p != first {
+			t.Fatalf("session moved from proxy %s to %s", first, p)
+		}
+	}
+}
+
+func TestProxyPoolMaxSessions(t *testing.T) {
+	pool := &ProxyPool{
+		Proxies: []PooledProxy{
+			{URL: &url.URL{Scheme: "http", Host: "a"}},
+			{URL: &url.URL{Scheme: "http", Host: "b"}},
+			{URL: &url.URL{Scheme: "http", Host: "c"}},
+		},
+		MaxSessions: 1,
+	}
+	proxy := func(session string) string {
+		t.Helper()
+		req, _ := NewRequestWithContext(WithProxySession(context.Background(), session), "GET", "http://example.test/", nil)
+		u, err := pool.Proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Host
+	}
+	if p := proxy("s1"); p != "a" {
+		t.Fatalf("s1 got proxy %s, want a", p)
+	}
+	if p := proxy("s1"); p != "a" {
+		t.Fatalf("s1 moved to proxy %s", p)
+	}
+	proxy("s2")
+	// s2 pushed s1 out, so it is given the next proxy in turn.
+	if p := proxy("s1"); p != "c" {
+		t.Errorf("forgotten session s1 got proxy %s, want c", p)
+	}
+}
+
+func TestProxyPoolFailover(t *testing.T) {
+	dead, live := deadProxy(t), newTestProxy(t, "live", false, nil).URL
+	pool := &ProxyPool{
+		Proxies:     []PooledProxy{{URL: dead}, {URL: live}},
+		MaxFailures: 1,
+	}
+	c := newProxyPoolClient(t, pool)
+
+	for range 3 {
+		if p := poolGet(t, c, context.Background(), "http://example.test/"); p != "live" {
+			t.Fatalf("request went through %q, want live", p)
+		}
+	}
+	st := pool.Stats()
+	if st[0].Failures != 1 || st[0].CoolingDownUntil.IsZero() || st[1].Successes != 3 {
+		t.Errorf("Stats() = %+v, want one failure and a cool-down for the dead proxy", st)
+	}
+
+	// A request that cannot be replayed is not retried.
+	pool2 := &ProxyPool{Proxies: []PooledProxy{{URL: dead}, {URL: live}}}
+	c2 := newProxyPoolClient(t, pool2)
+	req, _ := NewRequest("POST", "http://example.test/", io.NopCloser(strings.NewReader("x")))
+	if resp, err := c2.Do(req); err == nil {
+		resp.Body.Close()
+		t.Error("POST through a dead proxy succeeded, want error")
+	}
+}
+
+func TestProxyPoolConnectFailure(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer ts.Close()
+
+	refusing, tunnel := newTestProxy(t, "refusing", true, nil), newTestProxy(t, "tunnel", false, nil)
+	pool := &ProxyPool{
+		Proxies:   []PooledProxy{{URL: refusing.URL}, {URL: tunnel.URL}},
+		Selection: LeastErrors,
+	}
+	tr := ts.Client().Transport.(*Transport)
+	tr.Proxy = pool.Proxy
+	tr.OnProxyConnectResponse = pool.OnProxyConnectResponse
+	pool.Transport = tr
+	c := &Client{Transport: pool}
+
+	for range 2 {
+		if body := poolGet(t, c, context.Background(), ts.URL); body != "origin" {
+			t.Fatalf("body = %q, want origin", body)
+		}
+	}
+	if st := pool.Stats(); st[0].Failures != 1 || st[1].Successes != 2 {
+		t.Errorf("Stats() = %+v, want the refusing proxy failed once and avoided after", st)
+	}
+}
+
+func TestProxyPoolHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	a, b := newTestProxy(t, "a", false, nil), newTestProxy(t, "b", false, nil)
+	pool := &ProxyPool{Proxies: []PooledProxy{{URL: a.URL}, {URL: b.URL}}}
+	tr := ts.Client().Transport.(*Transport)
+	tr.Proxy = pool.Proxy
+	tr.OnProxyConnectResponse = pool.OnProxyConnectResponse
+	pool.Transport = tr
+	c := &Client{Transport: pool}
+
+	// Each proxy tunnels its own HTTP/2 connection, which its later
+	// requests reuse.
+	for range 4 {
+		if proto := poolGet(t, c, context.Background(), ts.URL); proto != "HTTP/2.0" {
+			t.Fatalf("request over %s, want HTTP/2.0", proto)
+		}
+	}
+	if na, nb := len(a.connects()), len(b.connects()); na != 1 || nb != 1 {
+		t.Errorf("CONNECT requests per proxy: a %d, b %d, want one each", na, nb)
+	}
+	if st := pool.Stats(); st[0].Successes != 2 || st[1].Successes != 2 {
+		t.Errorf("Stats() = %+v, want two requests through each proxy", st)
+	}
+}
+
+type fixedRoundTripper string
+
+func (rt fixedRoundTripper) RoundTrip(req *Request) (*Response, error) {
+	return &Response{StatusCode: StatusOK, Body: io.NopCloser(strings.NewReader(string(rt))), Request: req}, nil
+}
+
+func TestProxyRegisteredProtocol(t *testing.T) {
+	// A Proxy that returns no proxy, as ProxyFromEnvironment does
+	// without proxy variables, leaves registered protocols in use.
+	tr := &Transport{Proxy: func(*Request) (*url.URL, error) { return nil, nil }}
+	tr.RegisterProtocol("https", fixedRoundTripper("registered"))
+	req, _ := NewRequest("GET", "https://example.test/", nil)
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(b) != "registered" {
+		t.Errorf("body = %q, want the registered RoundTripper's", b)
+	}
+}
@@ -0,0 +1,661 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxyHeaderContextKey is a context key. It can be used in HTTP
+// handlers with Context.Value to access the PROXY protocol header the
+// connection started with, when Server.ProxyProtocol is set. The
+// associated value will be of type *ProxyHeader.
+var ProxyHeaderContextKey = &contextKey{"proxy-header"}
+
+// A ProxyHeader is a PROXY protocol header, which a load balancer or
+// other TCP proxy sends at the start of a connection to pass on the
+// addresses of the connection it accepted from the client. Version 1 is
+// the text format; version 2, the binary one, also carries TLVs. See
+// https://www.haproxy.org/download/3.0/doc/proxy-protocol.txt.
+type ProxyHeader struct {
+	// Version is 1 or 2. Zero writes version 2.
+	Version int
+
+	// Local is set for version 2 LOCAL connections, which the proxy
+	// made on its own, such as health checks. Their addresses are those
+	// of the connection itself.
+	Local bool
+
+	// SourceAddr and DestAddr are the client's address and the address
+	// it connected to: a *net.TCPAddr, *net.UDPAddr or *net.UnixAddr.
+	// They are nil if the proxy did not know them (UNKNOWN or
+	// AF_UNSPEC).
+	SourceAddr, DestAddr net.Addr
+
+	// TLVs are the type-length-value fields of a version 2 header, in
+	// their order in the header.
+	TLVs []ProxyTLV
+}
+
+// A ProxyTLV is a type-length-value field of a version 2 PROXY header.
+type ProxyTLV struct {
+	Type  byte
+	Value []byte
+}
+
+// PROXY protocol TLV types.
+const (
+	ProxyTLVALPN      = 0x01 // application protocol, from ALPN
+	ProxyTLVAuthority = 0x02 // host name the client asked for, such as its TLS SNI
+	ProxyTLVCRC32C    = 0x03 // CRC-32C checksum of the header
+	ProxyTLVNoop      = 0x04 // padding
+	ProxyTLVUniqueID  = 0x05 // connection ID
+	ProxyTLVSSL       = 0x20 // TLS details, see ProxyHeader.SSL
+	ProxyTLVNetNS     = 0x30 // network namespace
+
+	// Types of the TLVs within a ProxyTLVSSL field.
+	ProxyTLVSSLVersion = 0x21 // TLS version, such as "TLSv1.3"
+	ProxyTLVSSLCN      = 0x22 // client certificate's Common Name
+	ProxyTLVSSLCipher  = 0x23 // cipher suite, such as "ECDHE-RSA-AES128-GCM-SHA256"
+	ProxyTLVSSLSigAlg  = 0x24 // signature algorithm of the certificate
+	ProxyTLVSSLKeyAlg  = 0x25 // key algorithm of the certificate
+)
+
+// TLV returns the value of the first TLV of type typ.
+func (h *ProxyHeader) TLV(typ byte) ([]byte, bool) {
+	for _, tlv := range h.TLVs {
+		if tlv.Type == typ {
+			return tlv.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Authority returns the host name the client connected to, as sent by
+// the proxy: for TLS connections, the server name (SNI) of the client's
+// ClientHello. It is empty if the header has no ProxyTLVAuthority.
+func (h *ProxyHeader) Authority() string {
+	v, _ := h.TLV(ProxyTLVAuthority)
+	return string(v)
+}
+
+// ALPN returns the application protocol the proxy negotiated with the
+// client, such as "h2". It is empty if the header has no ProxyTLVALPN.
+func (h *ProxyHeader) ALPN() string {
+	v, _ := h.TLV(ProxyTLVALPN)
+	return string(v)
+}
+
+// ProxySSL is the content of a ProxyTLVSSL field, describing the TLS
+// connection between the client and the proxy.
+type ProxySSL struct {
+	// Client is a bit field: 0x01 if the client connected over TLS,
+	// 0x02 if it sent a certificate on this connection, and 0x04 if it
+	// sent one on a connection of the same TLS session.
+	Client byte
+
+	// Verify is zero if the client's certificate was verified.
+	Verify uint32
+
+	// TLVs are the TLVs within the field, of the ProxyTLVSSL* types.
+	TLVs []ProxyTLV
+}
+
+// SSL returns the header's ProxyTLVSSL field, if it has a well-formed
+// one.
+func (h *ProxyHeader) SSL() (ProxySSL, bool) {
+	v, ok := h.TLV(ProxyTLVSSL)
+	if !ok || len(v) < 5 {
+		return ProxySSL{}, false
+	}
+	tlvs, err := parseProxyTLVs(v[5:])
+	if err != nil {
+		return ProxySSL{}, false
+	}
+	return ProxySSL{Client: v[0], Verify: binary.BigEndian.Uint32(v[1:5]), TLVs: tlvs}, true
+}
+
+var (
+	proxyV1Sig = []byte("PROXY ")
+	proxyV2Sig = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	maxProxyV1Len = 107 // including the CRLF
+
+	proxyV2CmdLocal = 0x0
+	proxyV2CmdProxy = 0x1
+
+	proxyV2AFUnspec = 0x0
+	proxyV2AFInet   = 0x1
+	proxyV2AFInet6  = 0x2
+	proxyV2AFUnix   = 0x3
+
+	proxyV2Stream = 0x1
+	proxyV2Dgram  = 0x2
+
+	proxyV2UnixPathLen = 108
+)
+
+// WriteTo writes h to w, in the format of h.Version. A version 1 header
+// can only describe TCP connections; others are written as UNKNOWN. If
+// h has a ProxyTLVCRC32C TLV, its value is set to the header's checksum.
+func (h *ProxyHeader) WriteTo(w io.Writer) (int64, error) {
+	var b []byte
+	switch h.Version {
+	case 1:
+		b = h.appendV1(nil)
+	case 0, 2:
+		var err error
+		if b, err = h.appendV2(nil); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("net/http: unsupported PROXY protocol version %d", h.Version)
+	}
+	n, err := w.Write(b)
+	return int64(n), err
+}
+
+func (h *ProxyHeader) appendV1(b []byte) []byte {
+	src, srcOK := h.SourceAddr.(*net.TCPAddr)
+	dst, dstOK := h.DestAddr.(*net.TCPAddr)
+	if !srcOK || !dstOK || src == nil || dst == nil {
+		return append(b, "PROXY UNKNOWN\r\n"...)
+	}
+	srcIP, dstIP := src.AddrPort().Addr().Unmap(), dst.AddrPort().Addr().Unmap()
+	proto := "TCP4"
+	if !srcIP.Is4() || !dstIP.Is4() {
+		proto = "TCP6"
+		srcIP, dstIP = netip.AddrFrom16(srcIP.As16()), netip.AddrFrom16(dstIP.As16())
+	}
+	return fmt.Appendf(b, "PROXY %s %s %s %d %d\r\n", proto, srcIP, dstIP, src.Port, dst.Port)
+}
+
+func (h *ProxyHeader) appendV2(b []byte) ([]byte, error) {
+	start := len(b)
+	b = append(b, proxyV2Sig...)
+	cmd := byte(proxyV2CmdProxy)
+	if h.Local {
+		cmd = proxyV2CmdLocal
+	}
+	b = append(b, 0x20|cmd, 0, 0, 0) // family and length are set below
+	famAt := len(b) - 3
+
+	var fam byte
+	switch src := h.SourceAddr.(type) {
+	case *net.TCPAddr, *net.UDPAddr:
+		srcAP, srcOK := addrPortOf(src)
+		dstAP, dstOK := addrPortOf(h.DestAddr)
+		if !srcOK || !dstOK {
+			break
+		}
+		fam = proxyV2Stream
+		if _, ok := src.(*net.UDPAddr); ok {
+			fam = proxyV2Dgram
+		}
+		srcIP, dstIP := srcAP.Addr().Unmap(), dstAP.Addr().Unmap()
+		if srcIP.Is4() && dstIP.Is4() {
+			fam |= proxyV2AFInet << 4
+			b = append(b, srcIP.AsSlice()...)
+			b = append(b, dstIP.AsSlice()...)
+		} else {
+			fam |= proxyV2AFInet6 << 4
+			src16, dst16 := srcIP.As16(), dstIP.As16()
+			b = append(b, src16[:]...)
+			b = append(b, dst16[:]...)
+		}
+		b = binary.BigEndian.AppendUint16(b, srcAP.Port())
+		b = binary.BigEndian.AppendUint16(b, dstAP.Port())
+	case *net.UnixAddr:
+		dst, ok := h.DestAddr.(*net.UnixAddr)
+		if !ok || len(src.Name) > proxyV2UnixPathLen || len(dst.Name) > proxyV2UnixPathLen {
+			break
+		}
+		fam = proxyV2AFUnix<<4 | proxyV2Stream
+		if src.Net == "unixgram" {
+			fam = proxyV2AFUnix<<4 | proxyV2Dgram
+		}
+		b = append(b, make([]byte, 2*proxyV2UnixPathLen)...)
+		copy(b[len(b)-2*proxyV2UnixPathLen:], src.Name)
+		copy(b[len(b)-proxyV2UnixPathLen:], dst.Name)
+	}
+	b[famAt] = fam
+
+	crcAt := -1
+	for _, tlv := range h.TLVs {
+		if len(tlv.Value) > 0xffff {
+			return nil, fmt.Errorf("net/http: PROXY TLV %#x too long", tlv.Type)
+		}
+		b = append(b, tlv.Type)
+		if tlv.Type == ProxyTLVCRC32C && crcAt < 0 {
+			b = append(b, 0, 4)
+			crcAt = len(b)
+			b = append(b, 0, 0, 0, 0)
+			continue
+		}
+		b = binary.BigEndian.AppendUint16(b, uint16(len(tlv.Value)))
+		b = append(b, tlv.Value...)
+	}
+	n := len(b) - start - 16
+	if n > 0xffff {
+		return nil, errors.New("net/http: PROXY header too long")
+	}
+	binary.BigEndian.PutUint16(b[famAt+1:], uint16(n))
+	if crcAt >= 0 {
+		binary.BigEndian.PutUint32(b[crcAt:], crc32.Checksum(b[start:], crc32.MakeTable(crc32.Castagnoli)))
+	}
+	return b, nil
+}
+
+func addrPortOf(a net.Addr) (netip.AddrPort, bool) {
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		if a != nil {
+			return a.AddrPort(), a.IP != nil
+		}
+	case *net.UDPAddr:
+		if a != nil {
+			return a.AddrPort(), a.IP != nil
+		}
+	}
+	return netip.AddrPort{}, false
+}
+
+// ReadProxyHeader reads a version 1 or 2 PROXY header from r. It reads
+// no more than the header.
+func ReadProxyHeader(r io.Reader) (*ProxyHeader, error) {
+	h, prefix, err := readProxyHeader(r)
+	if err == nil && h == nil {
+		err = fmt.Errorf("net/http: no PROXY header, connection starts with %q", prefix)
+	}
+	return h, err
+}
+
+// readProxyHeader reads a PROXY header from r, a byte at a time until
+// it has seen a signature. If r does not start with one, it returns a
+// nil header and the bytes read.
+func readProxyHeader(r io.Reader) (h *ProxyHeader, prefix []byte, err error) {
+	var b [len("\r\n\r\n\x00\r\nQUIT\n")]byte
+	for n := 1; ; n++ {
+		if _, err := io.ReadFull(r, b[n-1:n]); err != nil {
+			return nil, nil, err
+		}
+		v1 := n <= len(proxyV1Sig) && bytes.Equal(b[:n], proxyV1Sig[:n])
+		v2 := bytes.Equal(b[:n], proxyV2Sig[:n])
+		switch {
+		case !v1 && !v2:
+			return nil, b[:n:n], nil
+		case v1 && n == len(proxyV1Sig):
+			h, err := readProxyV1(r)
+			return h, nil, err
+		case v2 && n == len(proxyV2Sig):
+			h, err := readProxyV2(r)
+			return h, nil, err
+		}
+	}
+}
+
+// readProxyV1 reads the rest of a version 1 header, after "PROXY ".
+func readProxyV1(r io.Reader) (*ProxyHeader, error) {
+	line := make([]byte, 0, maxProxyV1Len)
+	line = append(line, proxyV1Sig...)
+	var c [1]byte
+	for !bytes.HasSuffix(line, []byte("\r\n")) {
+		if len(line) == maxProxyV1Len {
+			return nil, errors.New("net/http: PROXY v1 header too long")
+		}
+		if _, err := io.ReadFull(r, c[:]); err != nil {
+			return nil, err
+		}
+		line = append(line, c[0])
+	}
+	fields := strings.Split(string(line[len(proxyV1Sig):len(line)-2]), " ")
+	h := &ProxyHeader{Version: 1}
+	if fields[0] == "UNKNOWN" {
+		return h, nil
+	}
+	if len(fields) != 5 || (fields[0] != "TCP4" && fields[0] != "TCP6") {
+		return nil, fmt.Errorf("net/http: malformed PROXY v1 header %q", line)
+	}
+	var addrs [2]*net.TCPAddr
+	for i := range addrs {
+		ip, err := netip.ParseAddr(fields[1+i])
+		if err != nil || ip.Is4() != (fields[0] == "TCP4") || ip.Zone() != "" {
+			return nil, fmt.Errorf("net/http: malformed PROXY v1 address %q", fields[1+i])
+		}
+		port, err := strconv.ParseUint(fields[3+i], 10, 16)
+		if err != nil || (len(fields[3+i]) > 1 && fields[3+i][0] == '0') {
+			return nil, fmt.Errorf("net/http: malformed PROXY v1 port %q", fields[3+i])
+		}
+		addrs[i] = net.TCPAddrFromAddrPort(netip.AddrPortFrom(ip, uint16(port)))
+	}
+	h.SourceAddr, h.DestAddr = addrs[0], addrs[1]
+	return h, nil
+}
+
+// readProxyV2 reads the rest of a version 2 header, after its
+// signature.
+func readProxyV2(r io.Reader) (*ProxyHeader, error) {
+	buf := make([]byte, 16, 16+64)
+	copy(buf, proxyV2Sig)
+	if _, err := io.ReadFull(r, buf[12:16]); err != nil {
+		return nil, err
+	}
+	if buf[12]>>4 != 2 {
+		return nil, fmt.Errorf("net/http: unsupported PROXY v2 version %d", buf[12]>>4)
+	}
+	cmd, fam := buf[12]&0xf, buf[13]
+	if cmd != proxyV2CmdLocal && cmd != proxyV2CmdProxy {
+		return nil, fmt.Errorf("net/http: unsupported PROXY v2 command %d", cmd)
+	}
+	n := int(binary.BigEndian.Uint16(buf[14:16]))
+	buf = append(buf, make([]byte, n)...)
+	if _, err := io.ReadFull(r, buf[16:]); err != nil {
+		return nil, err
+	}
+	body := buf[16:]
+
+	h := &ProxyHeader{Version: 2, Local: cmd == proxyV2CmdLocal}
+	var addrLen int
+	switch fam >> 4 {
+	case proxyV2AFUnspec:
+	case proxyV2AFInet:
+		addrLen = 2*net.IPv4len + 4
+	case proxyV2AFInet6:
+		addrLen = 2*net.IPv6len + 4
+	case proxyV2AFUnix:
+		addrLen = 2 * proxyV2UnixPathLen
+	default:
+		return nil, fmt.Errorf("net/http: unsupported PROXY v2 address family %#x", fam)
+	}
+	if len(body) < addrLen {
+		return nil, errors.New("net/http: PROXY v2 header too short for its addresses")
+	}
+	if !h.Local && fam>>4 != proxyV2AFUnspec {
+		if err := h.parseV2Addrs(fam, body[:addrLen]); err != nil {
+			return nil, err
+		}
+	}
+
+	tlvs, err := parseProxyTLVs(body[addrLen:])
+	if err != nil {
+		return nil, err
+	}
+	h.TLVs = tlvs
+	if sum, ok := h.TLV(ProxyTLVCRC32C); ok {
+		if len(sum) != 4 {
+			return nil, errors.New("net/http: malformed PROXY v2 CRC32C")
+		}
+		want := binary.BigEndian.Uint32(sum)
+		copy(sum, []byte{0, 0, 0, 0}) // sum aliases buf
+		if crc32.Checksum(buf, crc32.MakeTable(crc32.Castagnoli)) != want {
+			return nil, errors.New("net/http: PROXY v2 header checksum mismatch")
+		}
+		binary.BigEndian.PutUint32(sum, want)
+	}
+	return h, nil
+}
+
+func (h *ProxyHeader) parseV2Addrs(fam byte, b []byte) error {
+	proto := fam & 0xf
+	if proto != proxyV2Stream && proto != proxyV2Dgram {
+		return fmt.Errorf("net/http: unsupported PROXY v2 transport protocol %d", proto)
+	}
+	if fam>>4 == proxyV2AFUnix {
+		netw := "unix"
+		if proto == proxyV2Dgram {
+			netw = "unixgram"
+		}
+		path := func(p []byte) string {
+			if i := bytes.IndexByte(p, 0); i >= 0 {
+				p = p[:i]
+			}
+			return string(p)
+		}
+		h.SourceAddr = &net.UnixAddr{Name: path(b[:proxyV2UnixPathLen]), Net: netw}
+		h.DestAddr = &net.UnixAddr{Name: path(b[proxyV2UnixPathLen:]), Net: netw}
+		return nil
+	}
+	ipLen := (len(b) - 4) / 2
+	src, _ := netip.AddrFromSlice(b[:ipLen])
+	dst, _ := netip.AddrFromSlice(b[ipLen : 2*ipLen])
+	srcAP := netip.AddrPortFrom(src, binary.BigEndian.Uint16(b[2*ipLen:]))
+	dstAP := netip.AddrPortFrom(dst, binary.BigEndian.Uint16(b[2*ipLen+2:]))
+	if proto == proxyV2Dgram {
+		h.SourceAddr, h.DestAddr = net.UDPAddrFromAddrPort(srcAP), net.UDPAddrFromAddrPort(dstAP)
+	} else {
+		h.SourceAddr, h.DestAddr = net.TCPAddrFromAddrPort(srcAP), net.TCPAddrFromAddrPort(dstAP)
+	}
+	return nil
+}
+
+func parseProxyTLVs(b []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(b) > 0 {
+		if len(b) < 3 {
+			return nil, errors.New("net/http: truncated PROXY v2 TLV")
+		}
+		n := int(binary.BigEndian.Uint16(b[1:3]))
+		if len(b) < 3+n {
+			return nil, errors.New("net/http: truncated PROXY v2 TLV")
+		}
+		tlvs = append(tlvs, ProxyTLV{Type: b[0], Value: b[3 : 3+n : 3+n]})
+		b = b[3+n:]
+	}
+	return tlvs, nil
+}
+
+// ProxyProtocolConfig configures the PROXY protocol on a Server; see
+// Server.ProxyProtocol.
+type ProxyProtocolConfig struct {
+	// TrustedSources are the networks of the proxies allowed to send
+	// PROXY headers. Connections from other peers are served as they
+	// are, and a PROXY header from them is an invalid request. The
+	// Server fails to serve if TrustedSources is empty and TrustAll is
+	// not set.
+	TrustedSources []netip.Prefix
+
+	// TrustAll trusts PROXY headers from every peer, which lets any
+	// client that reaches the server choose its Request.RemoteAddr.
+	// Only set it for servers that are reachable solely through the
+	// proxies.
+	TrustAll bool
+
+	// Required makes connections from trusted sources that do not start
+	// with a PROXY header fail. Otherwise the header is optional.
+	Required bool
+
+	// HeaderTimeout is the maximum duration for reading the header. If
+	// zero, the Server's ReadHeaderTimeout applies, or its ReadTimeout
+	// if that is zero too.
+	HeaderTimeout time.Duration
+}
+
+// check reports a configuration that trusts no peer.
+func (cfg *ProxyProtocolConfig) check() error {
+	if cfg != nil && len(cfg.TrustedSources) == 0 && !cfg.TrustAll {
+		return errors.New("http: ProxyProtocolConfig has no TrustedSources; set TrustAll to trust every peer")
+	}
+	return nil
+}
+
+func (cfg *ProxyProtocolConfig) trusted(a net.Addr) bool {
+	if cfg.TrustAll {
+		return true
+	}
+	var ip netip.Addr
+	switch a := a.(type) {
+	case *net.TCPAddr:
+		ip = a.AddrPort().Addr().Unmap()
+	case *net.UnixAddr:
+		return false
+	default:
+		ap, err := netip.ParseAddrPort(a.String())
+		if err != nil {
+			return false
+		}
+		ip = ap.Addr().Unmap()
+	}
+	for _, p := range cfg.TrustedSources {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyProtocolListener returns l, wrapped to read PROXY headers from
+// trusted peers if Server.ProxyProtocol is set.
+func (s *Server) proxyProtocolListener(l net.Listener) net.Listener {
+	switch l.(type) {
+	case *proxyProtoListener, proxyProtoTLSListener:
+		return l
+	}
+	if s.ProxyProtocol == nil {
+		return l
+	}
+	timeout := s.ProxyProtocol.HeaderTimeout
+	if timeout == 0 {
+		timeout = s.readHeaderTimeout()
+	}
+	return &proxyProtoListener{Listener: l, cfg: s.ProxyProtocol, timeout: timeout}
+}
+
+// proxyProtoTLSListener marks a TLS listener whose underlying
+// listener is already a proxyProtoListener, as ServeTLS makes it.
+type proxyProtoTLSListener struct {
+	net.Listener
+}
+
+// proxyProtoListener is a listener whose connections from trusted
+// peers start with a PROXY header.
+type proxyProtoListener struct {
+	net.Listener
+	cfg     *ProxyProtocolConfig
+	timeout time.Duration // for reading the header
+}
+
+func (l *proxyProtoListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil || !l.cfg.trusted(c.RemoteAddr()) {
+		return c, err
+	}
+	return &proxyProtoConn{Conn: c, cfg: l.cfg, timeout: l.timeout}, nil
+}
+
+// proxyProtoConn is a connection starting with a PROXY header, which
+// is read on first use. Its addresses are those of the header.
+type proxyProtoConn struct {
+	net.Conn
+	cfg     *ProxyProtocolConfig
+	timeout time.Duration
+
+	mu           sync.Mutex
+	readDeadline time.Time // as last set; restored after the header
+
+	once   sync.Once
+	header *ProxyHeader
+	err    error
+	r      io.Reader // bytes after the header
+}
+
+func (c *proxyProtoConn) readHeader() (*ProxyHeader, error) {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			c.mu.Lock()
+			if d := time.Now().Add(c.timeout); c.readDeadline.IsZero() || d.Before(c.readDeadline) {
+				c.Conn.SetReadDeadline(d)
+			}
+			c.mu.Unlock()
+			defer func() {
+				c.mu.Lock()
+				defer c.mu.Unlock()
+				c.Conn.SetReadDeadline(c.readDeadline)
+			}()
+		}
+		var prefix []byte
+		c.header, prefix, c.err = readProxyHeader(c.Conn)
+		if c.err == nil && c.header == nil && c.cfg.Required {
+			c.err = errors.New("connection does not start with a PROXY header")
+		}
+		c.r = io.MultiReader(bytes.NewReader(prefix), c.Conn)
+	})
+	return c.header, c.err
+}
+
+func (c *proxyProtoConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetDeadline(t)
+}
+
+func (c *proxyProtoConn) SetReadDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.readDeadline = t
+	return c.Conn.SetReadDeadline(t)
+}
+
+func (c *proxyProtoConn) Read(p []byte) (int, error) {
+	if _, err := c.readHeader(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+func (c *proxyProtoConn) RemoteAddr() net.Addr {
+	if h, _ := c.readHeader(); h != nil && !h.Local && h.SourceAddr != nil {
+		return h.SourceAddr
+	}
+	return c.Conn.RemoteAddr()
+}
+
+func (c *proxyProtoConn) LocalAddr() net.Addr {
+	if h, _ := c.readHeader(); h != nil && !h.Local && h.DestAddr != nil {
+		return h.DestAddr
+	}
+	return c.Conn.LocalAddr()
+}
+
+// proxyHeaderOf reads the PROXY header of the connection rwc accepted
+// by a Server, if it has one.
+func proxyHeaderOf(rwc net.Conn) (*ProxyHeader, error) {
+	if nc, ok := rwc.(interface{ NetConn() net.Conn }); ok {
+		rwc = nc.NetConn()
+	}
+	if pc, ok := rwc.(*proxyProtoConn); ok {
+		return pc.readHeader()
+	}
+	return nil, nil
+}
+
+// writeProxyHeader writes the PROXY header given by
+// Transport.ProxyProtocolHeader to conn, a connection just dialed.
+func (t *Transport) writeProxyHeader(ctx context.Context, conn net.Conn) error {
+	if t.ProxyProtocolHeader == nil {
+		return nil
+	}
+	h, err := t.ProxyProtocolHeader(ctx, conn)
+	if err != nil || h == nil {
+		return err
+	}
+	if h.SourceAddr == nil && h.DestAddr == nil && !h.Local {
+		hc := *h
+		hc.SourceAddr, hc.DestAddr = conn.LocalAddr(), conn.RemoteAddr()
+		h = &hc
+	}
+	_, err = h.WriteTo(conn)
+	return err
+}
@@ -0,0 +1,234 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/internal/testcert"
+	tls "github.com/refraction-networking/utls"
+)
+
+func TestProxyHeaderRoundTrip(t *testing.T) {
+	src4 := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242}
+	dst4 := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 443}
+	src6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::7"), Port: 53}
+	dst6 := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5353}
+	ssl := []byte{0x01, 0, 0, 0, 0, ProxyTLVSSLVersion, 0, 7}
+	ssl = append(ssl, "TLSv1.3"...)
+
+	for _, h := range []*ProxyHeader{
+		{Version: 1, SourceAddr: src4, DestAddr: dst4},
+		{Version: 1},
+		{Version: 2, SourceAddr: src4, DestAddr: dst4},
+		{Version: 2, SourceAddr: src6, DestAddr: dst6, TLVs: []ProxyTLV{
+			{Type: ProxyTLVAuthority, Value: []byte("example.test")},
+			{Type: ProxyTLVALPN, Value: []byte("h2")},
+			{Type: ProxyTLVSSL, Value: ssl},
+			{Type: ProxyTLVCRC32C, Value: []byte{0, 0, 0, 0}},
+		}},
+		{Version: 2, SourceAddr: &net.UnixAddr{Name: "/run/a.sock", Net: "unix"}, DestAddr: &net.UnixAddr{Name: "/run/b.sock", Net: "unix"}},
+		{Version: 2, Local: true},
+	} {
+		var buf bytes.Buffer
+		if _, err := h.WriteTo(&buf); err != nil {
+			t.Fatalf("%+v: WriteTo: %v", h, err)
+		}
+		buf.WriteString("rest")
+		got, err := ReadProxyHeader(&buf)
+		if err != nil {
+			t.Fatalf("%+v: ReadProxyHeader: %v", h, err)
+		}
+		if buf.String() != "rest" {
+			t.Errorf("%+v: ReadProxyHeader left %q, want rest", h, buf.String())
+		}
+		if got.Version != h.Version || got.Local != h.Local ||
+			fmt.Sprint(got.SourceAddr, got.DestAddr) != fmt.Sprint(h.SourceAddr, h.DestAddr) ||
+			len(got.TLVs) != len(h.TLVs) {
+			t.Errorf("read %+v, wrote %+v", got, h)
+		}
+	}
+
+	h := &ProxyHeader{TLVs: []ProxyTLV{
+		{Type: ProxyTLVAuthority, Value: []byte("example.test")},
+		{Type: ProxyTLVSSL, Value: ssl},
+		{Type: ProxyTLVCRC32C},
+	}}
+	var buf bytes.Buffer
+	h.WriteTo(&buf)
+	b := buf.Bytes()
+	got, err := ReadProxyHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, ok := got.SSL()
+	if v, _ := got.TLV(ProxyTLVCRC32C); got.Authority() != "example.test" || !ok || st.Client != 1 ||
+		!reflect.DeepEqual(st.TLVs, []ProxyTLV{{Type: ProxyTLVSSLVersion, Value: []byte("TLSv1.3")}}) || len(v) != 4 {
+		t.Errorf("read %+v with SSL %+v", got, st)
+	}
+	b[len(b)-20] ^= 1 // corrupt the SSL TLV
+	if _, err := ReadProxyHeader(bytes.NewReader(b)); err == nil {
+		t.Error("ReadProxyHeader accepted a header with a bad checksum")
+	}
+	if _, err := ReadProxyHeader(bytes.NewReader([]byte("GET / HTTP/1.1\r\n"))); err == nil {
+		t.Error("ReadProxyHeader accepted a request without header")
+	}
+}
+
+// proxyProtocolServer starts srv on loopback, over TLS if useTLS is
+// set, and returns its address.
+func proxyProtocolServer(t *testing.T, srv *Server, useTLS bool) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv.ErrorLog = log.New(io.Discard, "", 0)
+	if useTLS {
+		cert, err := tls.X509KeyPair(testcert.LocalhostCert, testcert.LocalhostKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		go srv.ServeTLS(ln, "", "")
+	} else {
+		go srv.Serve(ln)
+	}
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+func TestProxyProtocolServer(t *testing.T) {
+	handler := HandlerFunc(func(w ResponseWriter, r *Request) {
+		var authority string
+		if h, ok := r.Context().Value(ProxyHeaderContextKey).(*ProxyHeader); ok {
+			authority = h.Authority()
+		}
+		fmt.Fprintf(w, "%s %v %s", r.RemoteAddr, r.Context().Value(LocalAddrContextKey), authority)
+	})
+	src := &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7).To4(), Port: 4242}
+	dst := &net.TCPAddr{IP: net.IPv4(192, 0, 2, 1).To4(), Port: 443}
+	loopback := []netip.Prefix{netip.MustParsePrefix("127.0.0.0/8")}
+
+	for _, tt := range []struct {
+		name    string
+		useTLS  bool
+		version int
+	}{
+		{"v1", false, 1},
+		{"v2", false, 2},
+		{"v2 TLS", true, 2},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := &Server{Handler: handler, ProxyProtocol: &ProxyProtocolConfig{TrustedSources: loopback, Required: true}}
+			addr := proxyProtocolServer(t, srv, tt.useTLS)
+
+			tr := &Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+				ProxyProtocolHeader: func(ctx context.Context, conn net.Conn) (*ProxyHeader, error) {
+					return &ProxyHeader{Version: tt.version, SourceAddr: src, DestAddr: dst, TLVs: []ProxyTLV{
+						{Type: ProxyTLVAuthority, Value: []byte("example.test")},
+					}}, nil
+				},
+			}
+			defer tr.CloseIdleConnections()
+			scheme := "http"
+			if tt.useTLS {
+				scheme = "https"
+			}
+			for range 2 { // the second request reuses the connection
+				resp, err := (&Client{Transport: tr}).Get(scheme + "://" + addr + "/")
+				if err != nil {
+					t.Fatal(err)
+				}
+				b, _ := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				want := "203.0.113.7:4242 192.0.2.1:443 example.test"
+				if tt.version == 1 {
+					want = "203.0.113.7:4242 192.0.2.1:443 " // no TLVs in v1
+				}
+				if string(b) != want {
+					t.Errorf("handler saw %q, want %q", b, want)
+				}
+			}
+
+			// Required: a connection without a header is refused.
+			if resp, err := (&Client{Transport: &Transport{TLSClientConfig: tr.TLSClientConfig}}).Get(scheme + "://" + addr + "/"); err == nil {
+				resp.Body.Close()
+				t.Errorf("request without a PROXY header: %s, want an error", resp.Status)
+			}
+		})
+	}
+}
+
+func TestProxyProtocolUntrustedSource(t *testing.T) {
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			io.WriteString(w, r.RemoteAddr)
+		}),
+		ProxyProtocol: &ProxyProtocolConfig{TrustedSources: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}},
+	}
+	addr := proxyProtocolServer(t, srv, false)
+
+	// Without a header, the request is served as usual.
+	resp, err := Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if host, _, _ := net.SplitHostPort(string(b)); host != "127.0.0.1" {
+		t.Errorf("RemoteAddr = %q, want the real one", b)
+	}
+
+	// A header from an untrusted peer is not believed.
+	tr := &Transport{ProxyProtocolHeader: func(ctx context.Context, conn net.Conn) (*ProxyHeader, error) {
+		return &ProxyHeader{Version: 1, SourceAddr: &net.TCPAddr{IP: net.IPv4(203, 0, 113, 7), Port: 1}, DestAddr: conn.RemoteAddr()}, nil
+	}}
+	defer tr.CloseIdleConnections()
+	resp, err = (&Client{Transport: tr}).Get("http://" + addr + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusBadRequest {
+		t.Errorf("PROXY header from an untrusted peer: %s, want 400", resp.Status)
+	}
+}
+
+func TestProxyProtocolConfig(t *testing.T) {
+	// Trusting every peer must be asked for.
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &Server{ProxyProtocol: &ProxyProtocolConfig{Required: true}}
+	if err := srv.Serve(ln); err == nil || err == ErrServerClosed {
+		t.Errorf("Serve with no TrustedSources = %v, want a configuration error", err)
+	}
+
+	// Without HeaderTimeout, ReadHeaderTimeout limits the wait for the
+	// header.
+	srv = &Server{
+		Handler:           HandlerFunc(func(ResponseWriter, *Request) {}),
+		ReadHeaderTimeout: 50 * time.Millisecond,
+		ProxyProtocol:     &ProxyProtocolConfig{TrustAll: true},
+	}
+	addr := proxyProtocolServer(t, srv, false)
+	c, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := c.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("idle connection read %v, want the server to close it", err)
+	}
+}
@@ -0,0 +1,79 @@
+package publicsuffix
+
+import (
+	"strings"
+	"testing"
+)
+
+var publicSuffixTests = []struct {
+	domain string
+	want   string
+	icann  bool
+}{
+	{"", "", false},
+	{"com", "com", true},
+	{"example.com", "com", true},
+	{"www.example.co.uk", "co.uk", true},
+	{"foo.example.unlisted", "unlisted", false},
+
+	// Wildcard and exception rules: "*.ck" and "!www.ck".
+	{"ck", "ck", false},
+	{"foo.ck", "foo.ck", true},
+	{"bar.foo.ck", "foo.ck", true},
+	{"www.ck", "ck", true},
+	{"a.www.ck", "ck", true},
+
+	// Wildcard rule "*.kawasaki.jp" next to the normal rule "jp".
+	{"kawasaki.jp", "jp", true},
+	{"foo.kawasaki.jp", "foo.kawasaki.jp", true},
+	{"city.kawasaki.jp", "kawasaki.jp", true},
+
+	// The private section.
+	{"github.io", "github.io", false},
+	{"foo.github.io", "github.io", false},
+	{"blogspot.com", "blogspot.com", false},
+
+	// Internationalized rules are stored as Punycode, e.g. "公司.cn".
+	{"foo.xn--55qx5d.cn", "xn--55qx5d.cn", true},
+}
+
+func TestPublicSuffix(t *testing.T) {
+	for _, tt := range publicSuffixTests {
+		got, icann := PublicSuffix(tt.domain)
+		if got != tt.want || icann != tt.icann {
+			t.Errorf("PublicSuffix(%q) = %q, %v; want %q, %v", tt.domain, got, icann, tt.want, tt.icann)
+		}
+		if got := List.PublicSuffix(tt.domain); got != tt.want {
+			t.Errorf("List.PublicSuffix(%q) = %q, want %q", tt.domain, got, tt.want)
+		}
+	}
+}
+
+func TestEffectiveTLDPlusOne(t *testing.T) {
+	tests := []struct {
+		domain, want string
+	}{
+		{"", ""},
+		{".com", ""},
+		{"com", ""},
+		{"a..b.com", ""},
+		{"example.com.", ""},
+		{"example.com", "example.com"},
+		{"b.example.co.uk", "example.co.uk"},
+		{"a.b.foo.ck", "b.foo.ck"},
+		{"www.ck", "www.ck"},
+		{"user.github.io", "user.github.io"},
+	}
+	for _, tt := range tests {
+		got, err := EffectiveTLDPlusOne(tt.domain)
+		if got != tt.want || (err != nil) != (tt.want == "") {
+			t.Errorf("EffectiveTLDPlusOne(%q) = %q, %v; want %q", tt.domain, got, err, tt.want)
+		}
+	}
+}
+
+func TestVersion(t *testing.T) {
+	if v := List.String(); !strings.HasPrefix(v, "publicsuffix.org's public_suffix_list.dat, ") || !strings.Contains(v, "sha256 ") {
+		t.Errorf("List.String() = %q", v)
+	}
+}
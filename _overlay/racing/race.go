@@ -77,6 +77,7 @@ type Engine struct {
 
 	conn   net.Conn
 	framer *http2.Framer
+	in     *frameCapture // the framer's reader
 
 	writeMu  sync.Mutex // serialises framer writes
 	hpackBuf *bytes.Buffer
@@ -89,8 +90,16 @@ type Engine struct {
 	serverSetup chan struct{}
 	closed      chan struct{}
 	closeErr    error
+	frameLog    bool             // keep frames, set by WithFrameLog
+	frames      []*ReceivedFrame // every frame received, for Frames and WaitFrame
+	frameAdded  chan struct{}    // closed and replaced when a frame is added
 }
 
+// maxHeaderBlockSize bounds the header block, HEADERS or PUSH_PROMISE
+// fragment and CONTINUATION fragments together, that the read loop
+// buffers until END_HEADERS. A server sending more closes the Engine.
+const maxHeaderBlockSize = 1 << 20
+
 // streamState is the in-flight state for one HTTP/2 stream.
 type streamState struct {
 	id          uint32
@@ -119,9 +128,10 @@ func (st *streamState) finish(err error) {
 type Option func(*engineOpts)
 
 type engineOpts struct {
-	helloID tls.ClientHelloID
-	tlsConf *tls.Config
-	dial    func(context.Context, string) (net.Conn, error)
+	helloID  tls.ClientHelloID
+	tlsConf  *tls.Config
+	dial     func(context.Context, string) (net.Conn, error)
+	frameLog bool
 }
 
 // WithHelloID sets the utls ClientHello fingerprint. Defaults to
@@ -143,6 +153,27 @@ func WithDialer(d func(context.Context, string) (net.Conn, error)) Option {
 	return func(o *engineOpts) { o.dial = d }
 }
 
+// WithTCPProfile dials with the default 10s-timeout net.Dialer configured
+// by p, so the engine's TCP/IP fingerprint can match its ClientHello
+// (see http.TCPProfile). It replaces any earlier WithDialer. The Engine
+// still disables Nagle's algorithm, whatever p.Nagle says, so that a
+// gate's release is not delayed.
+func WithTCPProfile(p *http.TCPProfile) Option {
+	return func(o *engineOpts) {
+		dial := p.DialContextFunc(&net.Dialer{Timeout: 10 * time.Second})
+		o.dial = func(ctx context.Context, addr string) (net.Conn, error) {
+			return dial(ctx, "tcp", addr)
+		}
+	}
+}
+
+// WithFrameLog makes an Engine keep every frame it receives, response
+// bodies included, for Engine.Frames and Engine.WaitFrame. Without it,
+// received frames are not kept. H1Engine ignores it.
+func WithFrameLog() Option {
+	return func(o *engineOpts) { o.frameLog = true }
+}
+
 // NewEngine opens a TLS+h2 connection to target, completes the HTTP/2
 // preface + SETTINGS exchange, and returns a ready Engine. target must be
 // an https:// URL; the path is ignored, only host:port is used.
@@ -210,12 +241,15 @@ func NewEngine(target string, opts ...Option) (*Engine, error) {
 		helloID:     o.helloID,
 		tlsConf:     o.tlsConf,
 		conn:        tconn,
-		framer:      http2.NewFramer(tconn, bufio.NewReader(tconn)),
+		in:          &frameCapture{r: bufio.NewReader(tconn)},
 		hpackBuf:    new(bytes.Buffer),
 		pending:     make(map[uint32]*streamState),
 		serverSetup: make(chan struct{}),
 		closed:      make(chan struct{}),
+		frameLog:    o.frameLog,
+		frameAdded:  make(chan struct{}),
 	}
+	e.framer = http2.NewFramer(tconn, e.in)
 	e.hpackEnc = hpack.NewEncoder(e.hpackBuf)
 	e.nextSID.Store(1)
 
@@ -574,14 +608,60 @@ func (e *Engine) readLoop() {
 
 	hdec := hpack.NewDecoder(4096, nil)
 
+	// A header block starts in a HEADERS or PUSH_PROMISE frame and may
+	// continue in CONTINUATION frames; it is decoded once complete, for
+	// every stream, to keep the decoder in step with the server.
+	var (
+		block          []byte
+		blockStream    uint32 // 0 for PUSH_PROMISE, which is not dispatched
+		blockEndStream bool
+	)
+
 	for {
+		e.in.buf = e.in.buf[:0]
 		frame, err := e.framer.ReadFrame()
+		now := time.Now()
 		if err != nil {
+			// Record the frame even if the Framer rejected it.
+			e.recordFrame(now, nil)
 			e.mu.Lock()
 			e.closeErr = fmt.Errorf("racing: read frame: %w", err)
 			e.mu.Unlock()
 			return
 		}
+
+		var (
+			fields     []hpack.HeaderField
+			fieldsErr  error
+			endHeaders bool
+		)
+		switch f := frame.(type) {
+		case *http2.HeadersFrame:
+			block = append(block[:0], f.HeaderBlockFragment()...)
+			blockStream, blockEndStream, endHeaders = f.StreamID, f.StreamEnded(), f.HeadersEnded()
+		case *http2.PushPromiseFrame:
+			block = append(block[:0], f.HeaderBlockFragment()...)
+			blockStream, blockEndStream, endHeaders = 0, false, f.HeadersEnded()
+		case *http2.ContinuationFrame:
+			block = append(block, f.HeaderBlockFragment()...)
+			endHeaders = f.HeadersEnded()
+		}
+		if len(block) > maxHeaderBlockSize {
+			e.recordFrame(now, nil)
+			e.mu.Lock()
+			e.closeErr = fmt.Errorf("racing: header block exceeds %d bytes", maxHeaderBlockSize)
+			e.mu.Unlock()
+			return
+		}
+		if endHeaders {
+			fields, fieldsErr = hdec.DecodeFull(block)
+		}
+		e.recordFrame(now, fields)
+
+		if endHeaders && blockStream != 0 {
+			e.gotHeaders(blockStream, blockEndStream, fields, fieldsErr)
+		}
+
 		switch f := frame.(type) {
 		case *http2.SettingsFrame:
 			if f.IsAck() {
@@ -617,33 +697,6 @@ func (e *Engine) readLoop() {
 			// flow control: we let the connection window drift; for very
 			// large responses the connection would stall. Acceptable for
 			// race-testing where bodies are usually small.
-		case *http2.HeadersFrame:
-			e.mu.Lock()
-			st := e.pending[f.StreamID]
-			e.mu.Unlock()
-			if st == nil {
-				continue
-			}
-			fields, err := hdec.DecodeFull(f.HeaderBlockFragment())
-			if err != nil {
-				st.finish(fmt.Errorf("hpack decode: %w", err))
-				continue
-			}
-			st.respHeaders = make(http.Header)
-			for _, hf := range fields {
-				if hf.Name == ":status" {
-					code, _ := strconv.Atoi(hf.Value)
-					st.respStatus = code
-					continue
-				}
-				if strings.HasPrefix(hf.Name, ":") {
-					continue
-				}
-				st.respHeaders.Add(hf.Name, hf.Value)
-			}
-			if f.StreamEnded() {
-				st.finish(nil)
-			}
 		case *http2.DataFrame:
 			e.mu.Lock()
 			st := e.pending[f.StreamID]
@@ -671,3 +724,33 @@ func (e *Engine) readLoop() {
 		}
 	}
 }
+
+// gotHeaders delivers a response header block to its stream, if the
+// stream belongs to a Gate.
+func (e *Engine) gotHeaders(streamID uint32, endStream bool, fields []hpack.HeaderField, err error) {
+	e.mu.Lock()
+	st := e.pending[streamID]
+	e.mu.Unlock()
+	if st == nil {
+		return
+	}
+	if err != nil {
+		st.finish(fmt.Errorf("hpack decode: %w", err))
+		return
+	}
+	st.respHeaders = make(http.Header)
+	for _, hf := range fields {
+		if hf.Name == ":status" {
+			code, _ := strconv.Atoi(hf.Value)
+			st.respStatus = code
+			continue
+		}
+		if strings.HasPrefix(hf.Name, ":") {
+			continue
+		}
+		st.respHeaders.Add(hf.Name, hf.Value)
+	}
+	if endStream {
+		st.finish(nil)
+	}
+}
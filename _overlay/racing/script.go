@@ -0,0 +1,334 @@
+package racing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// A Script is a sequence of HTTP/2 frames written to an Engine's
+// connection in one TCP write, for reproducing server bugs at the frame
+// level: CONTINUATION floods, rapid reset, oversized or padded DATA,
+// malformed pseudo-header sets and the like. Unlike Gate, a Script
+// writes frames exactly as described, skipping the checks http2.Framer
+// normally applies, and does not track the streams it opens; use
+// Engine.Frames and Engine.WaitFrame, on an Engine made WithFrameLog, to
+// observe what the server sends back.
+//
+// Build a script with its methods, then call Send:
+//
+//	id := eng.NewStreamID()
+//	s := eng.NewScript()
+//	s.Headers(racing.HeadersParam{StreamID: id, Fields: fields, EndStream: true})
+//	s.RSTStream(id, http2.ErrCodeCancel)
+//	err := s.Send()
+//
+// A Script is single-use and, like Gate, must be used from one goroutine.
+type Script struct {
+	engine *Engine
+	ops    []func(fr *http2.Framer) error
+	sent   bool
+}
+
+// HeadersParam describes a header block written by Script.Headers.
+type HeadersParam struct {
+	// StreamID is the stream of the HEADERS frame. It need not be a
+	// valid client stream ID.
+	StreamID uint32
+
+	// Fields are HPACK-encoded in order with the connection's encoder.
+	// They are sent as given: pseudo-header fields may be missing,
+	// repeated, unknown or placed after regular fields, and names are not
+	// lower-cased.
+	Fields []hpack.HeaderField
+
+	// Block, if not nil, is sent as the header block instead of Fields,
+	// for blocks that are not valid HPACK. The server's HPACK decoder
+	// may disagree with the Engine's encoder afterwards.
+	Block []byte
+
+	// FragmentSize, if positive, splits the header block into a HEADERS
+	// frame and CONTINUATION frames carrying at most FragmentSize bytes
+	// of it each.
+	FragmentSize int
+
+	// NoEndHeaders leaves END_HEADERS off the last frame, so the header
+	// block stays open for Script.Continuation frames.
+	NoEndHeaders bool
+
+	EndStream bool
+	PadLength uint8
+	Priority  http2.PriorityParam
+}
+
+// NewScript returns an empty Script bound to this engine.
+func (e *Engine) NewScript() *Script {
+	return &Script{engine: e}
+}
+
+// NewStreamID reserves the next client stream ID, so that scripted
+// streams do not collide with those of gates on the same Engine.
+func (e *Engine) NewStreamID() uint32 {
+	return e.nextSID.Add(2) - 2
+}
+
+// Headers adds a header block split into a HEADERS frame and, with
+// p.FragmentSize, CONTINUATION frames.
+func (s *Script) Headers(p HeadersParam) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		block := p.Block
+		if block == nil {
+			block = s.engine.encodeFields(p.Fields)
+		}
+		split := func(b []byte) (frag, rest []byte) {
+			if p.FragmentSize > 0 && len(b) > p.FragmentSize {
+				return b[:p.FragmentSize], b[p.FragmentSize:]
+			}
+			return b, nil
+		}
+		frag, rest := split(block)
+		err := fr.WriteHeaders(http2.HeadersFrameParam{
+			StreamID:      p.StreamID,
+			BlockFragment: frag,
+			EndStream:     p.EndStream,
+			EndHeaders:    len(rest) == 0 && !p.NoEndHeaders,
+			PadLength:     p.PadLength,
+			Priority:      p.Priority,
+		})
+		for err == nil && len(rest) > 0 {
+			frag, rest = split(rest)
+			err = fr.WriteContinuation(p.StreamID, len(rest) == 0 && !p.NoEndHeaders, frag)
+		}
+		return err
+	})
+}
+
+// Continuation adds a CONTINUATION frame carrying fragment, a raw piece
+// of header block.
+func (s *Script) Continuation(streamID uint32, endHeaders bool, fragment []byte) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteContinuation(streamID, endHeaders, fragment)
+	})
+}
+
+// Data adds a DATA frame. If pad is not nil, the frame is padded with
+// it; its bytes need not be zero. data may exceed the server's
+// SETTINGS_MAX_FRAME_SIZE.
+func (s *Script) Data(streamID uint32, endStream bool, data, pad []byte) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteDataPadded(streamID, endStream, data, pad)
+	})
+}
+
+// Priority adds a PRIORITY frame.
+func (s *Script) Priority(streamID uint32, p http2.PriorityParam) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WritePriority(streamID, p)
+	})
+}
+
+// RSTStream adds a RST_STREAM frame.
+func (s *Script) RSTStream(streamID uint32, code http2.ErrCode) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteRSTStream(streamID, code)
+	})
+}
+
+// Settings adds a SETTINGS frame. The Engine itself keeps assuming the
+// HTTP/2 defaults.
+func (s *Script) Settings(settings ...http2.Setting) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteSettings(settings...)
+	})
+}
+
+// Ping adds a PING frame.
+func (s *Script) Ping(data [8]byte) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WritePing(false, data)
+	})
+}
+
+// WindowUpdate adds a WINDOW_UPDATE frame; incr may be zero or too large.
+func (s *Script) WindowUpdate(streamID, incr uint32) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteWindowUpdate(streamID, incr)
+	})
+}
+
+// GoAway adds a GOAWAY frame.
+func (s *Script) GoAway(lastStreamID uint32, code http2.ErrCode, debugData []byte) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteGoAway(lastStreamID, code, debugData)
+	})
+}
+
+// Raw adds a frame with an arbitrary type, flags and payload.
+func (s *Script) Raw(t http2.FrameType, flags http2.Flags, streamID uint32, payload []byte) {
+	s.ops = append(s.ops, func(fr *http2.Framer) error {
+		return fr.WriteRawFrame(t, flags, streamID, payload)
+	})
+}
+
+// Send writes all the script's frames to the connection in a single
+// Conn.Write. Header blocks are encoded now, in order with those of the
+// Engine's gates, so that the server's HPACK state stays in step.
+func (s *Script) Send() error {
+	if s.sent {
+		return errors.New("racing: script already sent")
+	}
+	s.sent = true
+	if len(s.ops) == 0 {
+		return errors.New("racing: script has no frames")
+	}
+
+	var buf bytes.Buffer
+	fr := http2.NewFramer(&buf, nil)
+	fr.AllowIllegalWrites = true
+
+	e := s.engine
+	e.writeMu.Lock()
+	defer e.writeMu.Unlock()
+	for _, op := range s.ops {
+		if err := op(fr); err != nil {
+			return fmt.Errorf("racing: script: %w", err)
+		}
+	}
+	if _, err := e.conn.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("racing: script write: %w", err)
+	}
+	return nil
+}
+
+// encodeFields HPACK-encodes fields with the connection's encoder. The
+// caller must hold e.writeMu.
+func (e *Engine) encodeFields(fields []hpack.HeaderField) []byte {
+	e.hpackBuf.Reset()
+	for _, f := range fields {
+		e.hpackEnc.WriteField(f)
+	}
+	return append([]byte(nil), e.hpackBuf.Bytes()...)
+}
+
+// A ReceivedFrame is a frame the Engine read from the server.
+type ReceivedFrame struct {
+	http2.FrameHeader
+
+	// Time is when the frame was read.
+	Time time.Time
+
+	// Payload is the frame payload as received, padding included.
+	Payload []byte
+
+	// Fields is the decoded header block, set on the frame that ends
+	// one: a HEADERS or PUSH_PROMISE frame with END_HEADERS, or the last
+	// CONTINUATION frame. It is nil if the block failed to decode.
+	Fields []hpack.HeaderField
+}
+
+// Parse parses the frame. The result stays valid indefinitely; frames
+// the Engine rejected, such as a CONTINUATION frame out of sequence,
+// parse as well.
+func (f *ReceivedFrame) Parse() (http2.Frame, error) {
+	var buf bytes.Buffer
+	w := http2.NewFramer(&buf, nil)
+	w.AllowIllegalWrites = true
+	if err := w.WriteRawFrame(f.Type, f.Flags, f.StreamID, f.Payload); err != nil {
+		return nil, err
+	}
+	r := http2.NewFramer(nil, &buf)
+	r.AllowIllegalReads = true
+	return r.ReadFrame()
+}
+
+// Frames returns every frame received on the connection so far, in
+// order. The Engine keeps them for its whole life, response bodies
+// included, if made WithFrameLog; otherwise Frames returns nil.
+func (e *Engine) Frames() []*ReceivedFrame {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]*ReceivedFrame(nil), e.frames...)
+}
+
+// WaitFrame returns the first frame received on the connection for
+// which match reports true, waiting for it if no frame received so far
+// matches. It fails once ctx is done or the connection is closed, and
+// at once if the Engine was not made WithFrameLog.
+func (e *Engine) WaitFrame(ctx context.Context, match func(*ReceivedFrame) bool) (*ReceivedFrame, error) {
+	if !e.frameLog {
+		return nil, errors.New("racing: WaitFrame needs an Engine made WithFrameLog")
+	}
+	next := 0
+	for {
+		e.mu.Lock()
+		frames, added := e.frames[next:], e.frameAdded
+		next = len(e.frames)
+		e.mu.Unlock()
+		for _, f := range frames {
+			if match(f) {
+				return f, nil
+			}
+		}
+
+		select {
+		case <-added:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.closed:
+			// The read loop records its last frame before closing.
+			e.mu.Lock()
+			frames, err := e.frames[next:], e.closeErr
+			e.mu.Unlock()
+			for _, f := range frames {
+				if match(f) {
+					return f, nil
+				}
+			}
+			return nil, fmt.Errorf("racing: engine closed: %w", err)
+		}
+	}
+}
+
+// frameCapture records the bytes the read loop's Framer reads, which
+// are exactly those of the last frame read, since Framer.ReadFrame reads
+// a frame header and then its payload with io.ReadFull.
+type frameCapture struct {
+	r   io.Reader
+	buf []byte
+}
+
+func (c *frameCapture) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.buf = append(c.buf, p[:n]...)
+	return n, err
+}
+
+// recordFrame adds the frame last read, whose header block decoded to
+// fields, to e.frames. It does nothing without a frame log or if the
+// read did not complete a frame.
+func (e *Engine) recordFrame(t time.Time, fields []hpack.HeaderField) {
+	if !e.frameLog {
+		return
+	}
+	fh, err := http2.ReadFrameHeader(bytes.NewReader(e.in.buf))
+	if err != nil || len(e.in.buf) != 9+int(fh.Length) {
+		return
+	}
+	f := &ReceivedFrame{
+		FrameHeader: fh,
+		Time:        t,
+		Payload:     append([]byte(nil), e.in.buf[9:]...),
+		Fields:      fields,
+	}
+	e.mu.Lock()
+	e.frames = append(e.frames, f)
+	close(e.frameAdded)
+	e.frameAdded = make(chan struct{})
+	e.mu.Unlock()
+}
@@ -0,0 +1,291 @@
+package racing_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dteh/dhttp/racing"
+	tls "github.com/refraction-networking/utls"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/hpack"
+)
+
+// startH2FrameLogServer starts an in-process HTTP/2 server that logs
+// the frames it reads after the connection preface, with the fields of
+// each complete header block. Once it reads a RST_STREAM frame it sends
+// the log, answers stream 1 with a header block split across HEADERS and
+// CONTINUATION, some DATA and a GOAWAY.
+func startH2FrameLogServer(t *testing.T) (string, <-chan []string) {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{localhostCert(t)},
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	logged := make(chan []string, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		preface := make([]byte, len(http2.ClientPreface))
+		if _, err := io.ReadFull(conn, preface); err != nil {
+			return
+		}
+		framer := http2.NewFramer(conn, conn)
+		framer.AllowIllegalReads = true
+		if err := framer.WriteSettings(); err != nil {
+			return
+		}
+
+		dec := hpack.NewDecoder(4096, nil)
+		var log []string
+		var block []byte
+		for {
+			frame, err := framer.ReadFrame()
+			if err != nil {
+				return
+			}
+			switch f := frame.(type) {
+			case *http2.SettingsFrame:
+				log = append(log, fmt.Sprintf("SETTINGS ack=%v", f.IsAck()))
+				if !f.IsAck() {
+					framer.WriteSettingsAck()
+				}
+			case *http2.HeadersFrame:
+				log = append(log, fmt.Sprintf("HEADERS stream=%d weight=%d", f.StreamID, f.Priority.Weight))
+				block = append(block[:0], f.HeaderBlockFragment()...)
+			case *http2.ContinuationFrame:
+				if log[len(log)-1] != "CONTINUATION" {
+					log = append(log, "CONTINUATION")
+				}
+				block = append(block, f.HeaderBlockFragment()...)
+				if f.HeadersEnded() {
+					fields, _ := dec.DecodeFull(block)
+					log = append(log, fmt.Sprint(fields))
+				}
+			case *http2.DataFrame:
+				log = append(log, fmt.Sprintf("DATA %q padded=%v", f.Data(), f.Flags.Has(http2.FlagDataPadded)))
+			default:
+				log = append(log, f.Header().Type.String())
+			}
+			if _, ok := frame.(*http2.RSTStreamFrame); !ok {
+				continue
+			}
+
+			logged <- log
+			var hbuf bytes.Buffer
+			enc := hpack.NewEncoder(&hbuf)
+			enc.WriteField(hpack.HeaderField{Name: ":status", Value: "200"})
+			enc.WriteField(hpack.HeaderField{Name: "x-a", Value: "1"})
+			framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: hbuf.Bytes()[:1]})
+			framer.WriteContinuation(1, true, hbuf.Bytes()[1:])
+			framer.WriteData(1, true, []byte("ok"))
+			framer.WriteGoAway(1, http2.ErrCodeEnhanceYourCalm, []byte("bye"))
+		}
+	}()
+
+	return "https://" + listener.Addr().String(), logged
+}
+
+func TestScript(t *testing.T) {
+	url, logged := startH2FrameLogServer(t)
+	eng, err := racing.NewEngine(url, racing.WithTLSConfig(insecureTLSConfig()), racing.WithFrameLog())
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	defer eng.Close()
+
+	id := eng.NewStreamID()
+	s := eng.NewScript()
+	s.Settings(http2.Setting{ID: http2.SettingInitialWindowSize, Val: 1 << 20})
+	s.Headers(racing.HeadersParam{
+		StreamID: id,
+		Fields: []hpack.HeaderField{
+			{Name: ":method", Value: "POST"},
+			{Name: ":path", Value: "/a"},
+			{Name: "X-Upper", Value: "1"},
+			{Name: ":path", Value: "/b"},
+		},
+		FragmentSize: 4,
+		Priority:     http2.PriorityParam{Weight: 41},
+	})
+	s.Data(id, false, []byte("hello"), []byte{1, 2, 3})
+	s.Priority(id, http2.PriorityParam{Weight: 7})
+	s.Raw(0xfa, 0, id, []byte("x"))
+	s.RSTStream(id, http2.ErrCodeCancel)
+	if err := s.Send(); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := s.Send(); err == nil {
+		t.Error("second Send succeeded")
+	}
+
+	want := []string{
+		"SETTINGS ack=false", // the Engine's preface
+		"SETTINGS ack=true",
+		"SETTINGS ack=false",
+		"HEADERS stream=1 weight=41",
+		"CONTINUATION",
+		`[header field ":method" = "POST" header field ":path" = "/a" header field "X-Upper" = "1" header field ":path" = "/b"]`,
+		`DATA "hello" padded=true`,
+		"PRIORITY",
+		"UNKNOWN_FRAME_TYPE_250",
+		"RST_STREAM",
+	}
+	select {
+	case got := <-logged:
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Errorf("server read frames:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timeout waiting for the server")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	goAway, err := eng.WaitFrame(ctx, func(f *racing.ReceivedFrame) bool {
+		return f.Type == http2.FrameGoAway
+	})
+	if err != nil {
+		t.Fatalf("WaitFrame: %v", err)
+	}
+	parsed, err := goAway.Parse()
+	if ga, ok := parsed.(*http2.GoAwayFrame); err != nil || !ok || ga.ErrCode != http2.ErrCodeEnhanceYourCalm || string(ga.DebugData()) != "bye" {
+		t.Errorf("GOAWAY parsed as %v, %v", parsed, err)
+	}
+
+	var got []string
+	var last time.Time
+	for _, f := range eng.Frames() {
+		if f.Time.Before(last) {
+			t.Errorf("frame %v received at %v, before the previous one", f.FrameHeader, f.Time)
+		}
+		last = f.Time
+		if f.StreamID != id {
+			continue
+		}
+		desc := f.Type.String()
+		if f.Fields != nil {
+			desc += fmt.Sprint(" ", f.Fields)
+		}
+		if f.Type == http2.FrameData {
+			desc += fmt.Sprintf(" %q", f.Payload)
+		}
+		got = append(got, desc)
+	}
+	wantRecv := []string{
+		"HEADERS",
+		`CONTINUATION [header field ":status" = "200" header field "x-a" = "1"]`,
+		`DATA "ok"`,
+	}
+	if strings.Join(got, "\n") != strings.Join(wantRecv, "\n") {
+		t.Errorf("frames received on stream %d:\n%s\nwant:\n%s", id, strings.Join(got, "\n"), strings.Join(wantRecv, "\n"))
+	}
+}
+
+// startContinuationFloodServer starts an in-process HTTP/2 server that,
+// on each connection, answers a PING with a header block on stream 1
+// that never ends, made of CONTINUATION frames totalling more than
+// 1 MiB.
+func startContinuationFloodServer(t *testing.T) string {
+	t.Helper()
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{localhostCert(t)},
+		NextProtos:   []string{"h2"},
+	})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				preface := make([]byte, len(http2.ClientPreface))
+				if _, err := io.ReadFull(conn, preface); err != nil {
+					return
+				}
+				framer := http2.NewFramer(conn, conn)
+				if err := framer.WriteSettings(); err != nil {
+					return
+				}
+				for {
+					frame, err := framer.ReadFrame()
+					if err != nil {
+						return
+					}
+					if f, ok := frame.(*http2.SettingsFrame); ok && !f.IsAck() {
+						framer.WriteSettingsAck()
+					}
+					if _, ok := frame.(*http2.PingFrame); !ok {
+						continue
+					}
+					junk := make([]byte, 16<<10)
+					framer.WriteHeaders(http2.HeadersFrameParam{StreamID: 1, BlockFragment: junk})
+					for range 70 {
+						if err := framer.WriteContinuation(1, false, junk); err != nil {
+							return
+						}
+					}
+				}
+			}()
+		}
+	}()
+	return "https://" + listener.Addr().String()
+}
+
+func TestEngineHeaderBlockLimit(t *testing.T) {
+	url := startContinuationFloodServer(t)
+	eng, err := racing.NewEngine(url, racing.WithTLSConfig(insecureTLSConfig()), racing.WithFrameLog())
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	defer eng.Close()
+
+	s := eng.NewScript()
+	s.Ping([8]byte{})
+	if err := s.Send(); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = eng.WaitFrame(ctx, func(*racing.ReceivedFrame) bool { return false })
+	if err == nil || !strings.Contains(err.Error(), "header block exceeds") {
+		t.Errorf("WaitFrame during a CONTINUATION flood: %v, want the header block limit", err)
+	}
+	if n := len(eng.Frames()); n > 100 {
+		t.Errorf("engine kept %d frames, want it closed at the limit", n)
+	}
+}
+
+func TestEngineWithoutFrameLog(t *testing.T) {
+	url := startContinuationFloodServer(t)
+	eng, err := racing.NewEngine(url, racing.WithTLSConfig(insecureTLSConfig()))
+	if err != nil {
+		t.Fatalf("NewEngine: %v", err)
+	}
+	defer eng.Close()
+
+	if f := eng.Frames(); f != nil {
+		t.Errorf("Frames() = %d frames without WithFrameLog, want nil", len(f))
+	}
+	if _, err := eng.WaitFrame(context.Background(), func(*racing.ReceivedFrame) bool { return true }); err == nil {
+		t.Error("WaitFrame without WithFrameLog succeeded, want error")
+	}
+}
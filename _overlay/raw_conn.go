@@ -0,0 +1,147 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	tls "github.com/refraction-networking/utls"
+)
+
+// A RawConn is an HTTP/1.1 connection on which the caller writes requests
+// as exact byte sequences, for request smuggling and parser differential
+// testing. Nothing written to it is validated or normalized: conflicting
+// Content-Length and Transfer-Encoding headers, obs-fold, bare LF line
+// endings and duplicate Host headers all reach the server as written.
+//
+// RawConn is deliberately separate from the Transport's request path,
+// which must keep refusing such requests. Use it only against servers
+// you are authorized to test.
+//
+// A RawConn is not safe for concurrent use.
+type RawConn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	tlsState *tls.ConnectionState
+}
+
+// DialRaw dials a new connection for raw HTTP/1.1 requests to address, a
+// "host:port" pair, the way the Transport dials connections for requests
+// with the given scheme, "http" or "https". It uses the Transport's
+// dialer, TCPProfile, Proxy, ProxyChain and ClientHelloSettings, and for
+// "https" the parroted ClientHello offers only HTTP/1.1 in ALPN.
+//
+// If the Transport uses an HTTP proxy for an "http" address, the
+// connection is to the proxy, and requests written to it should use the
+// absolute-form request-target. HTTPS and SOCKS proxies tunnel to address.
+//
+// The connection is not part of the Transport's pool. The caller must
+// close it.
+func (t *Transport) DialRaw(ctx context.Context, scheme, address string) (*RawConn, error) {
+	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+
+	switch scheme {
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("net/http: invalid scheme %q", scheme)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if port == "" {
+		port = schemePort(scheme)
+	}
+
+	cm := connectMethod{
+		targetScheme: scheme,
+		targetAddr:   net.JoinHostPort(host, port),
+		onlyH1:       true,
+		raw:          true,
+	}
+	if t.Proxy != nil || t.ProxyChain != nil {
+		// Transport.Proxy takes a *Request, so create one to pass it.
+		req, err := NewRequestWithContext(ctx, "GET", (&url.URL{Scheme: scheme, Host: cm.targetAddr, Path: "/"}).String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		if cm.proxyURL, cm.proxyChain, err = t.proxiesForRequest(req); err != nil {
+			return nil, err
+		}
+	}
+
+	pconn, err := t.dialConn(ctx, cm, false, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &RawConn{
+		conn:     pconn.conn,
+		br:       bufio.NewReader(pconn.conn),
+		tlsState: pconn.tlsState,
+	}, nil
+}
+
+// Write writes b to the connection exactly as given.
+func (c *RawConn) Write(b []byte) (int, error) {
+	return c.conn.Write(b)
+}
+
+// ReadResponse reads the next response from the connection with
+// ReadResponse and reads its body into memory, so that the following
+// response can be read next. req is the request the response answers,
+// which determines for instance that a response to HEAD has no body; nil
+// means a GET request.
+//
+// The returned Response's Body can be read after the connection is
+// closed. For a response whose body is delimited by the end of the
+// connection, ReadResponse reads until the server closes it.
+func (c *RawConn) ReadResponse(req *Request) (*Response, error) {
+	resp, err := ReadResponse(c.br, req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, err
+}
+
+// Leftover returns the bytes the server has sent that no ReadResponse
+// call has consumed, such as the response to a smuggled request. It
+// waits up to wait for more bytes, returning early if the server closes
+// the connection.
+func (c *RawConn) Leftover(wait time.Duration) ([]byte, error) {
+	if err := c.conn.SetReadDeadline(time.Now().Add(wait)); err != nil {
+		return nil, err
+	}
+	defer c.conn.SetReadDeadline(time.Time{})
+	b, err := io.ReadAll(c.br)
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		err = nil
+	}
+	return b, err
+}
+
+// ConnectionState returns the state of the TLS connection, or nil for a
+// connection without TLS.
+func (c *RawConn) ConnectionState() *tls.ConnectionState {
+	return c.tlsState
+}
+
+// NetConn returns the underlying connection, a *tls.UConn for HTTPS. Reads
+// from it bypass the bytes already buffered by ReadResponse.
+func (c *RawConn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Close closes the connection.
+func (c *RawConn) Close() error {
+	return c.conn.Close()
+}
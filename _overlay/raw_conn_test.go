@@ -0,0 +1,98 @@
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+)
+
+func TestRawConnHTTP1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	const raw = "POST / HTTP/1.1\r\nHost: a\r\nHost: b\r\nContent-Length: 4\r\nTransfer-Encoding: chunked\r\nX-Fold: 1\r\n 2\n\r\n0\r\n\r\n" +
+		"GET /second HTTP/1.1\r\nHost: a\r\n\r\n"
+	got := make(chan []byte, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		buf := make([]byte, len(raw))
+		n, _ := io.ReadFull(c, buf)
+		got <- buf[:n]
+		io.WriteString(c, "HTTP/1.1 200 OK\r\nContent-Length: 5\r\n\r\nfirst"+
+			"HTTP/1.1 404 Not Found\r\nContent-Length: 6\r\n\r\nsecond"+
+			"HTTP/1.1 400 Bad")
+	}()
+
+	tr := &Transport{}
+	rc, err := tr.DialRaw(context.Background(), "http", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if _, err := rc.Write([]byte(raw)); err != nil {
+		t.Fatal(err)
+	}
+	if b := <-got; string(b) != raw {
+		t.Errorf("server got %q, want %q", b, raw)
+	}
+
+	for _, want := range []string{"200 first", "404 second"} {
+		resp, err := rc.ReadResponse(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if got := strings.Fields(resp.Status)[0] + " " + string(body); got != want {
+			t.Errorf("response = %q, want %q", got, want)
+		}
+	}
+	left, err := rc.Leftover(time.Second)
+	if err != nil || string(left) != "HTTP/1.1 400 Bad" {
+		t.Errorf("Leftover = %q, %v", left, err)
+	}
+}
+
+func TestRawConnTLSOffersOnlyHTTP1(t *testing.T) {
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	tr := ts.Client().Transport.(*Transport)
+	rc, err := tr.DialRaw(context.Background(), "https", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	if cs := rc.ConnectionState(); cs == nil || cs.NegotiatedProtocol == "h2" {
+		t.Fatalf("ConnectionState = %+v, want TLS without h2", cs)
+	}
+	io.WriteString(rc, "HEAD / HTTP/1.1\r\nHost: x\r\n\r\nGET / HTTP/1.1\r\nHost: x\r\n\r\n")
+	head, _ := NewRequest("HEAD", "/", nil)
+	if _, err := rc.ReadResponse(head); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := rc.ReadResponse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body, _ := io.ReadAll(resp.Body); !bytes.Equal(body, []byte("HTTP/1.1")) {
+		t.Errorf("body = %q, want HTTP/1.1", body)
+	}
+}
@@ -0,0 +1,304 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	http "github.com/dteh/dhttp"
+)
+
+// ErrNoInteraction is returned, wrapped, by Transport.RoundTrip for a
+// request that matches no replayable interaction in ModeReplay.
+var ErrNoInteraction = errors.New("replay: no matching interaction")
+
+// Mode selects what a Transport does with requests.
+type Mode int
+
+const (
+	// ModeReplay serves every request from the cassette and fails the
+	// requests it cannot serve. It never uses the network.
+	ModeReplay Mode = iota
+
+	// ModeRecord sends every request with the underlying Transport and
+	// appends the exchange to the cassette.
+	ModeRecord
+
+	// ModeReplayOrRecord serves requests from the cassette when it can,
+	// and records the others as ModeRecord does.
+	ModeReplayOrRecord
+)
+
+// A Matcher reports whether a request, with its body read into
+// req.Body, matches a recorded one.
+type Matcher func(req, recorded *Request) bool
+
+// MatchMethod matches requests with the same method.
+func MatchMethod(req, recorded *Request) bool {
+	return strings.EqualFold(valueOr(req.Method, "GET"), valueOr(recorded.Method, "GET"))
+}
+
+// MatchURL matches requests for the same URL, including the query.
+func MatchURL(req, recorded *Request) bool {
+	u1, err1 := url.Parse(req.URL)
+	u2, err2 := url.Parse(recorded.URL)
+	if err1 != nil || err2 != nil {
+		return req.URL == recorded.URL
+	}
+	u1.Fragment, u1.RawFragment = "", ""
+	u2.Fragment, u2.RawFragment = "", ""
+	return u1.String() == u2.String()
+}
+
+// MatchBody matches requests with the same body, by SHA-256 hash.
+func MatchBody(req, recorded *Request) bool {
+	return req.BodySHA256() == recorded.BodySHA256()
+}
+
+// MatchHeader returns a Matcher that matches requests with the same
+// values of the headers keys.
+func MatchHeader(keys ...string) Matcher {
+	return func(req, recorded *Request) bool {
+		for _, k := range keys {
+			if !slices.Equal(req.Header.Values(k), recorded.Header.Values(k)) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// MatchAll returns a Matcher that matches requests that all of ms match.
+func MatchAll(ms ...Matcher) Matcher {
+	return func(req, recorded *Request) bool {
+		for _, m := range ms {
+			if !m(req, recorded) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// DefaultMatcher matches requests with the same method and URL.
+var DefaultMatcher = MatchAll(MatchMethod, MatchURL)
+
+// A Transport is an http.RoundTripper that replays the interactions of a
+// cassette and, depending on its Mode, records new ones. It is safe for
+// concurrent use.
+type Transport struct {
+	// Cassette holds the interactions to replay and receives recorded
+	// ones. It must not be modified while the Transport is in use.
+	Cassette *Cassette
+
+	// Mode selects between replaying and recording. The zero value,
+	// ModeReplay, never uses the network.
+	Mode Mode
+
+	// Match selects the recorded interactions a request may be served
+	// from. If nil, DefaultMatcher is used.
+	Match Matcher
+
+	// Strict makes each interaction replay at most once, in recorded
+	// order among those matching a request, so that a repeated request
+	// needs as many recordings. Otherwise an interaction replays any
+	// number of times: a request gets the first matching interaction
+	// not yet replayed or, once all have been, the last one.
+	Strict bool
+
+	// Transport sends the requests that are recorded. If nil,
+	// http.DefaultTransport is used.
+	Transport http.RoundTripper
+
+	mu     sync.Mutex
+	played map[*Interaction]bool
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	r := &Request{
+		Method: valueOr(req.Method, "GET"),
+		URL:    req.URL.String(),
+		Header: req.Header.Clone(),
+		Body:   body,
+	}
+	delete(r.Header, http.HeaderOrderKey)
+	delete(r.Header, http.PHeaderOrderKey)
+
+	if t.Mode != ModeRecord {
+		if in := t.find(r); in != nil {
+			return in.Response.response(req), nil
+		}
+		if t.Mode == ModeReplay {
+			return nil, fmt.Errorf("%w for %s %s", ErrNoInteraction, r.Method, r.URL)
+		}
+	}
+	return t.record(req, r)
+}
+
+// find returns the interaction to serve r from and marks it played, or
+// returns nil.
+func (t *Transport) find(r *Request) *Interaction {
+	match := t.Match
+	if match == nil {
+		match = DefaultMatcher
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.Cassette == nil {
+		return nil
+	}
+	var last *Interaction
+	for _, in := range t.Cassette.Interactions {
+		if !match(r, &in.Request) {
+			continue
+		}
+		if !t.played[in] {
+			if t.played == nil {
+				t.played = make(map[*Interaction]bool)
+			}
+			t.played[in] = true
+			return in
+		}
+		last = in
+	}
+	if t.Strict {
+		return nil
+	}
+	return last
+}
+
+// record sends req, whose body has been read into r.Body, and appends
+// the exchange to the cassette.
+func (t *Transport) record(req *http.Request, r *Request) (*http.Response, error) {
+	rt := t.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	req2 := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		req2.Body = io.NopCloser(bytes.NewReader(r.Body))
+		req2.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(r.Body)), nil
+		}
+	}
+	resp, err := rt.RoundTrip(req2)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	in := &Interaction{
+		Request: *r,
+		Response: Response{
+			StatusCode: resp.StatusCode,
+			Proto:      resp.Proto,
+			Header:     resp.Header.Clone(),
+			Body:       body,
+		},
+	}
+
+	t.mu.Lock()
+	if t.Cassette == nil {
+		t.Cassette = &Cassette{}
+	}
+	t.Cassette.Interactions = append(t.Cassette.Interactions, in)
+	if t.played == nil {
+		t.played = make(map[*Interaction]bool)
+	}
+	t.played[in] = true
+	t.mu.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	resp.Request = req
+	return resp, nil
+}
+
+// Unplayed returns the interactions of the cassette that have not been
+// replayed or recorded yet, for tests that expect every recorded request
+// to be made.
+func (t *Transport) Unplayed() []*Interaction {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var unplayed []*Interaction
+	if t.Cassette != nil {
+		for _, in := range t.Cassette.Interactions {
+			if !t.played[in] {
+				unplayed = append(unplayed, in)
+			}
+		}
+	}
+	return unplayed
+}
+
+// response returns a new *http.Response for req serving r.
+func (r *Response) response(req *http.Request) *http.Response {
+	proto := r.Proto
+	major, minor, ok := http.ParseHTTPVersion(proto)
+	if !ok {
+		// HAR files written by browsers use names such as "h2".
+		switch strings.ToLower(proto) {
+		case "h2", "http/2":
+			proto, major, minor = "HTTP/2.0", 2, 0
+		default:
+			proto, major, minor = "HTTP/1.1", 1, 1
+		}
+	}
+	header := r.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	resp := &http.Response{
+		Status:        strconv.Itoa(r.StatusCode) + " " + http.StatusText(r.StatusCode),
+		StatusCode:    r.StatusCode,
+		Proto:         proto,
+		ProtoMajor:    major,
+		ProtoMinor:    minor,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(r.Body)),
+		ContentLength: int64(len(r.Body)),
+		Request:       req,
+	}
+	if req.Method == "HEAD" {
+		resp.Body = http.NoBody
+		resp.ContentLength = -1
+		if cl, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil {
+			resp.ContentLength = cl
+		}
+	}
+	return resp
+}
+
+// readBody reads and closes the body of req, which a RoundTripper must
+// close even when it does not send it.
+func readBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	defer req.Body.Close()
+	b, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("replay: reading request body: %w", err)
+	}
+	return b, nil
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
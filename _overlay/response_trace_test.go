@@ -0,0 +1,133 @@
+package http_test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptrace"
+)
+
+// TestTraceGotHeaderFieldHTTP1 checks that the fields of the final
+// response are reported in wire order with their keys as sent, and that
+// those of 1xx responses are not.
+func TestTraceGotHeaderFieldHTTP1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer c.Close()
+		if _, err := ReadRequest(bufio.NewReader(c)); err != nil {
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 103 Early Hints\r\nLink: </a.css>\r\n\r\n"+
+			"HTTP/1.1 200 OK\r\nzz-last: 1\r\nX-Folded: a\r\n b\r\nAa-First: 2\r\nzz-last: 3\r\nContent-Length: 0\r\n\r\n")
+	}()
+
+	var mu sync.Mutex
+	var fields, events []string
+	trace := &httptrace.ClientTrace{
+		GotHeaderField: func(key string, value []string) {
+			mu.Lock()
+			defer mu.Unlock()
+			fields = append(fields, key+"="+strings.Join(value, ","))
+		},
+		ConnectStart: func(network, addr string) { events = append(events, "start "+addr) },
+		ConnectDone:  func(network, addr string, err error) { events = append(events, "done "+addr) },
+	}
+	req, _ := NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if got, want := strings.Join(fields, " "), "zz-last=1 X-Folded=a b Aa-First=2 zz-last=3 Content-Length=0"; got != want {
+		t.Errorf("GotHeaderField calls = %q, want %q", got, want)
+	}
+	addr := ln.Addr().String()
+	if got, want := strings.Join(events, "; "), "start "+addr+"; done "+addr; got != want {
+		t.Errorf("connect events = %q, want %q", got, want)
+	}
+}
+
+// TestTraceDNS checks that dials are left to net.Dialer and reported as
+// one connection attempt, unless Transport.TraceDNS asks for DNS events.
+func TestTraceDNS(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer c.Close()
+				if _, err := ReadRequest(bufio.NewReader(c)); err == nil {
+					io.WriteString(c, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+				}
+			}()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+	addr := net.JoinHostPort("localhost", port)
+
+	for _, traceDNS := range []bool{false, true} {
+		var mu sync.Mutex
+		var events []string
+		logf := func(s string) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, s)
+		}
+		trace := &httptrace.ClientTrace{
+			DNSStart:     func(e httptrace.DNSStartInfo) { logf("dns " + e.Host) },
+			ConnectStart: func(network, addr string) { logf("start " + addr) },
+			ConnectDone: func(network, addr string, err error) {
+				if err == nil {
+					logf("done " + addr)
+				}
+			},
+		}
+		req, _ := NewRequest("GET", "http://"+addr+"/", nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+		tr := &Transport{TraceDNS: traceDNS}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		mu.Lock()
+		got := strings.Join(events, "; ")
+		mu.Unlock()
+		want := "start " + addr + "; done " + addr
+		if traceDNS {
+			want = "dns localhost; "
+			if !strings.HasPrefix(got, want) || !strings.Contains(got, "done "+ln.Addr().String()) {
+				t.Errorf("TraceDNS events = %q, want a lookup of localhost and a connection to %s", got, ln.Addr())
+			}
+		} else if got != want {
+			t.Errorf("events = %q, want %q", got, want)
+		}
+	}
+}
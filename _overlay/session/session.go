@@ -0,0 +1,385 @@
+// Package session provides a browsing session layered on dhttp's Client:
+// one parroted TLS fingerprint, a cookie jar, the browser's default
+// headers, a Referer that follows navigation, and a history of visited
+// pages, all of which can be saved to disk and restored.
+//
+// Quick start:
+//
+//	s, err := session.New(session.WithClientHello(http.ClientHelloSettings{HelloID: tls.HelloChrome_133}))
+//	if err != nil { ... }
+//	req, _ := http.NewRequest("GET", "https://example.com/", nil)
+//	resp, err := s.Navigate(req) // a page load: Sec-Fetch-Mode navigate
+//	...
+//	req, _ = http.NewRequest("GET", "https://example.com/api/items", nil)
+//	resp, err = s.Fetch(req) // a fetch() from that page: Sec-Fetch-Mode cors
+//	...
+//	err = s.Save(f) // and later session.Load(f)
+//
+// A Session fills in only the headers a request does not set itself, so
+// callers keep full control over individual requests.
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/cookiejar"
+	tls "github.com/refraction-networking/utls"
+)
+
+// A Session is a browsing session. Its methods are safe for concurrent
+// use, but navigations made concurrently race for the current page.
+type Session struct {
+	// Client sends the session's requests. Its Jar is Jar and its
+	// Transport parrots the session's ClientHello. It may be used
+	// directly for requests outside the session's navigation model.
+	Client *http.Client
+
+	// Jar holds the session's cookies. It enforces SameSite and uses the
+	// built-in public suffix list.
+	Jar *cookiejar.Jar
+
+	hello   http.ClientHelloSettings
+	browser http.BrowserHeaders
+	header  http.Header
+
+	mu      sync.Mutex
+	history []*url.URL
+}
+
+// Option configures a Session.
+type Option func(*options)
+
+type options struct {
+	hello     *http.ClientHelloSettings
+	browser   *http.BrowserHeaders
+	header    http.Header
+	transport *http.Transport
+}
+
+// WithClientHello sets the TLS fingerprint of the session and the browser
+// whose headers it sends. Defaults to the Transport's ClientHelloSettings;
+// Chrome's headers are sent if those do not name a browser version.
+func WithClientHello(s http.ClientHelloSettings) Option {
+	return func(o *options) { o.hello = &s }
+}
+
+// WithBrowser sets the platform and language the session claims. See
+// http.BrowserHeaders; its Inconsistent callback is not used.
+func WithBrowser(b http.BrowserHeaders) Option {
+	return func(o *options) { o.browser = &b }
+}
+
+// WithHeader adds headers to every request of the session that does not
+// set them, overriding the browser's own defaults.
+func WithHeader(h http.Header) Option {
+	return func(o *options) { o.header = h.Clone() }
+}
+
+// WithTransport bases the session's Transport on a clone of t, for
+// proxies, timeouts or TLS settings. Defaults to http.DefaultTransport.
+func WithTransport(t *http.Transport) Option {
+	return func(o *options) { o.transport = t }
+}
+
+// New returns a new Session with an empty cookie jar and history.
+func New(opts ...Option) (*Session, error) {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	jar, err := cookiejar.New(&cookiejar.Options{
+		UseBuiltinPublicSuffixList: true,
+		EnforceSameSite:            true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	base := o.transport
+	if base == nil {
+		base = http.DefaultTransport.(*http.Transport)
+	}
+	t := base.Clone()
+	if o.hello != nil {
+		t.ClientHelloSettings = *o.hello
+	}
+	s := &Session{
+		Client: &http.Client{
+			Transport:        t,
+			Jar:              jar,
+			BrowserRedirects: &http.BrowserRedirects{},
+		},
+		Jar:    jar,
+		hello:  t.ClientHelloSettings,
+		header: o.header,
+	}
+	if o.browser != nil {
+		s.browser = *o.browser
+		s.browser.Inconsistent = nil
+	}
+	return s, nil
+}
+
+// Current returns the URL of the page the session last navigated to, or
+// nil before the first navigation.
+func (s *Session) Current() *url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.history) == 0 {
+		return nil
+	}
+	u := *s.history[len(s.history)-1]
+	return &u
+}
+
+// History returns the URLs of the pages the session has navigated to,
+// oldest first. Redirects are recorded by their final URL.
+func (s *Session) History() []*url.URL {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	h := make([]*url.URL, len(s.history))
+	for i, u := range s.history {
+		u := *u
+		h[i] = &u
+	}
+	return h
+}
+
+// Navigate sends req as a browser page load from the current page, such
+// as following a link or submitting a form, or as typing its URL if
+// there is no current page yet. It adds the browser's navigation headers
+// (Accept, Sec-Fetch-Mode: navigate, Sec-Fetch-Dest: document, and so
+// on), a Referer and, for methods other than GET and HEAD, an Origin.
+// Cookies follow the SameSite rules of a navigation. The response's final
+// URL becomes the current page.
+func (s *Session) Navigate(req *http.Request) (*http.Response, error) {
+	cur := s.Current()
+	h := s.baseHeader()
+	h.Set("Upgrade-Insecure-Requests", "1")
+	h.Set("Sec-Fetch-Site", fetchSite(cur, req.URL))
+	h.Set("Sec-Fetch-Mode", "navigate")
+	h.Set("Sec-Fetch-User", "?1")
+	h.Set("Sec-Fetch-Dest", "document")
+	s.addRequestOrigin(h, cur, req)
+
+	resp, err := s.do(req, h, cur, http.RequestNavigation)
+	if err != nil {
+		return nil, err
+	}
+	u := *resp.Request.URL
+	s.mu.Lock()
+	s.history = append(s.history, &u)
+	s.mu.Unlock()
+	return resp, nil
+}
+
+// Fetch sends req as a script on the current page would with fetch() or
+// XMLHttpRequest. It adds the browser's headers for such requests
+// (Accept: */*, Sec-Fetch-Mode: cors, Sec-Fetch-Dest: empty), a Referer
+// and, for cross-origin requests or methods other than GET and HEAD, an
+// Origin. Cookies follow the SameSite and partitioning rules of a
+// subresource of the current page. Fetch does not change the current
+// page.
+func (s *Session) Fetch(req *http.Request) (*http.Response, error) {
+	cur := s.Current()
+	h := s.baseHeader()
+	h.Set("Accept", "*/*")
+	h.Set("Sec-Fetch-Site", fetchSite(cur, req.URL))
+	h.Set("Sec-Fetch-Mode", "cors")
+	h.Set("Sec-Fetch-Dest", "empty")
+	s.addRequestOrigin(h, cur, req)
+	if cur != nil && http.URLOrigin(cur) != http.URLOrigin(req.URL) && h.Get("Origin") == "" {
+		h.Set("Origin", http.URLOrigin(cur))
+	}
+	return s.do(req, h, cur, http.RequestSubresource)
+}
+
+// baseHeader returns the headers the session's browser sends on every
+// request, with the session's own defaults applied.
+func (s *Session) baseHeader() http.Header {
+	id := s.hello.HelloID
+	if id.Client == "" {
+		id = tls.HelloChrome_Auto
+	}
+	h, err := s.browser.Header(id)
+	if err != nil {
+		h, _ = s.browser.Header(tls.HelloChrome_Auto)
+	}
+	for k, vv := range s.header {
+		if old, ok := headerKey(h, k); ok {
+			delete(h, old)
+		}
+		h[k] = vv
+	}
+	return h
+}
+
+// addRequestOrigin adds the Referer and, for unsafe methods, the Origin
+// of a request from the page cur.
+func (s *Session) addRequestOrigin(h http.Header, cur *url.URL, req *http.Request) {
+	if cur == nil {
+		if req.Method != "" && req.Method != "GET" && req.Method != "HEAD" {
+			h.Set("Origin", "null")
+		}
+		return
+	}
+	if ref := http.ReferrerForPolicy("", cur, req.URL); ref != "" {
+		h.Set("Referer", ref)
+	}
+	if req.Method != "" && req.Method != "GET" && req.Method != "HEAD" {
+		h.Set("Origin", http.URLOrigin(cur))
+	}
+}
+
+// do sends a copy of req with the headers of h that it does not set, in
+// the SameSite context of a request of type typ from the page cur.
+func (s *Session) do(req *http.Request, h http.Header, cur *url.URL, typ http.RequestType) (*http.Response, error) {
+	ctx := req.Context()
+	var initiator string
+	if cur != nil {
+		initiator = http.URLOrigin(cur)
+	}
+	ctx = http.WithInitiator(ctx, initiator, typ)
+	if typ == http.RequestSubresource && cur != nil {
+		ctx = http.WithTopLevelSite(ctx, initiator)
+	}
+	r2 := req.Clone(ctx)
+	for k, vv := range h {
+		if _, ok := headerKey(r2.Header, k); !ok {
+			r2.Header[k] = vv
+		}
+	}
+	return s.Client.Do(r2)
+}
+
+// fetchSite returns the Sec-Fetch-Site of a request to u from the page
+// cur, which is nil for a user-initiated request.
+func fetchSite(cur, u *url.URL) string {
+	switch {
+	case cur == nil:
+		return "none"
+	case http.URLOrigin(cur) == http.URLOrigin(u):
+		return "same-origin"
+	case http.URLSite(cur) == http.URLSite(u):
+		return "same-site"
+	}
+	return "cross-site"
+}
+
+// headerKey returns the key under which h holds k. Callers may set keys
+// in any case, so they are matched case-insensitively.
+func headerKey(h http.Header, k string) (string, bool) {
+	if _, ok := h[k]; ok {
+		return k, true
+	}
+	for hk := range h {
+		if strings.EqualFold(hk, k) {
+			return hk, true
+		}
+	}
+	return "", false
+}
+
+// snapshotVersion is the version of the Save format.
+const snapshotVersion = 1
+
+type snapshot struct {
+	Version     int             `json:"version"`
+	ClientHello *clientHello    `json:"clientHello,omitempty"`
+	Browser     browser         `json:"browser"`
+	Header      http.Header     `json:"header,omitempty"`
+	History     []string        `json:"history"`
+	Cookies     json.RawMessage `json:"cookies"`
+}
+
+type clientHello struct {
+	Client  string `json:"client"`
+	Version string `json:"version"`
+}
+
+type browser struct {
+	Platform       string `json:"platform,omitempty"`
+	AcceptLanguage string `json:"acceptLanguage,omitempty"`
+}
+
+// Save writes the session's ClientHelloID, browser settings, default
+// headers, history and cookies (in the JSON form of cookiejar.Jar) to w
+// as JSON. The Transport's other settings, and the seed of randomized
+// ClientHelloIDs, are not saved. Sessions using a custom ClientHelloSpec
+// cannot be saved.
+func (s *Session) Save(w io.Writer) error {
+	if s.hello.HelloID.Client == tls.HelloCustom.Client {
+		return errors.New("session: cannot save a session with a custom ClientHelloSpec")
+	}
+	cookies, err := json.Marshal(s.Jar)
+	if err != nil {
+		return err
+	}
+	snap := snapshot{
+		Version: snapshotVersion,
+		Browser: browser{s.browser.Platform, s.browser.AcceptLanguage},
+		Header:  s.header,
+		History: []string{},
+		Cookies: cookies,
+	}
+	if id := s.hello.HelloID; id.Client != "" {
+		snap.ClientHello = &clientHello{id.Client, id.Version}
+	}
+	for _, u := range s.History() {
+		snap.History = append(snap.History, u.String())
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(snap)
+}
+
+// Load returns a Session restored from a snapshot written by Save. The
+// options are applied after the snapshot's settings, so WithTransport,
+// for instance, can give the restored session a new proxy.
+func Load(r io.Reader, opts ...Option) (*Session, error) {
+	var snap snapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return nil, fmt.Errorf("session: reading snapshot: %w", err)
+	}
+	if snap.Version != snapshotVersion {
+		return nil, fmt.Errorf("session: unsupported snapshot version %d", snap.Version)
+	}
+	var base []Option
+	if ch := snap.ClientHello; ch != nil {
+		base = append(base, WithClientHello(http.ClientHelloSettings{HelloID: tls.ClientHelloID{
+			Client:  ch.Client,
+			Version: ch.Version,
+		}}))
+	}
+	base = append(base, WithBrowser(http.BrowserHeaders{
+		Platform:       snap.Browser.Platform,
+		AcceptLanguage: snap.Browser.AcceptLanguage,
+	}))
+	if snap.Header != nil {
+		base = append(base, WithHeader(snap.Header))
+	}
+	s, err := New(append(base, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	if len(snap.Cookies) > 0 {
+		if err := json.Unmarshal(snap.Cookies, s.Jar); err != nil {
+			return nil, fmt.Errorf("session: restoring cookies: %w", err)
+		}
+	}
+	for _, h := range snap.History {
+		u, err := url.Parse(h)
+		if err != nil {
+			return nil, fmt.Errorf("session: restoring history: %w", err)
+		}
+		s.history = append(s.history, u)
+	}
+	return s, nil
+}
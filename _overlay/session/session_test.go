@@ -0,0 +1,193 @@
+package session_test
+
+import (
+	"bytes"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/session"
+)
+
+// recordingServer returns a TLS server that records the headers of each
+// request by path and sets a cookie on "/login".
+func recordingServer(t *testing.T) (*httptest.Server, func(path string) http.Header) {
+	var mu sync.Mutex
+	seen := map[string]http.Header{}
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		seen[r.URL.Path] = r.Header.Clone()
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/login":
+			http.SetCookie(w, &http.Cookie{Name: "sid", Value: "abc", Path: "/", SameSite: http.SameSiteLaxMode})
+		case "/redirect":
+			http.Redirect(w, r, "/home", http.StatusFound)
+			return
+		}
+		io.WriteString(w, "ok")
+	}))
+	t.Cleanup(ts.Close)
+	return ts, func(path string) http.Header {
+		mu.Lock()
+		defer mu.Unlock()
+		return seen[path]
+	}
+}
+
+func newSession(t *testing.T, ts *httptest.Server, opts ...session.Option) *session.Session {
+	t.Helper()
+	opts = append([]session.Option{session.WithTransport(ts.Client().Transport.(*http.Transport))}, opts...)
+	s, err := session.New(opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func do(t *testing.T, send func(*http.Request) (*http.Response, error), method, url string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := send(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+}
+
+func checkHeaders(t *testing.T, what string, h http.Header, want map[string]string) {
+	t.Helper()
+	for k, v := range want {
+		if got := h.Get(k); got != v {
+			t.Errorf("%s: %s = %q, want %q", what, k, got, v)
+		}
+	}
+}
+
+func TestNavigateAndFetch(t *testing.T) {
+	ts, seen := recordingServer(t)
+	s := newSession(t, ts, session.WithHeader(http.Header{"X-Default": {"1"}}))
+
+	do(t, s.Navigate, "GET", ts.URL+"/login#top")
+	first := seen("/login")
+	checkHeaders(t, "first navigation", first, map[string]string{
+		"Sec-Fetch-Site":            "none",
+		"Sec-Fetch-Mode":            "navigate",
+		"Sec-Fetch-User":            "?1",
+		"Sec-Fetch-Dest":            "document",
+		"Upgrade-Insecure-Requests": "1",
+		"Referer":                   "",
+		"Origin":                    "",
+		"X-Default":                 "1",
+	})
+	if !strings.Contains(first.Get("User-Agent"), "Chrome/") {
+		t.Errorf("first navigation: User-Agent = %q, want Chrome's", first.Get("User-Agent"))
+	}
+
+	do(t, s.Navigate, "GET", ts.URL+"/redirect")
+	checkHeaders(t, "second navigation", seen("/redirect"), map[string]string{
+		"Sec-Fetch-Site": "same-origin",
+		"Referer":        ts.URL + "/login",
+		"Cookie":         "sid=abc",
+	})
+	if got := s.Current().String(); got != ts.URL+"/home" {
+		t.Errorf("Current() = %q, want the redirect target %q", got, ts.URL+"/home")
+	}
+
+	do(t, s.Fetch, "POST", ts.URL+"/api")
+	checkHeaders(t, "fetch", seen("/api"), map[string]string{
+		"Accept":         "*/*",
+		"Sec-Fetch-Site": "same-origin",
+		"Sec-Fetch-Mode": "cors",
+		"Sec-Fetch-Dest": "empty",
+		"Sec-Fetch-User": "",
+		"Origin":         ts.URL,
+		"Referer":        ts.URL + "/home",
+		"Cookie":         "sid=abc",
+	})
+	if n := len(s.History()); n != 2 {
+		t.Errorf("len(History()) = %d after a Fetch, want 2", n)
+	}
+}
+
+func TestRequestHeadersWin(t *testing.T) {
+	ts, seen := recordingServer(t)
+	s := newSession(t, ts, session.WithHeader(http.Header{"Accept-Language": {"de"}, "user-agent": {"bot"}}))
+
+	req, _ := http.NewRequest("GET", ts.URL+"/", nil)
+	req.Header.Set("Sec-Fetch-Site", "cross-site")
+	req.Header.Set("Referer", "https://elsewhere.example/")
+	req.Header["accept"] = []string{"text/plain"}
+	resp, err := s.Navigate(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	checkHeaders(t, "navigation", seen("/"), map[string]string{
+		"Sec-Fetch-Site":  "cross-site",
+		"Referer":         "https://elsewhere.example/",
+		"Accept-Language": "de",
+	})
+	for k, want := range map[string]string{"Accept": "text/plain", "User-Agent": "bot"} {
+		if got := seen("/").Values(k); len(got) != 1 || got[0] != want {
+			t.Errorf("navigation: %s = %q, want only %q", k, got, want)
+		}
+	}
+	if len(req.Header) != 3 {
+		t.Errorf("Navigate modified the caller's request headers: %v", req.Header)
+	}
+}
+
+func TestSaveLoad(t *testing.T) {
+	ts, seen := recordingServer(t)
+	s := newSession(t, ts,
+		session.WithBrowser(http.BrowserHeaders{Platform: "Linux", AcceptLanguage: "fr-FR"}),
+		session.WithHeader(http.Header{"X-Default": {"1"}}),
+	)
+	do(t, s.Navigate, "GET", ts.URL+"/login")
+
+	var buf bytes.Buffer
+	if err := s.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	s2, err := session.Load(&buf, session.WithTransport(ts.Client().Transport.(*http.Transport)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(ts.URL)
+	if got := s2.Jar.Cookies(u); len(got) != 1 || got[0].Name != "sid" {
+		t.Errorf("restored cookies = %v, want sid", got)
+	}
+	if h := s2.History(); len(h) != 1 || h[0].String() != ts.URL+"/login" {
+		t.Errorf("restored History() = %v", h)
+	}
+
+	do(t, s2.Navigate, "GET", ts.URL+"/next")
+	checkHeaders(t, "restored navigation", seen("/next"), map[string]string{
+		"Referer":            ts.URL + "/login",
+		"Cookie":             "sid=abc",
+		"Accept-Language":    "fr-FR",
+		"Sec-Ch-Ua-Platform": `"Linux"`,
+		"X-Default":          "1",
+	})
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		`{"version": 99}`,
+		`{"version": 1, "history": ["%zz"]}`,
+	} {
+		if _, err := session.Load(strings.NewReader(in)); err == nil {
+			t.Errorf("Load(%q) succeeded, want error", in)
+		}
+	}
+}
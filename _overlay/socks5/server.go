@@ -0,0 +1,472 @@
+// Package socks5 implements a SOCKS version 5 server (RFC 1928), with
+// the CONNECT and UDP ASSOCIATE commands and either no authentication or
+// username/password authentication (RFC 1929).
+//
+// It is meant for tests and for local egress, such as exercising a
+// Transport with a "socks5" or "socks5h" proxy offline:
+//
+//	ts := socks5.NewTestServer(&socks5.Server{})
+//	defer ts.Close()
+//	tr := &http.Transport{Proxy: http.ProxyURL(ts.URL)}
+//
+// Hooks on the Server decide which requests are allowed and how their
+// targets are reached.
+package socks5
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"sync"
+	"syscall"
+)
+
+// Version is the SOCKS protocol version implemented.
+const Version = 0x05
+
+// A Command is a SOCKS request command.
+type Command byte
+
+const (
+	CmdConnect      Command = 0x01
+	CmdBind         Command = 0x02 // not supported
+	CmdUDPAssociate Command = 0x03
+)
+
+func (cmd Command) String() string {
+	switch cmd {
+	case CmdConnect:
+		return "connect"
+	case CmdBind:
+		return "bind"
+	case CmdUDPAssociate:
+		return "udp associate"
+	}
+	return "socks " + strconv.Itoa(int(cmd))
+}
+
+// A Status is the status of a SOCKS reply.
+type Status byte
+
+const (
+	StatusSucceeded           Status = 0x00
+	StatusGeneralFailure      Status = 0x01
+	StatusNotAllowed          Status = 0x02
+	StatusNetworkUnreachable  Status = 0x03
+	StatusHostUnreachable     Status = 0x04
+	StatusConnectionRefused   Status = 0x05
+	StatusTTLExpired          Status = 0x06
+	StatusCommandNotSupported Status = 0x07
+	StatusAddrNotSupported    Status = 0x08
+)
+
+// Authentication methods.
+const (
+	methodNoAuth       = 0x00
+	methodUserPass     = 0x02
+	methodNoAcceptable = 0xff
+
+	userPassVersion = 0x01
+)
+
+// Address types.
+const (
+	atypIPv4 = 0x01
+	atypFQDN = 0x03
+	atypIPv6 = 0x04
+)
+
+// A Request is a SOCKS request received by a [Server].
+type Request struct {
+	Command Command
+
+	// Username is the authenticated user, or empty without
+	// authentication.
+	Username string
+
+	// Target is the "host:port" address of the request, where host is
+	// an IP address or a host name as the client sent it. For
+	// CmdUDPAssociate, it is the address the client said it would send
+	// datagrams from when the association is made, and then the
+	// destination of each datagram.
+	Target string
+
+	// RemoteAddr is the address of the client's TCP connection.
+	RemoteAddr net.Addr
+}
+
+// A Server serves SOCKS5 clients. The zero value is a working server
+// without authentication that reaches every target directly.
+type Server struct {
+	// Credentials, if non-nil, makes clients authenticate with a
+	// username and password, and reports whether the pair is valid. If
+	// nil, no authentication is required.
+	Credentials func(username, password string) bool
+
+	// Allow, if non-nil, reports whether a request may proceed. Refused
+	// CONNECT and UDP ASSOCIATE requests are answered with
+	// StatusNotAllowed; datagrams to refused destinations are dropped.
+	Allow func(ctx context.Context, r *Request) bool
+
+	// Dial, if non-nil, dials the targets of CONNECT requests. If nil,
+	// net.Dialer.DialContext is used.
+	Dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// ListenPacket, if non-nil, opens the socket UDP associations send
+	// datagrams to their destinations from. If nil, net.ListenConfig's
+	// ListenPacket is used with network "udp" and address ":0".
+	ListenPacket func(ctx context.Context, network, addr string) (net.PacketConn, error)
+
+	// ErrorLog specifies an optional logger for errors serving
+	// connections. If nil, logging is done via the log package's
+	// standard logger.
+	ErrorLog *log.Logger
+
+	mu        sync.Mutex
+	closed    bool
+	listeners map[net.Listener]struct{}
+	conns     map[net.Conn]struct{}
+	wg        sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve after a call to Close.
+var ErrServerClosed = errors.New("socks5: Server closed")
+
+// ListenAndServe listens on the TCP network address addr and then calls
+// Serve.
+func (s *Server) ListenAndServe(addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return s.Serve(ln)
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine.
+// It always returns a non-nil error and closes ln; after Close, the
+// error is ErrServerClosed.
+func (s *Server) Serve(ln net.Listener) error {
+	if !s.track(ln, nil, true) {
+		ln.Close()
+		return ErrServerClosed
+	}
+	defer s.track(ln, nil, false)
+	defer ln.Close()
+	for {
+		c, err := ln.Accept()
+		if err != nil {
+			if s.isClosed() {
+				return ErrServerClosed
+			}
+			return err
+		}
+		go s.ServeConn(c)
+	}
+}
+
+// ServeConn serves the SOCKS5 client on c and closes c. It returns once
+// the client's request is done, when its tunnel or UDP association
+// ends.
+func (s *Server) ServeConn(c net.Conn) {
+	if !s.track(nil, c, true) {
+		c.Close()
+		return
+	}
+	defer s.track(nil, c, false)
+	defer c.Close()
+	if err := s.serve(c); err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+		s.logf("socks5: serving %v: %v", c.RemoteAddr(), err)
+	}
+}
+
+// Close closes the listeners of s and all the connections it serves.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for ln := range s.listeners {
+		ln.Close()
+	}
+	for c := range s.conns {
+		c.Close()
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// track adds ln or c to the ones Close closes, or removes it. It
+// reports false if s is closed.
+func (s *Server) track(ln net.Listener, c net.Conn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if add && s.closed {
+		return false
+	}
+	if s.listeners == nil {
+		s.listeners = make(map[net.Listener]struct{})
+		s.conns = make(map[net.Conn]struct{})
+	}
+	switch {
+	case ln != nil && add:
+		s.listeners[ln] = struct{}{}
+	case ln != nil:
+		delete(s.listeners, ln)
+	case add:
+		s.conns[c] = struct{}{}
+		s.wg.Add(1)
+	default:
+		delete(s.conns, c)
+		s.wg.Done()
+	}
+	return true
+}
+
+func (s *Server) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+func (s *Server) serve(c net.Conn) error {
+	username, err := s.authenticate(c)
+	if err != nil {
+		return err
+	}
+
+	// VER CMD RSV ATYP
+	var hdr [4]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != Version {
+		return errors.New("unexpected protocol version " + strconv.Itoa(int(hdr[0])))
+	}
+	target, err := readAddr(c, hdr[3])
+	if err != nil {
+		if errors.Is(err, errAddrType) {
+			writeReply(c, StatusAddrNotSupported, nil)
+		}
+		return err
+	}
+	req := &Request{
+		Command:    Command(hdr[1]),
+		Username:   username,
+		Target:     target,
+		RemoteAddr: c.RemoteAddr(),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	switch req.Command {
+	case CmdConnect, CmdUDPAssociate:
+	default:
+		return writeReply(c, StatusCommandNotSupported, nil)
+	}
+	if s.Allow != nil && !s.Allow(ctx, req) {
+		return writeReply(c, StatusNotAllowed, nil)
+	}
+	if req.Command == CmdUDPAssociate {
+		return s.serveUDP(ctx, c, req)
+	}
+	return s.serveConnect(ctx, c, req)
+}
+
+// authenticate negotiates the authentication method with the client on
+// c and authenticates it, returning its username.
+func (s *Server) authenticate(c net.Conn) (string, error) {
+	// VER NMETHODS METHODS
+	var hdr [2]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return "", err
+	}
+	if hdr[0] != Version {
+		return "", errors.New("unexpected protocol version " + strconv.Itoa(int(hdr[0])))
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return "", err
+	}
+	want := byte(methodNoAuth)
+	if s.Credentials != nil {
+		want = methodUserPass
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		c.Write([]byte{Version, methodNoAcceptable})
+		return "", errors.New("no acceptable authentication method")
+	}
+	if _, err := c.Write([]byte{Version, want}); err != nil {
+		return "", err
+	}
+	if want == methodNoAuth {
+		return "", nil
+	}
+
+	// VER ULEN UNAME PLEN PASSWD
+	var b [2]byte
+	if _, err := io.ReadFull(c, b[:]); err != nil {
+		return "", err
+	}
+	if b[0] != userPassVersion {
+		return "", errors.New("unexpected username/password version " + strconv.Itoa(int(b[0])))
+	}
+	username := make([]byte, b[1])
+	if _, err := io.ReadFull(c, username); err != nil {
+		return "", err
+	}
+	if _, err := io.ReadFull(c, b[:1]); err != nil {
+		return "", err
+	}
+	password := make([]byte, b[0])
+	if _, err := io.ReadFull(c, password); err != nil {
+		return "", err
+	}
+	if !s.Credentials(string(username), string(password)) {
+		c.Write([]byte{userPassVersion, 0x01})
+		return "", errors.New("authentication failed for user " + strconv.Quote(string(username)))
+	}
+	_, err := c.Write([]byte{userPassVersion, 0x00})
+	return string(username), err
+}
+
+// serveConnect tunnels c to the target of req.
+func (s *Server) serveConnect(ctx context.Context, c net.Conn, req *Request) error {
+	dial := s.Dial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+	upstream, err := dial(ctx, "tcp", req.Target)
+	if err != nil {
+		writeReply(c, dialStatus(err), nil)
+		return err
+	}
+	defer upstream.Close()
+	if err := writeReply(c, StatusSucceeded, upstream.LocalAddr()); err != nil {
+		return err
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Pass on a clean close from the client as a half-close; on a
+		// failure, such as when Close closes c, end the tunnel.
+		_, err := io.Copy(upstream, c)
+		if cw, ok := upstream.(interface{ CloseWrite() error }); ok && err == nil {
+			cw.CloseWrite()
+		} else {
+			upstream.Close()
+		}
+	}()
+	io.Copy(c, upstream)
+	c.Close()
+	<-done
+	return nil
+}
+
+// dialStatus returns the reply status for a failure to reach a target.
+func dialStatus(err error) Status {
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return StatusConnectionRefused
+	case errors.Is(err, syscall.ENETUNREACH):
+		return StatusNetworkUnreachable
+	case errors.Is(err, syscall.EHOSTUNREACH), errors.As(err, &dnsErr):
+		return StatusHostUnreachable
+	case errors.Is(err, context.DeadlineExceeded):
+		return StatusTTLExpired
+	}
+	return StatusGeneralFailure
+}
+
+var errAddrType = errors.New("unsupported address type")
+
+// readAddr reads an address of type atyp and its port from r, and
+// returns them as "host:port".
+func readAddr(r io.Reader, atyp byte) (string, error) {
+	var host string
+	switch atyp {
+	case atypIPv4, atypIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if atyp == atypIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case atypFQDN:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", errAddrType
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// appendAddr appends the SOCKS encoding of addr, "host:port", to b.
+func appendAddr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return nil, errors.New("host name too long: " + host)
+		}
+		b = append(b, atypFQDN, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, atypIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, atypIPv6)
+		b = append(b, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// writeReply writes a reply with status st and bound address bound to
+// c. A nil bound is sent as 0.0.0.0:0.
+func writeReply(c net.Conn, st Status, bound net.Addr) error {
+	addr := "0.0.0.0:0"
+	if bound != nil {
+		addr = bound.String()
+	}
+	b, err := appendAddr([]byte{Version, byte(st), 0x00}, addr)
+	if err != nil {
+		return err
+	}
+	_, err = c.Write(b)
+	return err
+}
+
+func (s *Server) logf(format string, args ...any) {
+	if s.ErrorLog != nil {
+		s.ErrorLog.Printf(format, args...)
+	} else {
+		log.Printf(format, args...)
+	}
+}
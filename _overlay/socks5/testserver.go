@@ -0,0 +1,61 @@
+package socks5
+
+import (
+	"net"
+	"net/url"
+)
+
+// A TestServer is a SOCKS5 server listening on a system-chosen port on
+// the local loopback interface, for use in end-to-end tests, in the
+// manner of httptest.Server.
+type TestServer struct {
+	// URL is the proxy URL of the server, of the form
+	// "socks5://127.0.0.1:port". It carries no userinfo.
+	URL *url.URL
+
+	Listener net.Listener
+	Server   *Server
+
+	done chan struct{}
+}
+
+// NewTestServer starts and returns a new TestServer serving with s.
+// The caller should call Close when finished, to shut it down.
+func NewTestServer(s *Server) *TestServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		if ln, err = net.Listen("tcp6", "[::1]:0"); err != nil {
+			panic("socks5: failed to listen on a port: " + err.Error())
+		}
+	}
+	ts := &TestServer{
+		URL:      &url.URL{Scheme: "socks5", Host: ln.Addr().String()},
+		Listener: ln,
+		Server:   s,
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(ts.done)
+		s.Serve(ln)
+	}()
+	return ts
+}
+
+// ProxyURL returns the server's URL with the given scheme, "socks5" or
+// "socks5h", and the credentials username and password, if username is
+// not empty.
+func (ts *TestServer) ProxyURL(scheme, username, password string) *url.URL {
+	u := *ts.URL
+	u.Scheme = scheme
+	if username != "" {
+		u.User = url.UserPassword(username, password)
+	}
+	return &u
+}
+
+// Close shuts down the server, closing its connections, and blocks
+// until all of them are done.
+func (ts *TestServer) Close() {
+	ts.Server.Close()
+	<-ts.done
+}
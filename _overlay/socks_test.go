@@ -0,0 +1,193 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/socks5"
+)
+
+// socks4Record is a SOCKS4 request seen by a proxy: the user ID and the
+// target, an IP address or, for SOCKS4a, a host name.
+type socks4Record struct {
+	user, target string
+}
+
+// newSOCKS4Proxy starts a SOCKS4/SOCKS4a proxy that tunnels to the
+// requested target and records the requests.
+func newSOCKS4Proxy(t *testing.T) (string, func() []socks4Record) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns []net.Conn
+		seen  []socks4Record
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			conns = append(conns, c)
+			mu.Unlock()
+			br := bufio.NewReader(c)
+			hdr := make([]byte, 8)
+			if _, err := io.ReadFull(br, hdr); err != nil {
+				c.Close()
+				continue
+			}
+			user, _ := br.ReadString(0)
+			host := net.IP(hdr[4:8]).String()
+			if hdr[4] == 0 && hdr[5] == 0 && hdr[6] == 0 {
+				host, _ = br.ReadString(0)
+				host = host[:len(host)-1]
+			}
+			target := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(hdr[2:4]))))
+			mu.Lock()
+			seen = append(seen, socks4Record{user[:len(user)-1], target})
+			mu.Unlock()
+			backend, err := net.Dial("tcp", target)
+			if err != nil {
+				c.Write([]byte{0, 91, 0, 0, 0, 0, 0, 0})
+				c.Close()
+				continue
+			}
+			c.Write([]byte{0, 90, 0, 0, 0, 0, 0, 0})
+			tunnel(&wg, c, backend)
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		mu.Lock()
+		for _, c := range conns {
+			c.Close()
+		}
+		mu.Unlock()
+		wg.Wait()
+	})
+	return ln.Addr().String(), func() []socks4Record {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]socks4Record(nil), seen...)
+	}
+}
+
+func TestSOCKS4Proxy(t *testing.T) {
+	origin := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+	_, port, _ := net.SplitHostPort(origin.Listener.Addr().String())
+	addr, seen := newSOCKS4Proxy(t)
+
+	for _, tt := range []struct {
+		scheme, target string
+	}{
+		{"socks4", "127.0.0.1:" + port},
+		{"socks4a", "localhost:" + port},
+	} {
+		tr := &Transport{Proxy: ProxyURL(&url.URL{Scheme: tt.scheme, User: url.User("id"), Host: addr})}
+		resp, err := (&Client{Transport: tr}).Get("http://localhost:" + port + "/")
+		if err != nil {
+			t.Fatalf("%s: %v", tt.scheme, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		tr.CloseIdleConnections()
+		if string(b) != "origin" {
+			t.Errorf("%s: body = %q, want origin", tt.scheme, b)
+		}
+		got := seen()
+		if want := (socks4Record{"id", tt.target}); len(got) == 0 || got[len(got)-1] != want {
+			t.Errorf("%s: proxy saw %+v, want %+v", tt.scheme, got, want)
+		}
+	}
+}
+
+// unspecifiedLocalConn reports the unspecified address as its local
+// address, so that a socks5.Server serving it answers UDP ASSOCIATE with
+// a relay on the unspecified address, as many proxies do.
+type unspecifiedLocalConn struct {
+	net.Conn
+}
+
+func (c unspecifiedLocalConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero, Port: c.Conn.LocalAddr().(*net.TCPAddr).Port}
+}
+
+func TestDialSOCKS5UDP(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], from)
+		}
+	}()
+
+	ts := socks5.NewTestServer(&socks5.Server{})
+	defer ts.Close()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go ts.Server.ServeConn(unspecifiedLocalConn{c})
+		}
+	}()
+
+	tr := &Transport{}
+	pc, err := tr.DialSOCKS5UDP(context.Background(), &url.URL{Scheme: "socks5", Host: ln.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	if got := pc.RelayAddr().(*net.UDPAddr); !got.IP.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("relay address = %v, want the proxy's address for an unspecified one", got)
+	}
+
+	if _, err := pc.WriteTo([]byte("ping"), echo.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" || from.String() != echo.LocalAddr().String() {
+		t.Errorf("ReadFrom = %q from %v, want ping from %v", buf[:n], from, echo.LocalAddr())
+	}
+
+	if _, err := tr.DialSOCKS5UDP(context.Background(), ts.ProxyURL("socks4", "", "")); err == nil {
+		t.Error("DialSOCKS5UDP with a socks4 proxy succeeded, want error")
+	}
+}
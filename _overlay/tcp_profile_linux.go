@@ -0,0 +1,49 @@
+package http
+
+import (
+	"os"
+	"strings"
+	"syscall"
+)
+
+// control applies p's socket options to a socket that is about to
+// connect. network is "tcp4" or "tcp6".
+func (p *TCPProfile) control(network string, c syscall.RawConn) error {
+	var serr error
+	err := c.Control(func(fd uintptr) {
+		serr = p.setsockopts(int(fd), strings.HasSuffix(network, "6"))
+	})
+	if err != nil {
+		return err
+	}
+	return serr
+}
+
+func (p *TCPProfile) setsockopts(fd int, ipv6 bool) error {
+	type opt struct {
+		level, name, value int
+	}
+	var opts []opt
+	if p.TTL > 0 {
+		if ipv6 {
+			opts = append(opts, opt{syscall.IPPROTO_IPV6, syscall.IPV6_UNICAST_HOPS, p.TTL})
+		} else {
+			opts = append(opts, opt{syscall.IPPROTO_IP, syscall.IP_TTL, p.TTL})
+		}
+	}
+	if p.SendBuffer > 0 {
+		opts = append(opts, opt{syscall.SOL_SOCKET, syscall.SO_SNDBUF, p.SendBuffer})
+	}
+	if p.ReceiveBuffer > 0 {
+		opts = append(opts, opt{syscall.SOL_SOCKET, syscall.SO_RCVBUF, p.ReceiveBuffer})
+	}
+	if p.MSS > 0 {
+		opts = append(opts, opt{syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, p.MSS})
+	}
+	for _, o := range opts {
+		if err := syscall.SetsockoptInt(fd, o.level, o.name, o.value); err != nil {
+			return os.NewSyscallError("setsockopt", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,18 @@
+//go:build !linux
+
+package http
+
+import (
+	"errors"
+	"syscall"
+)
+
+// control reports an error if p sets socket options, which are only
+// supported on Linux. Nagle and KeepAlive are applied portably by
+// DialContextFunc.
+func (p *TCPProfile) control(network string, c syscall.RawConn) error {
+	if p.TTL != 0 || p.SendBuffer != 0 || p.ReceiveBuffer != 0 || p.MSS != 0 {
+		return errors.New("http: TCPProfile socket options are only supported on Linux")
+	}
+	return nil
+}
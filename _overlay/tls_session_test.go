@@ -0,0 +1,105 @@
+package http_test
+
+import (
+	"io"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/httptrace"
+	tls "github.com/refraction-networking/utls"
+)
+
+// getResumption performs a GET on a fresh connection and returns what the
+// TLSSessionResumption hook reported for it.
+func getResumption(t *testing.T, cl *Client, url string) httptrace.TLSSessionResumptionInfo {
+	t.Helper()
+	var info httptrace.TLSSessionResumptionInfo
+	called := false
+	trace := &httptrace.ClientTrace{
+		TLSSessionResumption: func(i httptrace.TLSSessionResumptionInfo) {
+			info = i
+			called = true
+		},
+	}
+	req, _ := NewRequest("GET", url, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if !called {
+		t.Fatal("TLSSessionResumption hook not called")
+	}
+	return info
+}
+
+func TestTLSSessionResumption(t *testing.T) {
+	cases := map[string]func(*Transport){
+		"chrome_auto": func(*Transport) {},
+		"h1_only": func(tr *Transport) {
+			tr.TLSNextProto = make(map[string]func(authority string, c *tls.UConn) RoundTripper)
+		},
+		"custom_psk_parrot": func(tr *Transport) {
+			// A custom spec that already ends in a PSK extension, as
+			// the *_PSK parrots do. The Override is shared by every
+			// connection, so the extension must not carry state over.
+			spec, err := tls.UTLSIdToSpec(tls.HelloChrome_133)
+			if err != nil {
+				t.Fatal(err)
+			}
+			spec.Extensions = append(spec.Extensions, &tls.UtlsPreSharedKeyExtension{})
+			tr.ClientHelloSettings = ClientHelloSettings{HelloID: tls.HelloCustom, Override: spec}
+		},
+	}
+	for name, configure := range cases {
+		t.Run(name, func(t *testing.T) {
+			ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+			defer ts.Close()
+
+			cl := ts.Client()
+			tr := cl.Transport.(*Transport)
+			tr.DisableKeepAlives = true
+			tr.TLSSessionCache = tls.NewLRUClientSessionCache(0)
+			configure(tr)
+
+			if info := getResumption(t, cl, ts.URL); info.Offered || info.Resumed {
+				t.Errorf("first connection: got %+v, want neither offered nor resumed", info)
+			}
+			for i := 2; i <= 3; i++ {
+				if info := getResumption(t, cl, ts.URL); !info.Offered || !info.Resumed {
+					t.Errorf("connection %d: got %+v, want offered and resumed", i, info)
+				}
+			}
+		})
+	}
+}
+
+func TestFileSessionCache(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	dir := t.TempDir()
+	newClient := func() *Client {
+		cache, err := NewFileSessionCache(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cl := ts.Client()
+		tr := cl.Transport.(*Transport).Clone()
+		tr.DisableKeepAlives = true
+		tr.TLSSessionCache = cache
+		return &Client{Transport: tr}
+	}
+
+	if info := getResumption(t, newClient(), ts.URL); info.Resumed {
+		t.Errorf("first client resumed a session: %+v", info)
+	}
+	// A second client with its own cache instance reads the session the
+	// first one persisted.
+	if info := getResumption(t, newClient(), ts.URL); !info.Offered || !info.Resumed {
+		t.Errorf("second client: got %+v, want offered and resumed", info)
+	}
+}
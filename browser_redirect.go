@@ -0,0 +1,271 @@
+package http
+
+import (
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/dteh/dhttp/publicsuffix"
+)
+
+// BrowserRedirects makes a Client follow redirects the way a browser
+// navigation does, as specified by the Fetch standard, instead of Go's
+// own rules. See Client.BrowserRedirects.
+//
+// On every redirect hop the Client then:
+//
+//   - computes Referer from the initial request's Referer (not from the
+//     redirecting URL) under the current referrer policy, which each
+//     redirect response may change with a Referrer-Policy header;
+//   - sends Origin only while the method is neither GET nor HEAD, and
+//     as "null" after a cross-origin hop away from a URL outside the
+//     initiator's origin (the Fetch "tainted origin");
+//   - recomputes Sec-Fetch-Site over the whole chain, and drops the
+//     Sec-Fetch-* headers on hops to insecure URLs;
+//   - removes Authorization after any cross-origin hop, even to a
+//     subdomain;
+//   - keeps caller-supplied cookies in their original order.
+//
+// Headers that the initial request does not set are not added, so a
+// request that should look like a browser navigation should carry the
+// browser's Sec-Fetch-* and Origin headers itself. HeaderOrderKey and
+// PHeaderOrderKey are carried to every hop unchanged.
+type BrowserRedirects struct {
+	// ReferrerPolicy is the referrer policy in effect before any
+	// redirect response sets one. Empty means
+	// "strict-origin-when-cross-origin", the browser default.
+	ReferrerPolicy string
+}
+
+// rewrite updates the headers of req, the next hop of a redirect chain
+// whose earlier requests are via, oldest first. req.Header already holds
+// the headers copied from via[0].
+func (b *BrowserRedirects) rewrite(req *Request, via []*Request) {
+	ireq := via[0]
+	chain := make([]*url.URL, 0, len(via)+1)
+	for _, r := range via {
+		chain = append(chain, r.URL)
+	}
+	chain = append(chain, req.URL)
+
+	// Referer.
+	policy := b.ReferrerPolicy
+	for _, r := range via[1:] {
+		policy = referrerPolicyFromResponse(r.Response, policy)
+	}
+	policy = referrerPolicyFromResponse(req.Response, policy)
+	req.Header.delFold("Referer")
+	if ref, err := url.Parse(ireq.Header.getFold("Referer")); err == nil && ref.Scheme != "" {
+		if s := ReferrerForPolicy(policy, ref, req.URL); s != "" {
+			req.Header.Set("Referer", s)
+		}
+	}
+
+	// The initiator's origin, from the caller's Origin or Referer.
+	var initiator string
+	if o := ireq.Header.getFold("Origin"); o != "" && o != "null" {
+		initiator = o
+	} else if ref, err := url.Parse(ireq.Header.getFold("Referer")); err == nil && ref.Scheme != "" {
+		initiator = URLOrigin(ref)
+	}
+
+	// Origin.
+	if _, ok := ireq.Header.contains("Origin"); ok {
+		if req.Method == "GET" || req.Method == "HEAD" {
+			req.Header.delFold("Origin")
+		} else {
+			for i := 1; i < len(chain); i++ {
+				cur, next := URLOrigin(chain[i-1]), URLOrigin(chain[i])
+				if cur != next && initiator != cur {
+					req.Header.setFold("Origin", "null")
+					break
+				}
+			}
+		}
+	}
+
+	// Authorization.
+	for i := 1; i < len(chain); i++ {
+		if URLOrigin(chain[i-1]) != URLOrigin(chain[i]) {
+			req.Header.delFold("Authorization")
+			req.Header.delFold("Www-Authenticate")
+			break
+		}
+	}
+
+	// Sec-Fetch-*.
+	if !isPotentiallyTrustworthy(req.URL) {
+		for k := range req.Header {
+			if strings.HasPrefix(CanonicalHeaderKey(k), "Sec-Fetch-") {
+				delete(req.Header, k)
+			}
+		}
+		return
+	}
+	if site := ireq.Header.getFold("Sec-Fetch-Site"); site != "" && site != "none" && initiator != "" {
+		site = "same-origin"
+		for _, u := range chain {
+			if URLOrigin(u) == initiator {
+				continue
+			}
+			site = "same-site"
+			if !sameSite(initiator, u) {
+				site = "cross-site"
+				break
+			}
+		}
+		req.Header.setFold("Sec-Fetch-Site", site)
+	}
+}
+
+// getFold is like Get but matches key case-insensitively, since callers
+// may set header keys in any case and they are sent as set.
+func (h Header) getFold(key string) string {
+	if k, ok := h.contains(key); ok {
+		return h.get(k)
+	}
+	return ""
+}
+
+// delFold deletes key from h under every case it is set in.
+func (h Header) delFold(key string) {
+	for k := range h {
+		if k != HeaderOrderKey && k != PHeaderOrderKey && strings.EqualFold(k, key) {
+			delete(h, k)
+		}
+	}
+}
+
+// setFold sets key in canonical form, replacing it under any other case.
+func (h Header) setFold(key, value string) {
+	h.delFold(key)
+	h.Set(key, value)
+}
+
+// referrerPolicyFromResponse returns the policy set by resp's
+// Referrer-Policy header, or policy if it sets none. As with the header's
+// comma-separated list, the last recognized token wins.
+func referrerPolicyFromResponse(resp *Response, policy string) string {
+	if resp == nil {
+		return policy
+	}
+	for _, v := range resp.Header.Values("Referrer-Policy") {
+		for _, tok := range strings.Split(v, ",") {
+			tok = strings.ToLower(strings.TrimSpace(tok))
+			if _, ok := referrerPolicies[tok]; ok {
+				policy = tok
+			}
+		}
+	}
+	return policy
+}
+
+var referrerPolicies = map[string]struct{}{
+	"no-referrer":                     {},
+	"no-referrer-when-downgrade":      {},
+	"same-origin":                     {},
+	"origin":                          {},
+	"strict-origin":                   {},
+	"origin-when-cross-origin":        {},
+	"strict-origin-when-cross-origin": {},
+	"unsafe-url":                      {},
+}
+
+// ReferrerForPolicy returns the Referer to send to target for the
+// referrer ref under a referrer policy such as "origin", or "" for none.
+// An empty policy means "strict-origin-when-cross-origin", the browser
+// default.
+func ReferrerForPolicy(policy string, ref, target *url.URL) string {
+	full := *ref
+	full.User = nil
+	full.Fragment = ""
+	full.RawFragment = ""
+	fullStr := full.String()
+	origin := URLOrigin(ref) + "/"
+	sameOrigin := URLOrigin(ref) == URLOrigin(target)
+	downgrade := isPotentiallyTrustworthy(ref) && !isPotentiallyTrustworthy(target)
+
+	switch policy {
+	case "no-referrer":
+		return ""
+	case "no-referrer-when-downgrade":
+		if downgrade {
+			return ""
+		}
+		return fullStr
+	case "same-origin":
+		if sameOrigin {
+			return fullStr
+		}
+		return ""
+	case "origin":
+		return origin
+	case "strict-origin":
+		if downgrade {
+			return ""
+		}
+		return origin
+	case "origin-when-cross-origin":
+		if sameOrigin {
+			return fullStr
+		}
+		return origin
+	case "unsafe-url":
+		return fullStr
+	default: // strict-origin-when-cross-origin
+		if sameOrigin {
+			return fullStr
+		}
+		if downgrade {
+			return ""
+		}
+		return origin
+	}
+}
+
+// URLOrigin returns the ASCII serialization of u's origin, such as
+// "https://example.com" or "http://example.com:8080".
+func URLOrigin(u *url.URL) string {
+	scheme := strings.ToLower(u.Scheme)
+	host := idnaASCIIFromURL(u)
+	if port := u.Port(); port != "" && schemePort(scheme) != port {
+		host = net.JoinHostPort(host, port)
+	} else if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	return scheme + "://" + host
+}
+
+// isPotentiallyTrustworthy reports whether u is a secure context URL:
+// https, wss, or a loopback host.
+func isPotentiallyTrustworthy(u *url.URL) bool {
+	switch strings.ToLower(u.Scheme) {
+	case "https", "wss":
+		return true
+	}
+	host := u.Hostname()
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// sameSite reports whether u is schemefully same-site with origin.
+func sameSite(origin string, u *url.URL) bool {
+	o, err := url.Parse(origin)
+	return err == nil && URLSite(o) == URLSite(u)
+}
+
+// URLSite returns the schemeful site of u, its scheme and registrable
+// domain, such as "https://example.co.uk" for
+// "https://www.example.co.uk:8443/".
+func URLSite(u *url.URL) string {
+	host := strings.ToLower(u.Hostname())
+	if net.ParseIP(host) == nil {
+		if d, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+			host = d
+		}
+	}
+	return strings.ToLower(u.Scheme) + "://" + host
+}
@@ -107,6 +107,11 @@ type Client struct {
 	// RoundTripper implementations should use the Request's Context
 	// for cancellation instead of implementing CancelRequest.
 	Timeout time.Duration
+
+	// [dhttp] BrowserRedirects, if non-nil, makes the Client rewrite
+	// Referer, Origin, Sec-Fetch-Site and Authorization on redirects as
+	// a browser navigation would, instead of following Go's rules.
+	BrowserRedirects *BrowserRedirects
 }
 
 // DefaultClient is the default [Client] and is used by [Get], [Head], and [Post].
@@ -182,9 +187,10 @@ func (c *Client) send(req *Request, deadline time.Time) (resp *Response, didTime
 		cookieURL.Host = req.Host
 	}
 	if c.Jar != nil {
-		for _, cookie := range c.Jar.Cookies(cookieURL) {
+		for _, cookie := range c.jarCookies(req, cookieURL) { // [dhttp] partitions
 			req.AddCookie(cookie)
 		}
+		req = c.withJarSession(req)
 	}
 	resp, didTimeout, err = send(req, c.transport(), deadline)
 	if err != nil {
@@ -192,7 +198,7 @@ func (c *Client) send(req *Request, deadline time.Time) (resp *Response, didTime
 	}
 	if c.Jar != nil {
 		if rc := resp.Cookies(); len(rc) > 0 {
-			c.Jar.SetCookies(cookieURL, rc)
+			c.setJarCookies(req, cookieURL, rc) // [dhttp] partitions
 		}
 	}
 	return resp, nil, nil
@@ -700,6 +706,9 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 			if ref := refererForURL(reqs[len(reqs)-1].URL, req.URL, req.Header.Get("Referer")); ref != "" {
 				req.Header.Set("Referer", ref)
 			}
+			if c.BrowserRedirects != nil {
+				c.BrowserRedirects.rewrite(req, reqs)
+			}
 			err = c.checkRedirect(req, reqs)
 
 			// Sentinel error to let users select the
@@ -767,10 +776,12 @@ func (c *Client) makeHeadersCopier(ireq *Request) func(req *Request, stripSensit
 	var (
 		ireqhdr  = cloneOrMakeHeader(ireq.Header)
 		icookies map[string][]*Cookie
+		iorder   []*Cookie // [dhttp] for BrowserRedirects
 	)
 	if c.Jar != nil && ireq.Header.Get("Cookie") != "" {
 		icookies = make(map[string][]*Cookie)
-		for _, c := range ireq.Cookies() {
+		iorder = ireq.Cookies()
+		for _, c := range iorder {
 			icookies[c.Name] = append(icookies[c.Name], c)
 		}
 	}
@@ -804,7 +815,17 @@ func (c *Client) makeHeadersCopier(ireq *Request) func(req *Request, stripSensit
 						ss = append(ss, c.Name+"="+c.Value)
 					}
 				}
-				slices.Sort(ss) // Ensure deterministic headers
+				if c.BrowserRedirects != nil {
+					// [dhttp] Keep the caller's cookie order.
+					ss = ss[:0]
+					for _, c := range iorder {
+						if _, ok := icookies[c.Name]; ok {
+							ss = append(ss, c.Name+"="+c.Value)
+						}
+					}
+				} else {
+					slices.Sort(ss) // Ensure deterministic headers
+				}
 				ireqhdr.Set("Cookie", strings.Join(ss, "; "))
 			}
 		}
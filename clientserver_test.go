@@ -448,6 +448,7 @@ func mostlyCopy(r *Response) *Response {
 	c.Body = nil
 	c.TransferEncoding = nil
 	c.TLS = nil
+	c.Conn = nil // [dhttp] per connection, like TLS
 	c.Request = nil
 	return &c
 }
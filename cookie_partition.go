@@ -0,0 +1,77 @@
+package http
+
+import (
+	"context"
+	"net/url"
+)
+
+// A PartitionedCookieJar is a CookieJar that keeps CHIPS partitioned
+// cookies (those with the Partitioned attribute) apart per top-level
+// site, as browsers do, so that a third-party cookie set while embedded
+// in one site is not sent while embedded in another.
+//
+// The Client uses the For methods instead of Cookies and SetCookies for
+// requests whose context carries a top-level site; see WithTopLevelSite.
+// The cookiejar package's Jar implements PartitionedCookieJar.
+type PartitionedCookieJar interface {
+	CookieJar
+
+	// CookiesFor is like Cookies for a request made by a document whose
+	// top-level site is topLevelSite, such as "https://example.com".
+	CookiesFor(u *url.URL, topLevelSite string) []*Cookie
+
+	// SetCookiesFor is like SetCookies for the response to such a
+	// request.
+	SetCookiesFor(u *url.URL, topLevelSite string, cookies []*Cookie)
+}
+
+type topLevelSiteContextKey struct{}
+
+// WithTopLevelSite returns a copy of ctx that makes requests on behalf of
+// a document whose top-level site is site: an origin or URL such as
+// "https://example.com". A Client whose Jar is a PartitionedCookieJar
+// then sends and stores partitioned cookies in that site's partition.
+// Without it, each request is its own top-level site, as for a
+// navigation.
+func WithTopLevelSite(ctx context.Context, site string) context.Context {
+	return context.WithValue(ctx, topLevelSiteContextKey{}, site)
+}
+
+// topLevelSite returns the site set by WithTopLevelSite, or "".
+func topLevelSite(ctx context.Context) string {
+	site, _ := ctx.Value(topLevelSiteContextKey{}).(string)
+	return site
+}
+
+// jarCookies returns the cookies of c.Jar for req, sent to u.
+func (c *Client) jarCookies(req *Request, u *url.URL) []*Cookie {
+	if sj, ok := c.Jar.(SameSiteCookieJar); ok {
+		if r := cookieRequest(req); r != nil {
+			return sj.CookiesForRequest(u, r)
+		}
+	}
+	if pj, ok := c.Jar.(PartitionedCookieJar); ok {
+		if site := topLevelSite(req.Context()); site != "" {
+			return pj.CookiesFor(u, site)
+		}
+	}
+	return c.Jar.Cookies(u)
+}
+
+// setJarCookies stores the cookies of the response to req, sent to u, in
+// c.Jar.
+func (c *Client) setJarCookies(req *Request, u *url.URL, cookies []*Cookie) {
+	if sj, ok := c.Jar.(SameSiteCookieJar); ok {
+		if r := cookieRequest(req); r != nil {
+			sj.SetCookiesForRequest(u, r, cookies)
+			return
+		}
+	}
+	if pj, ok := c.Jar.(PartitionedCookieJar); ok {
+		if site := topLevelSite(req.Context()); site != "" {
+			pj.SetCookiesFor(u, site, cookies)
+			return
+		}
+	}
+	c.Jar.SetCookies(u, cookies)
+}
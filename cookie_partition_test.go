@@ -0,0 +1,54 @@
+package http_test
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/cookiejar"
+	"github.com/dteh/dhttp/httptest"
+)
+
+func TestClientTopLevelSite(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if v := r.URL.Query().Get("set"); v != "" {
+			SetCookie(w, &Cookie{Name: "chips", Value: v, Path: "/", Secure: true, Partitioned: true})
+		}
+		w.Write([]byte(r.Header.Get("Cookie")))
+	}))
+	defer ts.Close()
+
+	jar, _ := cookiejar.New(nil)
+	c := ts.Client()
+	c.Jar = jar
+
+	get := func(site, query string) string {
+		t.Helper()
+		ctx := context.Background()
+		if site != "" {
+			ctx = WithTopLevelSite(ctx, site)
+		}
+		req, _ := NewRequestWithContext(ctx, "GET", ts.URL+"/"+query, nil)
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b := make([]byte, 64)
+		n, _ := resp.Body.Read(b)
+		return string(b[:n])
+	}
+
+	get("https://a.example", "?set=a")
+	get("https://b.example", "?set=b")
+	for _, tt := range []struct{ site, want string }{
+		{"https://www.a.example", "chips=a"},
+		{"https://b.example", "chips=b"},
+		{"https://c.example", ""},
+		{"", ""},
+	} {
+		if got := get(tt.site, ""); got != tt.want {
+			t.Errorf("top-level site %q: Cookie = %q, want %q", tt.site, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package http
+
+import (
+	"context"
+	"net/url"
+)
+
+// A RequestType tells a SameSiteCookieJar how a browser would have issued
+// a request.
+type RequestType int
+
+const (
+	// RequestNavigation is a top-level navigation: a followed link, a
+	// submitted form (a "top-level POST" if its method is POST), a
+	// redirect of either, or a typed URL.
+	RequestNavigation RequestType = iota
+
+	// RequestSubresource is any other request, such as for an image, a
+	// script, an iframe or a fetch() call.
+	RequestSubresource
+)
+
+// A CookieRequest describes the browsing context of a request, so that a
+// SameSiteCookieJar can apply the SameSite rules a browser would.
+type CookieRequest struct {
+	// Method is the request method. Cross-site navigations with an
+	// unsafe method, such as a top-level POST, do not carry Lax cookies.
+	Method string
+
+	// Initiator is the site of the document that issued the request,
+	// such as "https://example.com". Empty means the user did, by typing
+	// a URL or opening a bookmark, which counts as same-site.
+	Initiator string
+
+	// Type is how the request was issued.
+	Type RequestType
+
+	// CrossSiteRedirect reports that the request is a redirect whose
+	// chain passed through a site other than its own, as the last hop
+	// of example.com → other.com → example.com does. Such a request is
+	// cross-site whatever its Initiator.
+	CrossSiteRedirect bool
+
+	// TopLevelSite is the site of the top-level document of a
+	// subresource request, as for PartitionedCookieJar. Empty means the
+	// request's own site.
+	TopLevelSite string
+}
+
+// A SameSiteCookieJar is a CookieJar that can withhold and refuse
+// cookies according to their SameSite attribute, given what a browser
+// would know about each request.
+//
+// The Client uses the ForRequest methods for requests whose context
+// carries an initiator; see WithInitiator. The cookiejar package's Jar
+// implements SameSiteCookieJar, and enforces SameSite when created with
+// Options.EnforceSameSite.
+type SameSiteCookieJar interface {
+	PartitionedCookieJar
+
+	// CookiesForRequest is like Cookies for a request described by r.
+	CookiesForRequest(u *url.URL, r *CookieRequest) []*Cookie
+
+	// SetCookiesForRequest is like SetCookies for the response to a
+	// request described by r.
+	SetCookiesForRequest(u *url.URL, r *CookieRequest, cookies []*Cookie)
+}
+
+type initiatorContextKey struct{}
+
+type initiatorContext struct {
+	site string
+	typ  RequestType
+}
+
+// WithInitiator returns a copy of ctx that makes requests of type typ on
+// behalf of a document of site initiator, such as "https://example.com".
+// An empty initiator means the user. A Client whose Jar is a
+// SameSiteCookieJar passes them, together with the request method and
+// the site set by WithTopLevelSite, to the jar. Redirects keep the
+// initiator and type of the first request, and are cross-site once the
+// redirect chain has left their site; see CookieRequest.CrossSiteRedirect.
+func WithInitiator(ctx context.Context, initiator string, typ RequestType) context.Context {
+	return context.WithValue(ctx, initiatorContextKey{}, initiatorContext{initiator, typ})
+}
+
+// cookieRequest returns the CookieRequest of req, or nil if its context
+// has no initiator.
+func cookieRequest(req *Request) *CookieRequest {
+	ic, ok := req.Context().Value(initiatorContextKey{}).(initiatorContext)
+	if !ok {
+		return nil
+	}
+	method := req.Method
+	if method == "" {
+		method = "GET"
+	}
+	return &CookieRequest{
+		Method:            method,
+		Initiator:         ic.site,
+		Type:              ic.typ,
+		TopLevelSite:      topLevelSite(req.Context()),
+		CrossSiteRedirect: redirectedCrossSite(req),
+	}
+}
+
+// redirectedCrossSite reports whether an earlier request of the redirect
+// chain that led to req, as the Client links them through
+// Request.Response, went to a site other than req's.
+func redirectedCrossSite(req *Request) bool {
+	site := URLSite(req.URL)
+	for resp := req.Response; resp != nil && resp.Request != nil; resp = resp.Request.Response {
+		if URLSite(resp.Request.URL) != site {
+			return true
+		}
+	}
+	return false
+}
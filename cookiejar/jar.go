@@ -21,6 +21,7 @@ import (
 	http "github.com/dteh/dhttp"
 
 	"github.com/dteh/dhttp/internal/ascii"
+	"github.com/dteh/dhttp/publicsuffix"
 	"net/netip"
 )
 
@@ -37,7 +38,8 @@ import (
 // set a cookie for bar.com.
 //
 // A public suffix list implementation is in the package
-// golang.org/x/net/publicsuffix.
+// golang.org/x/net/publicsuffix. Package github.com/dteh/dhttp/publicsuffix
+// embeds another one. [dhttp]
 type PublicSuffixList interface {
 	// PublicSuffix returns the public suffix of domain.
 	//
@@ -61,12 +63,24 @@ type Options struct {
 	// secure: it means that the HTTP server for foo.co.uk can set a cookie
 	// for bar.co.uk.
 	PublicSuffixList PublicSuffixList
+
+	// UseBuiltinPublicSuffixList makes a nil PublicSuffixList mean the
+	// list embedded in package github.com/dteh/dhttp/publicsuffix
+	// instead of none. [dhttp]
+	UseBuiltinPublicSuffixList bool
+
+	// EnforceSameSite makes CookiesForRequest and SetCookiesForRequest
+	// withhold and refuse cookies on cross-site requests according to
+	// their SameSite attribute, as browsers do. [dhttp]
+	EnforceSameSite bool
 }
 
 // Jar implements the http.CookieJar interface from the net/http package.
 type Jar struct {
 	psList PublicSuffixList
 
+	enforceSameSite bool // [dhttp]
+
 	// mu locks the remaining fields.
 	mu sync.Mutex
 
@@ -87,6 +101,10 @@ func New(o *Options) (*Jar, error) {
 	}
 	if o != nil {
 		jar.psList = o.PublicSuffixList
+		if jar.psList == nil && o.UseBuiltinPublicSuffixList {
+			jar.psList = publicsuffix.List
+		}
+		jar.enforceSameSite = o.EnforceSameSite
 	}
 	return jar, nil
 }
@@ -106,6 +124,13 @@ type entry struct {
 	HttpOnly   bool
 	Persistent bool
 	HostOnly   bool
+
+	// Partitioned is the CHIPS Partitioned attribute and PartitionKey
+	// the schemeful top-level site the cookie was set under, such as
+	// "https://example.com". [dhttp]
+	Partitioned  bool
+	PartitionKey string
+
 	Expires    time.Time
 	Creation   time.Time
 	LastAccess time.Time
@@ -116,8 +141,12 @@ type entry struct {
 	seqNum uint64
 }
 
-// id returns the domain;path;name triple of e as an id.
+// id returns the domain;path;name triple of e as an id, followed by
+// ;partitionKey for partitioned cookies. [dhttp]
 func (e *entry) id() string {
+	if e.PartitionKey != "" {
+		return fmt.Sprintf("%s;%s;%s;%s", e.Domain, e.Path, e.Name, e.PartitionKey)
+	}
 	return fmt.Sprintf("%s;%s;%s", e.Domain, e.Path, e.Name)
 }
 
@@ -199,6 +228,12 @@ func (j *Jar) Cookies(u *url.URL) (cookies []*http.Cookie) {
 
 // cookies is like Cookies but takes the current time as a parameter.
 func (j *Jar) cookies(u *url.URL, now time.Time) (cookies []*http.Cookie) {
+	return j.cookiesFor(u, nil, now)
+}
+
+// cookiesFor is like CookiesForRequest but takes the current time as a
+// parameter. r may be nil. [dhttp]
+func (j *Jar) cookiesFor(u *url.URL, r *http.CookieRequest, now time.Time) (cookies []*http.Cookie) {
 	if u.Scheme != "http" && u.Scheme != "https" {
 		return cookies
 	}
@@ -207,6 +242,8 @@ func (j *Jar) cookies(u *url.URL, now time.Time) (cookies []*http.Cookie) {
 		return cookies
 	}
 	key := jarKey(host, j.psList)
+	partition := j.partitionKey(topLevelSite(r), u.Scheme, host)
+	sameSite := j.sameSiteContext(u.Scheme, host, r)
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -230,6 +267,12 @@ func (j *Jar) cookies(u *url.URL, now time.Time) (cookies []*http.Cookie) {
 			modified = true
 			continue
 		}
+		if e.PartitionKey != "" && e.PartitionKey != partition {
+			continue
+		}
+		if !e.sameSiteAllows(sameSite, now) {
+			continue
+		}
 		if !e.shouldSend(https, host, path) {
 			continue
 		}
@@ -273,6 +316,12 @@ func (j *Jar) SetCookies(u *url.URL, cookies []*http.Cookie) {
 
 // setCookies is like SetCookies but takes the current time as parameter.
 func (j *Jar) setCookies(u *url.URL, cookies []*http.Cookie, now time.Time) {
+	j.setCookiesFor(u, nil, cookies, now)
+}
+
+// setCookiesFor is like SetCookiesForRequest but takes the current time
+// as parameter. r may be nil. [dhttp]
+func (j *Jar) setCookiesFor(u *url.URL, r *http.CookieRequest, cookies []*http.Cookie, now time.Time) {
 	if len(cookies) == 0 {
 		return
 	}
@@ -285,6 +334,9 @@ func (j *Jar) setCookies(u *url.URL, cookies []*http.Cookie, now time.Time) {
 	}
 	key := jarKey(host, j.psList)
 	defPath := defaultPath(u.Path)
+	partition := j.partitionKey(topLevelSite(r), u.Scheme, host)
+	sameSite := j.sameSiteContext(u.Scheme, host, r)
+	secureOrigin := isSecureOrigin(u.Scheme, host)
 
 	j.mu.Lock()
 	defer j.mu.Unlock()
@@ -297,6 +349,17 @@ func (j *Jar) setCookies(u *url.URL, cookies []*http.Cookie, now time.Time) {
 		if err != nil {
 			continue
 		}
+		if cookie.Partitioned {
+			// CHIPS cookies can only be set from secure origins, and
+			// only into a known partition.
+			if !secureOrigin || partition == "" {
+				continue
+			}
+			e.PartitionKey = partition
+		}
+		if !sameSite.allowsSet(cookie) {
+			continue
+		}
 		id := e.id()
 		if remove {
 			if submap != nil {
@@ -436,6 +499,10 @@ func defaultPath(path string) string {
 func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e entry, remove bool, err error) {
 	e.Name = c.Name
 
+	if c.Partitioned && !c.Secure {
+		return e, false, errPartitionedNotSecure
+	}
+
 	if c.Path == "" || c.Path[0] != '/' {
 		e.Path = defPath
 	} else {
@@ -470,6 +537,7 @@ func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e e
 	e.Quoted = c.Quoted
 	e.Secure = c.Secure
 	e.HttpOnly = c.HttpOnly
+	e.Partitioned = c.Partitioned
 
 	switch c.SameSite {
 	case http.SameSiteDefaultMode:
@@ -478,6 +546,8 @@ func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e e
 		e.SameSite = "SameSite=Strict"
 	case http.SameSiteLaxMode:
 		e.SameSite = "SameSite=Lax"
+	case http.SameSiteNoneMode:
+		e.SameSite = "SameSite=None"
 	}
 
 	return e, false, nil
@@ -486,6 +556,8 @@ func (j *Jar) newEntry(c *http.Cookie, now time.Time, defPath, host string) (e e
 var (
 	errIllegalDomain   = errors.New("cookiejar: illegal cookie domain attribute")
 	errMalformedDomain = errors.New("cookiejar: malformed cookie domain attribute")
+
+	errPartitionedNotSecure = errors.New("cookiejar: partitioned cookie without secure attribute")
 )
 
 // endOfTime is the time when session (non-persistent) cookies expire.
@@ -0,0 +1,94 @@
+package cookiejar
+
+import (
+	"testing"
+
+	http "github.com/dteh/dhttp"
+)
+
+func TestPartitionedCookies(t *testing.T) {
+	jar, _ := New(&Options{PublicSuffixList: testPSL{}})
+	embed := mustParseURL("https://widget.example.com/frame")
+	const siteA, siteB = "https://www.a.co.uk/page", "https://b.com"
+
+	jar.SetCookiesFor(embed, siteA, []*http.Cookie{
+		{Name: "chips", Value: "a", Secure: true, Partitioned: true},
+		{Name: "insecure", Value: "1", Partitioned: true},
+		{Name: "plain", Value: "1", Secure: true},
+	})
+	jar.SetCookiesFor(embed, siteB, []*http.Cookie{
+		{Name: "chips", Value: "b", Secure: true, Partitioned: true},
+	})
+	jar.SetCookies(embed, []*http.Cookie{
+		{Name: "chips", Value: "self", Secure: true, Partitioned: true},
+	})
+	jar.SetCookiesFor(mustParseURL("http://widget.example.com/"), siteA, []*http.Cookie{
+		{Name: "http", Value: "1", Secure: true, Partitioned: true},
+	})
+	jar.SetCookiesFor(embed, "https://", []*http.Cookie{
+		{Name: "nosite", Value: "1", Secure: true, Partitioned: true},
+	})
+
+	for _, tt := range []struct {
+		site, want string
+	}{
+		{siteA, "chips=a plain=1"},
+		{"https://a.co.uk", "chips=a plain=1"},
+		{"www.a.co.uk", "chips=a plain=1"},
+		{"http://www.a.co.uk", "plain=1"},
+		{siteB, "plain=1 chips=b"},
+		{"", "plain=1 chips=self"},
+		{"https://example.com", "plain=1 chips=self"},
+		{"https://c.com", "plain=1"},
+		{"https://", "plain=1"},
+	} {
+		if got := cookiesToString(jar.CookiesFor(embed, tt.site)); got != tt.want {
+			t.Errorf("CookiesFor(%q) = %q, want %q", tt.site, got, tt.want)
+		}
+	}
+
+	// Expiring a partitioned cookie only touches its own partition.
+	jar.SetCookiesFor(embed, siteB, []*http.Cookie{
+		{Name: "chips", Secure: true, Partitioned: true, MaxAge: -1},
+	})
+	if got := cookiesToString(jar.CookiesFor(embed, siteB)); got != "plain=1" {
+		t.Errorf("after delete, CookiesFor(siteB) = %q", got)
+	}
+	if got := cookiesToString(jar.CookiesFor(embed, siteA)); got != "chips=a plain=1" {
+		t.Errorf("after delete, CookiesFor(siteA) = %q", got)
+	}
+
+	keys := map[string]bool{}
+	for _, e := range jar.ForDomain("widget.example.com") {
+		if e.Partitioned {
+			keys[e.PartitionKey] = true
+		}
+	}
+	if len(keys) != 2 || !keys["https://a.co.uk"] || !keys["https://example.com"] {
+		t.Errorf("partition keys = %v", keys)
+	}
+	if !jar.Delete("widget.example.com", "/", "chips") || len(jar.ForDomain("widget.example.com")) != 1 {
+		t.Errorf("Delete did not remove the cookie from every partition: %v", jar.All())
+	}
+}
+
+func TestAddPartitioned(t *testing.T) {
+	jar, _ := New(nil)
+	err := jar.Add(
+		Entry{Name: "own", Value: "1", Domain: "www.example.com", HostOnly: true, Path: "/", Secure: true, Partitioned: true},
+		Entry{Name: "other", Value: "2", Domain: "www.example.com", HostOnly: true, Path: "/", Secure: true, PartitionKey: "https://other.com"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := mustParseURL("https://www.example.com/")
+	if got := cookiesToString(jar.Cookies(u)); got != "own=1" {
+		t.Errorf("Cookies = %q, want own=1", got)
+	}
+	if got := cookiesToString(jar.CookiesFor(u, "https://www.other.com")); got != "other=2" {
+		t.Errorf("CookiesFor(other.com) = %q, want other=2", got)
+	}
+	if err := jar.Add(Entry{Name: "x", Domain: "example.com", Partitioned: true}); err == nil {
+		t.Error("Add accepted a partitioned cookie without Secure")
+	}
+}
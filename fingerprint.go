@@ -0,0 +1,293 @@
+package http
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"reflect"
+	"strconv"
+	"sync"
+
+	tls "github.com/refraction-networking/utls"
+)
+
+// A Fingerprint is one self-consistent client identity: the TLS ClientHello
+// to parrot, together with the header order and HTTP/2 settings that the
+// same browser would send.
+type Fingerprint struct {
+	// Name identifies the fingerprint in Response.Fingerprint.
+	// If empty, it is derived from ClientHello.HelloID.
+	Name string
+
+	// ClientHello is used in place of Transport.ClientHelloSettings
+	// for connections made with this fingerprint.
+	ClientHello ClientHelloSettings
+
+	// HeaderOrder and PHeaderOrder are sent as the request's
+	// HeaderOrderKey and PHeaderOrderKey entries when the request
+	// does not set them itself.
+	HeaderOrder  []string
+	PHeaderOrder []string
+
+	// HTTP2, if non-nil, is merged over Transport.HTTP2 for HTTP/2
+	// connections made with this fingerprint. Only non-zero fields
+	// take effect.
+	HTTP2 *HTTP2Config
+
+	// Weight is the relative probability of picking this fingerprint.
+	// Zero or negative weights count as 1.
+	Weight int
+}
+
+// FingerprintPin selects what a [FingerprintRotation] keeps a chosen
+// fingerprint for.
+type FingerprintPin int
+
+const (
+	// PinPerHost picks a fingerprint per connection pool key: every
+	// connection to the same host (through the same proxy) presents
+	// the same identity.
+	PinPerHost FingerprintPin = iota
+
+	// PinPerSession picks a fingerprint per session, as set with
+	// WithFingerprintSession. A Client with a cookie jar uses the jar
+	// as the session of its requests, so one jar is one identity.
+	// Requests without a session fall back to PinPerHost. The rotation
+	// keeps a session reachable while it remembers its pin; see
+	// FingerprintRotation.MaxPins.
+	PinPerSession
+)
+
+// defaultMaxFingerprintPins is the default FingerprintRotation.MaxPins.
+const defaultMaxFingerprintPins = 1024
+
+// A FingerprintRotation chooses the [Fingerprint] presented by each new
+// connection of a Transport; see Transport.Fingerprints. Connections made
+// with different fingerprints are never shared between requests, so a
+// request always travels on a connection of its pinned identity.
+//
+// A FingerprintRotation must not be modified or copied after first use.
+type FingerprintRotation struct {
+	// Fingerprints is the weighted set to choose from.
+	Fingerprints []Fingerprint
+
+	// Randomized, if positive, adds that many fingerprints built from
+	// utls' randomized parrot (tls.HelloRandomized). Each one has a
+	// fixed PRNG seed, so it produces the same ClientHello every time
+	// it is used.
+	Randomized int
+
+	// Seed seeds both the choice of fingerprints and the randomized
+	// parrots, making a rotation reproducible across runs.
+	Seed uint64
+
+	// Pin selects what a chosen fingerprint is kept for.
+	Pin FingerprintPin
+
+	// RotateAfter, if positive, picks a new fingerprint for a pin once
+	// that many connections have been dialed with the current one.
+	// Idle connections of the previous fingerprint are not reused.
+	RotateAfter int
+
+	// MaxPins bounds the number of hosts and sessions the rotation
+	// remembers a fingerprint for, and so keeps reachable. Beyond it,
+	// the least recently used pin is forgotten: its host or session
+	// gets a new fingerprint with its next request, and idle
+	// connections of the old one are not reused. Zero means 1024.
+	MaxPins int
+
+	initOnce sync.Once
+	initErr  error
+
+	mu     sync.Mutex
+	rng    *rand.Rand
+	fps    []Fingerprint
+	weight int
+	pins   map[any]*list.Element // of *fingerprintPin, in lru
+	lru    list.List             // most recently used at the front
+	seq    uint64
+}
+
+// fingerprintPin is the fingerprint currently assigned to one pin.
+type fingerprintPin struct {
+	rot   *FingerprintRotation
+	key   any // in rot.pins
+	fp    *Fingerprint
+	id    string // connection pool key component; unique per pin and rotation
+	dials int    // guarded by rot.mu
+}
+
+func (r *FingerprintRotation) init() error {
+	r.initOnce.Do(func() {
+		r.rng = rand.New(rand.NewPCG(r.Seed, r.Seed^0x9e3779b97f4a7c15))
+		r.pins = make(map[any]*list.Element)
+		r.fps = append(r.fps, r.Fingerprints...)
+		for i := 0; i < r.Randomized; i++ {
+			seed := new(tls.PRNGSeed)
+			for j := range seed {
+				seed[j] = byte(r.rng.Uint32())
+			}
+			id := tls.HelloRandomized
+			id.Seed = seed
+			r.fps = append(r.fps, Fingerprint{
+				Name:        "randomized-" + strconv.Itoa(i),
+				ClientHello: ClientHelloSettings{HelloID: id},
+			})
+		}
+		if len(r.fps) == 0 {
+			r.initErr = errors.New("http: FingerprintRotation has no fingerprints")
+			return
+		}
+		for i := range r.fps {
+			fp := &r.fps[i]
+			if fp.Name == "" {
+				fp.Name = fp.ClientHello.HelloID.Str()
+			}
+			r.weight += max(fp.Weight, 1)
+		}
+	})
+	return r.initErr
+}
+
+// pick returns the pin for a request with context ctx on the connection
+// pool key hostKey, assigning a fingerprint to it first if needed.
+func (r *FingerprintRotation) pick(ctx context.Context, hostKey connectMethodKey) (*fingerprintPin, error) {
+	if err := r.init(); err != nil {
+		return nil, err
+	}
+	var key any = hostKey
+	if r.Pin == PinPerSession {
+		if s := fingerprintSession(ctx); s != nil {
+			key = fingerprintSessionKey{s}
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	e := r.pins[key]
+	if e == nil {
+		e = r.lru.PushFront(r.newPinLocked(key))
+		r.pins[key] = e
+		maxPins := r.MaxPins
+		if maxPins <= 0 {
+			maxPins = defaultMaxFingerprintPins
+		}
+		for r.lru.Len() > maxPins {
+			delete(r.pins, r.lru.Remove(r.lru.Back()).(*fingerprintPin).key)
+		}
+	} else {
+		r.lru.MoveToFront(e)
+		if p := e.Value.(*fingerprintPin); r.RotateAfter > 0 && p.dials >= r.RotateAfter {
+			e.Value = r.newPinLocked(key)
+		}
+	}
+	return e.Value.(*fingerprintPin), nil
+}
+
+// newPinLocked chooses a fingerprint for a new pin of key, or for one
+// whose previous fingerprint has been rotated out.
+func (r *FingerprintRotation) newPinLocked(key any) *fingerprintPin {
+	n := r.rng.IntN(r.weight)
+	fp := &r.fps[len(r.fps)-1]
+	for i := range r.fps {
+		if n -= max(r.fps[i].Weight, 1); n < 0 {
+			fp = &r.fps[i]
+			break
+		}
+	}
+	r.seq++
+	return &fingerprintPin{rot: r, key: key, fp: fp, id: fp.Name + "#" + strconv.FormatUint(r.seq, 10)}
+}
+
+// countDial records that a connection was dialed for p, so that
+// RotateAfter can take effect.
+func (p *fingerprintPin) countDial() {
+	p.rot.mu.Lock()
+	p.dials++
+	p.rot.mu.Unlock()
+}
+
+type fingerprintSessionContextKey struct{}
+
+// fingerprintSessionKey keeps session keys apart from connectMethodKeys
+// in FingerprintRotation.pins.
+type fingerprintSessionKey struct{ s any }
+
+// WithFingerprintSession returns a copy of ctx that makes requests
+// carrying it share one fingerprint when the Transport's rotation uses
+// PinPerSession. session must be comparable.
+func WithFingerprintSession(ctx context.Context, session any) context.Context {
+	return context.WithValue(ctx, fingerprintSessionContextKey{}, session)
+}
+
+func fingerprintSession(ctx context.Context) any {
+	return ctx.Value(fingerprintSessionContextKey{})
+}
+
+// withJarSession returns req with c.Jar as its fingerprint session when
+// the Client's Transport pins fingerprints per session and req has no
+// session of its own.
+func (c *Client) withJarSession(req *Request) *Request {
+	t, ok := c.transport().(*Transport)
+	if !ok || t.Fingerprints == nil || t.Fingerprints.Pin != PinPerSession {
+		return req
+	}
+	if fingerprintSession(req.Context()) != nil || !reflect.TypeOf(c.Jar).Comparable() {
+		return req
+	}
+	return req.WithContext(WithFingerprintSession(req.Context(), c.Jar))
+}
+
+// fingerprintPoolContextKey carries the pool key component of a request's
+// fingerprint pin from Transport.roundTrip to the HTTP/2 connection pool.
+type fingerprintPoolContextKey struct{}
+
+// fingerprintPoolSuffix returns the HTTP/2 connection pool key suffix for
+// a request, so that requests of different fingerprints do not share
+// HTTP/2 connections.
+func fingerprintPoolSuffix(ctx context.Context) string {
+	if id, _ := ctx.Value(fingerprintPoolContextKey{}).(string); id != "" {
+		return "|" + id
+	}
+	return ""
+}
+
+func (t *Transport) connFingerprint(c net.Conn) *fingerprintPin {
+	v, _ := t.fingerprintConns.Load(c)
+	p, _ := v.(*fingerprintPin)
+	return p
+}
+
+// withFingerprint returns req prepared to be sent on a connection of the
+// fingerprint pinned in cm: its header order defaults come from the
+// fingerprint, and its context names the pin for the HTTP/2 connection
+// pool. req itself is not modified.
+func (cm *connectMethod) withFingerprint(req *Request) *Request {
+	p := cm.fingerprint
+	if p == nil {
+		return req
+	}
+	r2 := req.WithContext(context.WithValue(req.Context(), fingerprintPoolContextKey{}, p.id))
+	var h Header
+	for _, o := range []struct {
+		key   string
+		order []string
+	}{
+		{HeaderOrderKey, p.fp.HeaderOrder},
+		{PHeaderOrderKey, p.fp.PHeaderOrder},
+	} {
+		if len(o.order) == 0 || len(req.Header[o.key]) > 0 {
+			continue
+		}
+		if h == nil {
+			h = req.Header.Clone()
+		}
+		h[o.key] = o.order
+	}
+	if h != nil {
+		r2.Header = h
+	}
+	return r2
+}
@@ -0,0 +1,186 @@
+package http_test
+
+import (
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/cookiejar"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/httptrace"
+	tls "github.com/refraction-networking/utls"
+)
+
+var testFingerprints = []Fingerprint{
+	{
+		Name:        "chrome",
+		ClientHello: ClientHelloSettings{HelloID: tls.HelloChrome_133},
+		HeaderOrder: []string{"user-agent", "accept-encoding", "x-b", "x-a"},
+	},
+	{
+		Name:        "firefox",
+		ClientHello: ClientHelloSettings{HelloID: tls.HelloFirefox_120},
+		HeaderOrder: []string{"user-agent", "accept-encoding", "x-a", "x-b"},
+		HTTP2:       &HTTP2Config{MaxReceiveBufferPerStream: 128 << 10},
+	},
+}
+
+// fingerprintGet performs a GET and reports the Response.Fingerprint, the
+// order of the custom headers written and whether the connection was
+// reused.
+func fingerprintGet(t *testing.T, cl *Client, url string) (fp, order string, reused bool) {
+	t.Helper()
+	trace := &httptrace.ClientTrace{
+		GotConn: func(i httptrace.GotConnInfo) { reused = i.Reused },
+		WroteHeaderField: func(key string, _ []string) {
+			if strings.HasPrefix(strings.ToLower(key), "x-") {
+				order += strings.ToLower(key) + " "
+			}
+		},
+	}
+	req, _ := NewRequest("GET", url, nil)
+	req.Header.Set("X-A", "1")
+	req.Header.Set("X-B", "2")
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	resp, err := cl.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Fingerprint, order, reused
+}
+
+func TestFingerprintRotationPinPerHost(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		name := "h1"
+		if h2 {
+			name = "h2"
+		}
+		t.Run(name, func(t *testing.T) {
+			var proto atomic.Int32
+			ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+				proto.Store(int32(r.ProtoMajor))
+			}))
+			ts.EnableHTTP2 = h2
+			ts.StartTLS()
+			defer ts.Close()
+
+			cl := ts.Client()
+			tr := cl.Transport.(*Transport)
+			tr.Fingerprints = &FingerprintRotation{Fingerprints: testFingerprints}
+
+			first, firstOrder, _ := fingerprintGet(t, cl, ts.URL)
+			if first != "chrome" && first != "firefox" {
+				t.Fatalf("Response.Fingerprint = %q", first)
+			}
+			for i := 0; i < 3; i++ {
+				fp, order, reused := fingerprintGet(t, cl, ts.URL)
+				if fp != first {
+					t.Errorf("request %d: fingerprint %q, want pinned %q", i, fp, first)
+				}
+				if !reused {
+					t.Errorf("request %d: connection not reused", i)
+				}
+				if !h2 && order != firstOrder {
+					t.Errorf("request %d: header order %q, want %q", i, order, firstOrder)
+				}
+			}
+			if want := map[bool]int32{false: 1, true: 2}[h2]; proto.Load() != want {
+				t.Errorf("server saw HTTP/%d, want HTTP/%d", proto.Load(), want)
+			}
+			if want := map[string]string{"chrome": "x-b x-a ", "firefox": "x-a x-b "}[first]; !h2 && firstOrder != want {
+				t.Errorf("header order = %q, want %q", firstOrder, want)
+			}
+		})
+	}
+}
+
+func TestFingerprintRotationPinPerSession(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	tr := ts.Client().Transport.(*Transport)
+	tr.Fingerprints = &FingerprintRotation{
+		Fingerprints: testFingerprints,
+		Pin:          PinPerSession,
+		Seed:         1,
+	}
+	seen := map[string]bool{}
+	for i := 0; i < 16; i++ {
+		jar, _ := cookiejar.New(nil)
+		cl := &Client{Transport: tr, Jar: jar}
+		fp, _, _ := fingerprintGet(t, cl, ts.URL)
+		for j := 0; j < 2; j++ {
+			if got, _, _ := fingerprintGet(t, cl, ts.URL); got != fp {
+				t.Fatalf("session %d: fingerprint changed from %q to %q", i, fp, got)
+			}
+		}
+		seen[fp] = true
+	}
+	if len(seen) != 2 {
+		t.Errorf("16 sessions used fingerprints %v, want both", seen)
+	}
+}
+
+func TestFingerprintRotationRotateAfter(t *testing.T) {
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	cl := ts.Client()
+	tr := cl.Transport.(*Transport)
+	tr.Fingerprints = &FingerprintRotation{Fingerprints: testFingerprints, RotateAfter: 1}
+	for i := 0; i < 3; i++ {
+		if _, _, reused := fingerprintGet(t, cl, ts.URL); reused {
+			t.Errorf("request %d reused a connection of a rotated-out fingerprint", i)
+		}
+	}
+}
+
+func TestFingerprintRotationRandomized(t *testing.T) {
+	for _, h2 := range []bool{false, true} {
+		ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+		ts.EnableHTTP2 = h2
+		ts.StartTLS()
+		defer ts.Close()
+
+		cl := ts.Client()
+		tr := cl.Transport.(*Transport)
+		tr.Fingerprints = &FingerprintRotation{Randomized: 3, Seed: 42}
+		if fp, _, _ := fingerprintGet(t, cl, ts.URL); !strings.HasPrefix(fp, "randomized-") {
+			t.Errorf("h2=%v: Response.Fingerprint = %q, want a randomized parrot", h2, fp)
+		}
+	}
+}
+
+func TestFingerprintRotationEmpty(t *testing.T) {
+	tr := &Transport{Fingerprints: &FingerprintRotation{}}
+	req, _ := NewRequest("GET", "https://example.com/", nil)
+	if _, err := tr.RoundTrip(req); err == nil {
+		t.Fatal("RoundTrip with an empty FingerprintRotation succeeded")
+	}
+}
+
+func TestFingerprintRotationMaxPins(t *testing.T) {
+	a := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer a.Close()
+	b := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer b.Close()
+
+	cl := a.Client()
+	tr := cl.Transport.(*Transport)
+	tr.Fingerprints = &FingerprintRotation{Fingerprints: testFingerprints, MaxPins: 1}
+
+	fingerprintGet(t, cl, a.URL)
+	if _, _, reused := fingerprintGet(t, cl, a.URL); !reused {
+		t.Error("second request to a: connection not reused")
+	}
+	// b's pin takes the place of a's, whose connection is then left
+	// behind with its forgotten fingerprint.
+	fingerprintGet(t, cl, b.URL)
+	if _, _, reused := fingerprintGet(t, cl, a.URL); reused {
+		t.Error("request to a after its pin was evicted reused the old connection")
+	}
+}
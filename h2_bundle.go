@@ -7546,6 +7546,10 @@ func http2configureTransports(t1 *Transport) (*http2Transport, error) {
 	}
 	upgradeFn := func(scheme, authority string, c net.Conn) RoundTripper {
 		addr := http2authorityAddr(scheme, authority)
+		if p := t1.connFingerprint(c); p != nil {
+			addr += "|" + p.id // [dhttp] see fingerprintPoolSuffix
+		}
+		addr += http2ProxyPoolSuffix(t1.connProxyKey(c)) // [dhttp]
 		if used, err := connPool.addConnIfNeeded(addr, t2, c); err != nil {
 			go c.Close()
 			return http2erringRoundTripper{err}
@@ -7609,6 +7613,7 @@ type http2ClientConn struct {
 	atomicReused  uint32               // whether conn is being reused; atomic
 	singleUse     bool                 // whether being used for a single http.Request
 	getConnCalled bool                 // used by clientConnPool
+	connInfo      *ConnInfo            // [dhttp] for Response.Conn; may be nil
 
 	// readLoop goroutine fields:
 	readerDone chan struct{} // closed on error
@@ -7891,7 +7896,8 @@ func (t *http2Transport) RoundTripOpt(req *Request, opt http2RoundTripOpt) (*Res
 		return nil, errors.New("http2: unsupported scheme")
 	}
 
-	addr := http2authorityAddr(req.URL.Scheme, req.URL.Host)
+	addr := http2authorityAddr(req.URL.Scheme, req.URL.Host) + fingerprintPoolSuffix(req.Context()) +
+		http2ProxyPoolSuffix(http2RequestProxy(req.Context())) // [dhttp]
 	for retry := 0; ; retry++ {
 		cc, err := t.connPool().GetClientConn(req, addr)
 		if err != nil {
@@ -8083,6 +8089,13 @@ func (t *http2Transport) NewClientConn(c net.Conn) (*http2ClientConn, error) {
 
 func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalStateHook func()) (*http2ClientConn, error) {
 	conf := http2configFromTransport(t)
+	if t.t1 != nil {
+		// [dhttp] Fingerprint HTTP/2 settings apply over Transport.HTTP2.
+		if p := t.t1.connFingerprint(c); p != nil && p.fp.HTTP2 != nil {
+			http2fillNetHTTPConfig(&conf, p.fp.HTTP2)
+			http2setConfigDefaults(&conf, false)
+		}
+	}
 	cc := &http2ClientConn{
 		t:                           t,
 		tconn:                       c,
@@ -8105,6 +8118,9 @@ func (t *http2Transport) newClientConn(c net.Conn, singleUse bool, internalState
 		lastActive:                  time.Now(),
 		internalStateHook:           internalStateHook,
 	}
+	if t.t1 != nil {
+		cc.connInfo = t.t1.connInfoOf(c) // [dhttp] for Response.Conn
+	}
 	if t.http2transportTestHooks != nil {
 		t.http2transportTestHooks.newclientconn(cc)
 		c = cc.tconn
@@ -8923,6 +8939,7 @@ func http2encodeRequestHeaders(req *Request, addGzipHeader bool, peerMaxHeaderLi
 			Host:                req.Host,
 			Method:              req.Method,
 			ActualContentLength: http2actualContentLength(req),
+			RawRequestTarget:    req.RawRequestTarget, // [dhttp]
 		},
 		AddGzipHeader:         addGzipHeader,
 		PeerMaxHeaderListSize: peerMaxHeaderListSize,
@@ -9709,6 +9726,14 @@ func (rl *http2clientConnReadLoop) handleResponse(cs *http2clientStream, f *http
 		return nil, errors.New("malformed response from server: malformed non-numeric status pseudo header")
 	}
 
+	// [dhttp] GotHeaderField: report the final response's fields in
+	// wire order.
+	if cs.trace != nil && cs.trace.GotHeaderField != nil && (statusCode < 100 || statusCode > 199) {
+		for _, hf := range f.Fields {
+			cs.trace.GotHeaderField(hf.Name, []string{hf.Value})
+		}
+	}
+
 	regularFields := f.RegularFields()
 	strs := make([]string, len(regularFields))
 	header := make(Header, len(regularFields))
@@ -9718,6 +9743,7 @@ func (rl *http2clientConnReadLoop) handleResponse(cs *http2clientStream, f *http
 		Header:     header,
 		StatusCode: statusCode,
 		Status:     status + " " + StatusText(statusCode),
+		Conn:       cs.cc.connInfo, // [dhttp]
 	}
 	for _, hf := range regularFields {
 		key := httpcommon.CanonicalHeader(hf.Name)
@@ -0,0 +1,188 @@
+// Package har records HTTP traffic sent through dhttp in the HTTP Archive
+// (HAR) 1.2 format, for comparison with the exports of browser developer
+// tools.
+//
+// A Recorder wraps a RoundTripper:
+//
+//	rec := &har.Recorder{Transport: tr}
+//	client := &http.Client{Transport: rec}
+//	...
+//	err := rec.WriteHAR(f)
+//
+// Request and response headers are recorded as they appeared on the wire,
+// in order and including HTTP/2 pseudo-header fields, using the
+// ClientTrace hooks WroteHeaderField and GotHeaderField. Timings come from
+// the other ClientTrace hooks. Each entry also carries a custom
+// "_tlsFingerprint" field describing the parroted ClientHello and the
+// negotiated TLS parameters.
+package har
+
+import (
+	"encoding/json"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// HAR is the root of an HTTP Archive.
+type HAR struct {
+	Log Log `json:"log"`
+}
+
+// Log is the "log" object of an HTTP Archive.
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+}
+
+// Creator names the application that created the archive.
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+// Entry is one exchange of a request and its response.
+type Entry struct {
+	StartedDateTime time.Time       `json:"startedDateTime"`
+	Time            float64         `json:"time"` // milliseconds
+	Request         Request         `json:"request"`
+	Response        Response        `json:"response"`
+	Cache           struct{}        `json:"cache"`
+	Timings         Timings         `json:"timings"`
+	ServerIPAddress string          `json:"serverIPAddress,omitempty"`
+	Connection      string          `json:"connection,omitempty"`
+	TLSFingerprint  *TLSFingerprint `json:"_tlsFingerprint,omitempty"`
+
+	// Error is the error of a request that got no response, whose
+	// Response has a zero status.
+	Error string `json:"_error,omitempty"`
+}
+
+// Request is the "request" object of an entry.
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	PostData    *PostData   `json:"postData,omitempty"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// Response is the "response" object of an entry.
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []Cookie    `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int64       `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+// NameValue is a header field or query parameter.
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Cookie is a cookie sent with a request or set by a response.
+type Cookie struct {
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Path     string     `json:"path,omitempty"`
+	Domain   string     `json:"domain,omitempty"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	HTTPOnly bool       `json:"httpOnly,omitempty"`
+	Secure   bool       `json:"secure,omitempty"`
+	SameSite string     `json:"sameSite,omitempty"`
+}
+
+// PostData is the body of a request.
+type PostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+
+	// Encoding is "base64" if Text holds a base64-encoded binary body.
+	Encoding string `json:"_encoding,omitempty"`
+}
+
+// Content is the body of a response, after any content decoding done by
+// the Transport.
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// Timings are the durations of the phases of an exchange, in
+// milliseconds. Phases that did not happen, such as DNS, Connect and
+// SSL on a reused connection, are -1. As the HAR format requires,
+// Connect includes SSL.
+type Timings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// TLSFingerprint describes the TLS side of an exchange.
+type TLSFingerprint struct {
+	// ClientHello is the parroted ClientHelloID, such as "Chrome-133",
+	// or the name of the Fingerprint chosen by the Transport's
+	// FingerprintRotation. It is empty if the Recorder's Transport is
+	// not a *http.Transport.
+	ClientHello string `json:"clientHello,omitempty"`
+
+	Version     string `json:"version"`     // such as "TLS 1.3"
+	CipherSuite string `json:"cipherSuite"` // such as "TLS_AES_128_GCM_SHA256"
+	ALPN        string `json:"alpn,omitempty"`
+	ServerName  string `json:"serverName,omitempty"`
+	Resumed     bool   `json:"resumed,omitempty"`
+}
+
+// HAR returns the archive of the exchanges recorded so far, ordered by
+// start time.
+func (r *Recorder) HAR() *HAR {
+	r.mu.Lock()
+	entries := slices.Clone(r.entries)
+	r.mu.Unlock()
+	slices.SortStableFunc(entries, func(a, b Entry) int {
+		return a.StartedDateTime.Compare(b.StartedDateTime)
+	})
+	if entries == nil {
+		entries = []Entry{}
+	}
+	return &HAR{Log: Log{
+		Version: "1.2",
+		Creator: Creator{Name: "dhttp", Version: creatorVersion()},
+		Entries: entries,
+	}}
+}
+
+// WriteHAR writes the archive of the exchanges recorded so far to w as
+// JSON.
+func (r *Recorder) WriteHAR(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r.HAR())
+}
+
+// Reset discards the exchanges recorded so far.
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	r.entries = nil
+	r.mu.Unlock()
+}
+
+var creatorVersion = sync.OnceValue(moduleVersion)
@@ -0,0 +1,468 @@
+package har
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"maps"
+	"net"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptrace"
+	tls "github.com/refraction-networking/utls"
+)
+
+// defaultMaxBodySize is the default of Recorder.MaxBodySize.
+const defaultMaxBodySize = 1 << 20
+
+// A Recorder is an http.RoundTripper that records the exchanges made
+// through it as HAR entries. Its zero value records exchanges made with
+// http.DefaultTransport. It is safe for concurrent use.
+//
+// An exchange is recorded once its response body has been read to the
+// end or closed, or when the request fails. Responses whose bodies are
+// never closed are not recorded.
+type Recorder struct {
+	// Transport sends the requests. If nil, http.DefaultTransport is
+	// used. The TLS fingerprint of an entry names the ClientHelloID only
+	// if Transport is a *http.Transport. DNS timings are only recorded
+	// with a *http.Transport whose TraceDNS is set; otherwise the
+	// connect timing includes the lookup.
+	Transport http.RoundTripper
+
+	// MaxBodySize is the number of bytes of each request and response
+	// body recorded as text. Longer bodies are truncated. Zero means
+	// 1 MiB; a negative value disables recording bodies. Body sizes are
+	// recorded regardless.
+	MaxBodySize int64
+
+	mu      sync.Mutex
+	entries []Entry
+}
+
+func (r *Recorder) transport() http.RoundTripper {
+	if r.Transport != nil {
+		return r.Transport
+	}
+	return http.DefaultTransport
+}
+
+func (r *Recorder) maxBodySize() int64 {
+	if r.MaxBodySize == 0 {
+		return defaultMaxBodySize
+	}
+	return max(r.MaxBodySize, 0)
+}
+
+// RoundTrip implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	x := &exchange{rec: r, req: req, start: time.Now()}
+	r2 := req.WithContext(httptrace.WithClientTrace(req.Context(), x.trace()))
+	if req.Body != nil && req.Body != http.NoBody {
+		r2.Body = &requestBody{ReadCloser: req.Body, x: x}
+	}
+
+	resp, err := r.transport().RoundTrip(r2)
+	if err != nil {
+		x.finish(nil, err)
+		return nil, err
+	}
+	if resp.Request == r2 {
+		resp.Request = req
+	}
+	x.mu.Lock()
+	x.resp = resp
+	x.mu.Unlock()
+	if resp.Body == nil || resp.Body == http.NoBody {
+		x.finish(resp, nil)
+	} else {
+		resp.Body = &responseBody{ReadCloser: resp.Body, x: x}
+	}
+	return resp, nil
+}
+
+// An exchange collects what a Recorder learns about one round trip.
+type exchange struct {
+	rec  *Recorder
+	req  *http.Request
+	once sync.Once
+
+	mu                  sync.Mutex
+	resp                *http.Response
+	start               time.Time
+	dnsStart, dnsDone   time.Time
+	connStart, connDone time.Time
+	tlsStart, tlsDone   time.Time
+	gotConn             time.Time
+	wroteRequest        time.Time
+	firstByte           time.Time
+	remoteAddr          string
+	localAddr           string
+	reqHeaders          []NameValue
+	respHeaders         []NameValue
+	reqBody, respBody   bytes.Buffer
+	reqSize, respSize   int64
+}
+
+func (x *exchange) trace() *httptrace.ClientTrace {
+	at := func(t *time.Time) {
+		x.mu.Lock()
+		*t = time.Now()
+		x.mu.Unlock()
+	}
+	first := func(t *time.Time) {
+		x.mu.Lock()
+		if t.IsZero() {
+			*t = time.Now()
+		}
+		x.mu.Unlock()
+	}
+	return &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { first(&x.dnsStart) },
+		DNSDone:           func(httptrace.DNSDoneInfo) { at(&x.dnsDone) },
+		ConnectStart:      func(string, string) { first(&x.connStart) },
+		ConnectDone:       func(string, string, error) { at(&x.connDone) },
+		TLSHandshakeStart: func() { first(&x.tlsStart) },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { at(&x.tlsDone) },
+		GotConn: func(info httptrace.GotConnInfo) {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			x.gotConn = time.Now()
+			x.remoteAddr = info.Conn.RemoteAddr().String()
+			x.localAddr = info.Conn.LocalAddr().String()
+			// A retried request is written again.
+			x.reqHeaders = nil
+		},
+		WroteHeaderField: func(key string, value []string) {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			for _, v := range value {
+				x.reqHeaders = append(x.reqHeaders, NameValue{key, v})
+			}
+		},
+		WroteRequest:         func(httptrace.WroteRequestInfo) { at(&x.wroteRequest) },
+		GotFirstResponseByte: func() { first(&x.firstByte) },
+		GotHeaderField: func(key string, value []string) {
+			x.mu.Lock()
+			defer x.mu.Unlock()
+			for _, v := range value {
+				x.respHeaders = append(x.respHeaders, NameValue{key, v})
+			}
+		},
+	}
+}
+
+// requestBody records a request body as the Transport reads it.
+type requestBody struct {
+	io.ReadCloser
+	x *exchange
+}
+
+func (b *requestBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.x.recordBody(&b.x.reqBody, &b.x.reqSize, p[:n])
+	return n, err
+}
+
+// responseBody records a response body as the caller reads it, and
+// finishes the exchange at its end.
+type responseBody struct {
+	io.ReadCloser
+	x *exchange
+}
+
+func (b *responseBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.x.recordBody(&b.x.respBody, &b.x.respSize, p[:n])
+	if err == io.EOF {
+		b.x.finish(b.x.resp, nil)
+	}
+	return n, err
+}
+
+func (b *responseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.x.finish(b.x.resp, nil)
+	return err
+}
+
+func (x *exchange) recordBody(buf *bytes.Buffer, size *int64, p []byte) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	*size += int64(len(p))
+	if room := x.rec.maxBodySize() - int64(buf.Len()); room > 0 {
+		buf.Write(p[:min(int64(len(p)), room)])
+	}
+}
+
+// finish records the exchange, once.
+func (x *exchange) finish(resp *http.Response, err error) {
+	x.once.Do(func() {
+		end := time.Now()
+		x.mu.Lock()
+		e := x.entry(resp, err, end)
+		x.mu.Unlock()
+		x.rec.mu.Lock()
+		x.rec.entries = append(x.rec.entries, e)
+		x.rec.mu.Unlock()
+	})
+}
+
+// entry builds the HAR entry of the exchange. x.mu must be held.
+func (x *exchange) entry(resp *http.Response, err error, end time.Time) Entry {
+	req := x.req
+	proto := req.Proto
+	if resp != nil {
+		proto = resp.Proto
+	}
+	if proto == "" {
+		proto = "HTTP/1.1"
+	}
+	e := Entry{
+		StartedDateTime: x.start,
+		Request: Request{
+			Method:      valueOr(req.Method, "GET"),
+			URL:         req.URL.String(),
+			HTTPVersion: proto,
+			Cookies:     []Cookie{},
+			Headers:     x.reqHeaders,
+			QueryString: queryString(req.URL.RawQuery),
+			HeadersSize: -1,
+			BodySize:    x.reqSize,
+		},
+		Response: Response{
+			Cookies:     []Cookie{},
+			Headers:     x.respHeaders,
+			HeadersSize: -1,
+			BodySize:    -1,
+		},
+		Timings: x.timings(end),
+	}
+	if e.Request.Headers == nil {
+		// Not sent by a dhttp Transport, or not sent at all.
+		e.Request.Headers = sortedHeaders(req.Header)
+	}
+	for _, h := range e.Request.Headers {
+		if strings.EqualFold(h.Name, "Cookie") {
+			for _, c := range parseCookie(h.Value) {
+				e.Request.Cookies = append(e.Request.Cookies, Cookie{Name: c.Name, Value: c.Value})
+			}
+		}
+	}
+	if x.reqSize > 0 {
+		e.Request.PostData = &PostData{MimeType: req.Header.Get("Content-Type")}
+		e.Request.PostData.Text, e.Request.PostData.Encoding = bodyText(x.reqBody.Bytes())
+	}
+	if host, _, err := net.SplitHostPort(x.remoteAddr); err == nil {
+		e.ServerIPAddress = host
+	}
+	if _, port, err := net.SplitHostPort(x.localAddr); err == nil {
+		e.Connection = port
+	}
+
+	if err != nil {
+		e.Error = err.Error()
+		e.Response.HTTPVersion = proto
+		e.Response.Headers = []NameValue{}
+		e.Time = totalTime(e.Timings)
+		return e
+	}
+
+	e.Response.Status = resp.StatusCode
+	e.Response.StatusText = strings.TrimPrefix(resp.Status, strconv.Itoa(resp.StatusCode)+" ")
+	e.Response.HTTPVersion = proto
+	if e.Response.Headers == nil {
+		e.Response.Headers = sortedHeaders(resp.Header)
+	}
+	for _, c := range resp.Cookies() {
+		e.Response.Cookies = append(e.Response.Cookies, harCookie(c))
+	}
+	e.Response.Content = Content{Size: x.respSize, MimeType: resp.Header.Get("Content-Type")}
+	e.Response.Content.Text, e.Response.Content.Encoding = bodyText(x.respBody.Bytes())
+	if !resp.Uncompressed {
+		e.Response.BodySize = x.respSize
+	}
+	e.Response.RedirectURL = resp.Header.Get("Location")
+	e.TLSFingerprint = x.tlsFingerprint(resp)
+	e.Time = totalTime(e.Timings)
+	return e
+}
+
+// timings returns the timings of the exchange, which ended at end.
+// x.mu must be held.
+func (x *exchange) timings(end time.Time) Timings {
+	ms := func(from, to time.Time) float64 {
+		if from.IsZero() || to.IsZero() {
+			return -1
+		}
+		return max(float64(to.Sub(from))/float64(time.Millisecond), 0)
+	}
+	t := Timings{
+		DNS:     ms(x.dnsStart, x.dnsDone),
+		Connect: ms(x.connStart, latest(x.connDone, x.tlsDone)),
+		SSL:     ms(x.tlsStart, x.tlsDone),
+		Send:    ms(x.gotConn, x.wroteRequest),
+		Wait:    ms(latest(x.gotConn, x.wroteRequest), x.firstByte),
+		Receive: ms(x.firstByte, end),
+	}
+	// Blocked is the time before a new connection was being set up or,
+	// for a reused one, was handed to the request.
+	setup := x.gotConn
+	for _, s := range []time.Time{x.connStart, x.dnsStart} {
+		if !s.IsZero() && (setup.IsZero() || s.Before(setup)) {
+			setup = s
+		}
+	}
+	t.Blocked = ms(x.start, setup)
+	return t
+}
+
+// totalTime returns the duration of an exchange: the sum of its phases,
+// with SSL counted as part of Connect.
+func totalTime(t Timings) float64 {
+	var total float64
+	for _, d := range []float64{t.Blocked, t.DNS, t.Connect, t.Send, t.Wait, t.Receive} {
+		if d > 0 {
+			total += d
+		}
+	}
+	return total
+}
+
+func latest(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// tlsFingerprint describes the TLS connection of resp, or returns nil for
+// a response not received over TLS.
+func (x *exchange) tlsFingerprint(resp *http.Response) *TLSFingerprint {
+	cs := resp.TLS
+	if cs == nil {
+		return nil
+	}
+	fp := &TLSFingerprint{
+		ClientHello: resp.Fingerprint,
+		Version:     tls.VersionName(cs.Version),
+		CipherSuite: tls.CipherSuiteName(cs.CipherSuite),
+		ALPN:        cs.NegotiatedProtocol,
+		ServerName:  cs.ServerName,
+		Resumed:     cs.DidResume,
+	}
+	if t, ok := x.rec.Transport.(*http.Transport); ok && fp.ClientHello == "" {
+		id := t.ClientHelloSettings.HelloID
+		if id.Client == "" {
+			id = tls.HelloChrome_Auto
+		}
+		fp.ClientHello = id.Str()
+	}
+	return fp
+}
+
+func harCookie(c *http.Cookie) Cookie {
+	hc := Cookie{
+		Name:     c.Name,
+		Value:    c.Value,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		HTTPOnly: c.HttpOnly,
+		Secure:   c.Secure,
+	}
+	if !c.Expires.IsZero() {
+		exp := c.Expires.UTC()
+		hc.Expires = &exp
+	}
+	switch c.SameSite {
+	case http.SameSiteLaxMode:
+		hc.SameSite = "Lax"
+	case http.SameSiteStrictMode:
+		hc.SameSite = "Strict"
+	case http.SameSiteNoneMode:
+		hc.SameSite = "None"
+	}
+	return hc
+}
+
+// parseCookie parses a Cookie header value, skipping malformed pairs.
+func parseCookie(line string) []*http.Cookie {
+	var cookies []*http.Cookie
+	for part := range strings.SplitSeq(line, ";") {
+		if c, err := http.ParseCookie(part); err == nil {
+			cookies = append(cookies, c...)
+		}
+	}
+	return cookies
+}
+
+// queryString returns the parameters of a raw query in order.
+func queryString(rawQuery string) []NameValue {
+	q := []NameValue{}
+	for part := range strings.SplitSeq(rawQuery, "&") {
+		if part == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(part, "=")
+		if uk, err := url.QueryUnescape(k); err == nil {
+			k = uk
+		}
+		if uv, err := url.QueryUnescape(v); err == nil {
+			v = uv
+		}
+		q = append(q, NameValue{k, v})
+	}
+	return q
+}
+
+// sortedHeaders returns h as HAR headers in sorted order, leaving out
+// dhttp's magic ordering keys.
+func sortedHeaders(h http.Header) []NameValue {
+	hs := []NameValue{}
+	for _, k := range slices.Sorted(maps.Keys(h)) {
+		if k == http.HeaderOrderKey || k == http.PHeaderOrderKey {
+			continue
+		}
+		for _, v := range h[k] {
+			hs = append(hs, NameValue{k, v})
+		}
+	}
+	return hs
+}
+
+// bodyText returns b as HAR text: as is if it is UTF-8, base64-encoded
+// otherwise.
+func bodyText(b []byte) (text, encoding string) {
+	if utf8.Valid(b) {
+		return string(b), ""
+	}
+	return base64.StdEncoding.EncodeToString(b), "base64"
+}
+
+func valueOr(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// moduleVersion returns the version of dhttp linked into the binary, or
+// "devel".
+func moduleVersion() string {
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, m := range append([]*debug.Module{&bi.Main}, bi.Deps...) {
+			if m.Path == "github.com/dteh/dhttp" && m.Version != "" && m.Version != "(devel)" {
+				return m.Version
+			}
+		}
+	}
+	return "devel"
+}
@@ -1,10 +1,12 @@
 package http_test
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"log"
 	"net"
+	"strings"
 
 	. "github.com/dteh/dhttp"
 	"github.com/dteh/dhttp/httptest"
@@ -142,3 +144,32 @@ func TestHeaderOrderHTTP2(t *testing.T) {
 		t.Errorf("Header keys not in expected order\ngot : %v\nwant: %v", hk, want)
 	}
 }
+
+func TestHeaderOrderHost(t *testing.T) {
+	// A header shared by requests to different hosts orders Host
+	// without keeping it.
+	h := Header{
+		"X-A":          {"1"},
+		"X-B":          {"2"},
+		"User-Agent":   {"ua"},
+		HeaderOrderKey: {"x-a", "host", "x-b", "user-agent"},
+	}
+	for _, host := range []string{"a.test", "b.test"} {
+		r, _ := NewRequest("GET", "http://"+host+"/", nil)
+		r.Header = h
+		var buf bytes.Buffer
+		if err := r.Write(&buf); err != nil {
+			t.Fatal(err)
+		}
+		want := "X-A: 1\r\nHost: " + host + "\r\nX-B: 2\r\nUser-Agent: ua\r\n"
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("request to %s:\n%s\nwant headers\n%s", host, buf.String(), want)
+		}
+		if strings.Count(buf.String(), "Host:") != 1 {
+			t.Errorf("request to %s has more than one Host:\n%s", host, buf.String())
+		}
+	}
+	if _, ok := h["Host"]; ok {
+		t.Errorf("Request.Write added Host to the request's Header: %v", h)
+	}
+}
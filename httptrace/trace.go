@@ -108,6 +108,12 @@ type ClientTrace struct {
 	// headers is available.
 	GotFirstResponseByte func()
 
+	// [dhttp] GotHeaderField is called for each header field of
+	// the final response, not of 1xx informational responses, in
+	// the order the server sent them and with the key as sent.
+	// HTTP/2 pseudo-header fields such as ":status" are included.
+	GotHeaderField func(key string, value []string)
+
 	// Got100Continue is called if the server replies with a "100
 	// Continue" response.
 	Got100Continue func()
@@ -146,6 +152,10 @@ type ClientTrace struct {
 	// failure.
 	TLSHandshakeDone func(tls.ConnectionState, error)
 
+	// [dhttp] TLSSessionResumption is called after a successful TLS
+	// handshake when the Transport has session resumption enabled.
+	TLSSessionResumption func(TLSSessionResumptionInfo)
+
 	// WroteHeaderField is called after the Transport has written
 	// each request header. At the time of this call the values
 	// might be buffered and not yet written to the network.
@@ -174,6 +184,20 @@ type WroteRequestInfo struct {
 	Err error
 }
 
+// TLSSessionResumptionInfo is the argument to the
+// [ClientTrace.TLSSessionResumption] function.
+type TLSSessionResumptionInfo struct {
+	// ServerName is the name the session was cached under.
+	ServerName string
+
+	// Offered is whether the ClientHello carried a cached session,
+	// either as a TLS 1.3 pre_shared_key or a TLS 1.2 session ticket.
+	Offered bool
+
+	// Resumed is whether the server accepted the offered session.
+	Resumed bool
+}
+
 // compose modifies t such that it respects the previously-registered hooks in old,
 // subject to the composition policy requested in t.Compose.
 func (t *ClientTrace) compose(old *ClientTrace) {
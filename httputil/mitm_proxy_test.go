@@ -0,0 +1,294 @@
+package httputil
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	http "github.com/dteh/dhttp"
+
+	"github.com/dteh/dhttp/httptest"
+	tls "github.com/refraction-networking/utls"
+)
+
+func newTestCA(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(leaf)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// mitmOrigin is an HTTP/1.1 TLS server recording the ClientHello
+// extensions and header order of the requests it answers.
+type mitmOrigin struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu         sync.Mutex
+	extensions []uint16
+	names      []string // header names of the last request, on the wire
+}
+
+func newMITMOrigin(t *testing.T, cert *tls.Certificate) *mitmOrigin {
+	o := &mitmOrigin{}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	o.ln = tls.NewListener(ln, &tls.Config{
+		Certificates: []tls.Certificate{*cert},
+		NextProtos:   []string{"http/1.1"},
+		GetConfigForClient: func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			o.mu.Lock()
+			o.extensions = h.Extensions
+			o.mu.Unlock()
+			return nil, nil
+		},
+	})
+	o.wg.Add(1)
+	go func() {
+		defer o.wg.Done()
+		for {
+			c, err := o.ln.Accept()
+			if err != nil {
+				return
+			}
+			o.wg.Add(1)
+			go func() {
+				defer o.wg.Done()
+				defer c.Close()
+				o.serve(c)
+			}()
+		}
+	}()
+	return o
+}
+
+func (o *mitmOrigin) serve(c net.Conn) {
+	br := bufio.NewReader(c)
+	for {
+		if _, err := br.ReadString('\n'); err != nil { // request line
+			return
+		}
+		var names, hooked []string
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+			name, value, _ := strings.Cut(line, ":")
+			names = append(names, strings.ToLower(name))
+			if strings.EqualFold(name, "X-Hooked") {
+				hooked = append(hooked, strings.TrimSpace(value))
+			}
+		}
+		o.mu.Lock()
+		o.names = names
+		o.mu.Unlock()
+		body := "origin " + strings.Join(hooked, ",")
+		fmt.Fprintf(c, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\n\r\n%s", len(body), body)
+	}
+}
+
+func (o *mitmOrigin) Close() {
+	o.ln.Close()
+	o.wg.Wait()
+}
+
+func TestMITMProxy(t *testing.T) {
+	// Firefox sends record_size_limit (28); the proxy's own parrot does not.
+	const recordSizeLimit = 28
+
+	for _, tt := range []struct {
+		name string
+		h2   bool
+	}{
+		{"h1", false},
+		{"h2", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			ca, pool := newTestCA(t)
+			mitm := &MITMProxy{
+				CA:                ca,
+				Transport:         &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+				MirrorClientHello: true,
+				MirrorHeaderOrder: true,
+				ErrorLog:          log.New(io.Discard, "", 0),
+				OnRequest: func(r *http.Request) *http.Response {
+					if r.URL.Path == "/short" {
+						return &http.Response{StatusCode: http.StatusTeapot, Header: make(http.Header)}
+					}
+					r.Header.Set("X-Hooked", r.Header.Get("X-A"))
+					return nil
+				},
+				OnResponse: func(resp *http.Response) error {
+					resp.Header.Set("X-Seen", "1")
+					return nil
+				},
+			}
+			if err := mitm.initCerts(); err != nil {
+				t.Fatal(err)
+			}
+			cert, err := mitm.cert("127.0.0.1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			origin := newMITMOrigin(t, cert)
+			defer origin.Close()
+			defer mitm.Transport.CloseIdleConnections()
+
+			proxy := httptest.NewServer(mitm)
+			defer proxy.Close()
+			proxyURL, _ := url.Parse(proxy.URL)
+
+			tr := &http.Transport{
+				Proxy:               http.ProxyURL(proxyURL),
+				TLSClientConfig:     &tls.Config{RootCAs: pool},
+				ClientHelloSettings: http.ClientHelloSettings{HelloID: tls.HelloFirefox_120},
+			}
+			if tt.h2 {
+				tr.ForceAttemptHTTP2 = true
+			} else {
+				tr.TLSNextProto = map[string]func(string, *tls.UConn) http.RoundTripper{}
+			}
+			defer tr.CloseIdleConnections()
+
+			req, _ := http.NewRequest("GET", "https://"+origin.ln.Addr().String()+"/", nil)
+			req.Header = http.Header{
+				"X-A":               {"a"},
+				"X-B":               {"b"},
+				"User-Agent":        {"agent"},
+				http.HeaderOrderKey: {"x-b", "user-agent", "x-a"},
+			}
+			resp, err := tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			b, _ := io.ReadAll(resp.Body)
+			resp.Body.Close()
+			if string(b) != "origin a" || resp.Header.Get("X-Seen") != "1" {
+				t.Errorf("got %q with header %v, want origin a with X-Seen", b, resp.Header)
+			}
+			if tt.h2 != (resp.ProtoMajor == 2) {
+				t.Errorf("client talked %s to the proxy", resp.Proto)
+			}
+
+			origin.mu.Lock()
+			if !slices.Contains(origin.extensions, recordSizeLimit) {
+				t.Errorf("origin saw extensions %v, want the client's Firefox ClientHello", origin.extensions)
+			}
+			xb, ua, xa := slices.Index(origin.names, "x-b"), slices.Index(origin.names, "user-agent"), slices.Index(origin.names, "x-a")
+			if xb < 0 || !(xb < ua && ua < xa) {
+				t.Errorf("origin saw header order %q, want x-b, user-agent, x-a", origin.names)
+			}
+			origin.mu.Unlock()
+
+			req, _ = http.NewRequest("GET", "https://"+origin.ln.Addr().String()+"/short", nil)
+			resp, err = tr.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusTeapot {
+				t.Errorf("OnRequest response: got %s, want 418", resp.Status)
+			}
+		})
+	}
+}
+
+func TestMITMProxyResponseHookError(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+
+	proxy := httptest.NewServer(&MITMProxy{
+		OnResponse: func(*http.Response) error { return errors.New("refused") },
+		ErrorLog:   log.New(io.Discard, "", 0),
+	})
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+
+	tr := &http.Transport{Proxy: http.ProxyURL(proxyURL)}
+	defer tr.CloseIdleConnections()
+	resp, err := (&http.Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("status %s, want 502", resp.Status)
+	}
+}
+
+func TestMITMProxyCerts(t *testing.T) {
+	ca, pool := newTestCA(t)
+	mitm := &MITMProxy{CA: ca, MaxCerts: 2, ErrorLog: log.New(io.Discard, "", 0)}
+	if err := mitm.initCerts(); err != nil {
+		t.Fatal(err)
+	}
+	first, _ := mitm.cert("a.test")
+	mitm.cert("b.test")
+	if again, _ := mitm.cert("a.test"); again != first {
+		t.Error("a.test was minted again while cached")
+	}
+	mitm.cert("c.test") // pushes out b.test, the least recently used
+	if _, ok := mitm.certs["b.test"]; ok || len(mitm.certs) != 2 || mitm.certLRU.Len() != 2 {
+		t.Errorf("cached %d certificates with b.test %v, want a.test and c.test", len(mitm.certs), ok)
+	}
+
+	// A client whose SNI is not the CONNECT target is refused.
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer origin.Close()
+	proxy := httptest.NewServer(mitm)
+	defer proxy.Close()
+	proxyURL, _ := url.Parse(proxy.URL)
+	tr := &http.Transport{
+		Proxy:           http.ProxyURL(proxyURL),
+		TLSClientConfig: &tls.Config{RootCAs: pool, ServerName: "other.test"},
+	}
+	defer tr.CloseIdleConnections()
+	req, _ := http.NewRequest("GET", "https://"+origin.Listener.Addr().String()+"/", nil)
+	if resp, err := tr.RoundTrip(req); err == nil {
+		resp.Body.Close()
+		t.Error("request with a mismatched SNI succeeded")
+	}
+	if _, ok := mitm.certs["other.test"]; ok {
+		t.Error("a certificate was minted for the client's SNI")
+	}
+}
@@ -194,6 +194,9 @@ type Request struct {
 	Header              map[string][]string
 	Trailer             map[string][]string
 	ActualContentLength int64 // 0 means 0, -1 means unknown
+
+	// dhttp: RawRequestTarget, if non-empty, is sent as :path verbatim.
+	RawRequestTarget string
 }
 
 // EncodeHeadersParam is parameters to EncodeHeaders.
@@ -260,7 +263,17 @@ func EncodeHeaders(ctx context.Context, param EncodeHeadersParam, headerf func(n
 
 	// Validate the path, except for non-extended CONNECT requests which have no path.
 	var path string
-	if !isNormalConnect {
+	if req.RawRequestTarget != "" {
+		// dhttp: send the raw target as is, rejecting only the bytes
+		// that cannot appear in a field value.
+		if isNormalConnect {
+			return res, errors.New("RawRequestTarget cannot be sent with a CONNECT request")
+		}
+		if strings.ContainsAny(req.RawRequestTarget, "\x00\r\n") {
+			return res, fmt.Errorf("invalid request :path %q from RawRequestTarget", req.RawRequestTarget)
+		}
+		path = req.RawRequestTarget
+	} else if !isNormalConnect {
 		path = req.URL.RequestURI()
 		if !validPseudoPath(path) {
 			orig := path
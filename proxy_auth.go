@@ -0,0 +1,321 @@
+package http
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// A ProxyAuthenticator authenticates requests with proxies, answering
+// their 407 (Proxy Authentication Required) challenges. It is set as
+// Transport.ProxyAuthenticator and used for CONNECT requests, including
+// those to the hops of a proxy chain, and for requests forwarded by
+// "http" and "https" proxies.
+//
+// Connection-oriented schemes such as NTLM and Negotiate work on CONNECT
+// requests, whose challenges are answered on the same connection as long
+// as the proxy keeps it open. Forwarded requests are sent again as new
+// requests, usually on the same connection, which is enough for
+// request-oriented schemes such as Digest.
+type ProxyAuthenticator interface {
+	// ProxyAuthorization returns the Proxy-Authorization header value
+	// for req, sent to the proxy proxyURL. req is the CONNECT request or
+	// the forwarded request, and must not be modified. challenge is nil
+	// before req is first sent, and the proxy's 407 response to the
+	// previous attempt otherwise; its body must not be read.
+	//
+	// An empty value sends req without Proxy-Authorization before the
+	// first attempt, and gives up on a challenge, returning the 407
+	// response. An error aborts the request.
+	ProxyAuthorization(ctx context.Context, proxyURL *url.URL, req *Request, challenge *Response) (string, error)
+}
+
+// maxProxyAuthRounds bounds the challenges answered for one request.
+// NTLM takes two.
+const maxProxyAuthRounds = 4
+
+// proxyAuthState carries the answer to a forwarding proxy's challenge
+// into the next attempt at a request.
+type proxyAuthState struct {
+	proxyURL   *url.URL // the forwarding proxy of the last attempt, or nil
+	challenged bool
+	auth       string
+}
+
+type proxyAuthContextKey struct{}
+
+// roundTripProxyAuth sends req with roundTrip, sending it again while a
+// forwarding proxy challenges it and Transport.ProxyAuthenticator
+// answers. Each attempt runs with its own transport request context,
+// since reading a response body ends it.
+func (t *Transport) roundTripProxyAuth(req *Request) (*Response, error) {
+	st := &proxyAuthState{}
+	r := req.WithContext(context.WithValue(req.Context(), proxyAuthContextKey{}, st))
+	for round := 0; ; round++ {
+		resp, err := t.roundTrip(r)
+		if err != nil {
+			return nil, err
+		}
+		resp.Request = req
+		if resp.StatusCode != StatusProxyAuthRequired || st.proxyURL == nil || round == maxProxyAuthRounds {
+			return resp, nil
+		}
+		if req.Body != nil && req.Body != NoBody && req.GetBody == nil {
+			return resp, nil
+		}
+		auth, err := t.ProxyAuthenticator.ProxyAuthorization(req.Context(), st.proxyURL, req, resp)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		if auth == "" {
+			return resp, nil
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxPostHandlerReadBytes))
+		resp.Body.Close()
+
+		r = r.Clone(r.Context())
+		if req.Body != nil && req.Body != NoBody {
+			if r.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+		*st = proxyAuthState{challenged: true, auth: auth}
+	}
+}
+
+// addProxyAuthorization sets the Proxy-Authorization header of treq
+// from Transport.ProxyAuthenticator, if cm sends it to a forwarding
+// proxy.
+func (t *Transport) addProxyAuthorization(treq *transportRequest, cm *connectMethod) error {
+	st, _ := treq.ctx.Value(proxyAuthContextKey{}).(*proxyAuthState)
+	if st == nil || cm.proxyURL == nil || cm.targetScheme != "http" || isSOCKSProxy(cm.proxyURL.Scheme) {
+		return nil
+	}
+	st.proxyURL = cm.proxyURL
+	auth := st.auth
+	if !st.challenged {
+		var err error
+		if auth, err = t.ProxyAuthenticator.ProxyAuthorization(treq.ctx, cm.proxyURL, treq.Request, nil); err != nil {
+			return err
+		}
+	}
+	if auth != "" {
+		treq.extraHeaders().Set("Proxy-Authorization", auth)
+	}
+	return nil
+}
+
+// proxyCredentials returns username and password, or if username is
+// empty, the userinfo of proxyURL.
+func proxyCredentials(proxyURL *url.URL, username, password string) (string, string) {
+	if username == "" && proxyURL.User != nil {
+		username = proxyURL.User.Username()
+		password, _ = proxyURL.User.Password()
+	}
+	return username, password
+}
+
+// BasicProxyAuth is a [ProxyAuthenticator] sending Basic credentials
+// with every request, as the Transport does by default with the proxy
+// URL's userinfo. It gives up when challenged.
+type BasicProxyAuth struct {
+	// Username and Password are the credentials. If Username is empty,
+	// the proxy URL's userinfo is used.
+	Username, Password string
+}
+
+// ProxyAuthorization implements [ProxyAuthenticator].
+func (a *BasicProxyAuth) ProxyAuthorization(ctx context.Context, proxyURL *url.URL, req *Request, challenge *Response) (string, error) {
+	username, password := proxyCredentials(proxyURL, a.Username, a.Password)
+	if challenge != nil || username == "" {
+		return "", nil
+	}
+	return "Basic " + basicAuth(username, password), nil
+}
+
+// DigestProxyAuth is a [ProxyAuthenticator] for Digest access
+// authentication (RFC 7616), with the MD5, SHA-256 and SHA-512-256
+// algorithms and their session variants. Once a proxy has challenged a
+// request, later requests to it are authenticated upfront with the same
+// nonce, until the proxy reports it stale.
+//
+// A DigestProxyAuth must not be copied after first use.
+type DigestProxyAuth struct {
+	// Username and Password are the credentials. If Username is empty,
+	// the proxy URL's userinfo is used.
+	Username, Password string
+
+	mu     sync.Mutex
+	states map[string]*digestState // by proxy URL
+}
+
+// digestState is the last Digest challenge of a proxy.
+type digestState struct {
+	params map[string]string
+	nc     int
+}
+
+// ProxyAuthorization implements [ProxyAuthenticator].
+func (a *DigestProxyAuth) ProxyAuthorization(ctx context.Context, proxyURL *url.URL, req *Request, challenge *Response) (string, error) {
+	username, password := proxyCredentials(proxyURL, a.Username, a.Password)
+	if username == "" {
+		return "", nil
+	}
+	key := proxyURL.String()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	st := a.states[key]
+	if challenge != nil {
+		params := digestChallenge(challenge.Header["Proxy-Authenticate"])
+		if params == nil {
+			return "", nil
+		}
+		// A fresh challenge to a request answered with the same nonce
+		// means the credentials were wrong.
+		if st != nil && st.params["nonce"] == params["nonce"] && !strings.EqualFold(params["stale"], "true") {
+			delete(a.states, key)
+			return "", nil
+		}
+		st = &digestState{params: params}
+		if a.states == nil {
+			a.states = make(map[string]*digestState)
+		}
+		a.states[key] = st
+	}
+	if st == nil {
+		return "", nil
+	}
+
+	uri := req.URL.String()
+	if req.Method == "CONNECT" {
+		uri = req.Host
+	}
+	return st.authorization(username, password, req.Method, uri)
+}
+
+// authorization returns the Authorization value answering st for a
+// request of method to uri.
+func (st *digestState) authorization(username, password, method, uri string) (string, error) {
+	p := st.params
+	algorithm := p["algorithm"]
+	if algorithm == "" {
+		algorithm = "MD5"
+	}
+	base, sess := strings.CutSuffix(strings.ToUpper(algorithm), "-SESS")
+	var newHash func() hash.Hash
+	switch base {
+	case "MD5":
+		newHash = md5.New
+	case "SHA-256":
+		newHash = sha256.New
+	case "SHA-512-256":
+		newHash = sha512.New512_256
+	default:
+		return "", fmt.Errorf("net/http: unsupported Digest algorithm %q", algorithm)
+	}
+	h := func(s string) string {
+		d := newHash()
+		io.WriteString(d, s)
+		return hex.EncodeToString(d.Sum(nil))
+	}
+
+	cnonce := rand.Text()
+	ha1 := h(username + ":" + p["realm"] + ":" + password)
+	if sess {
+		ha1 = h(ha1 + ":" + p["nonce"] + ":" + cnonce)
+	}
+	ha2 := h(method + ":" + uri)
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `Digest username=%q, realm=%q, nonce=%q, uri=%q, algorithm=%s`, username, p["realm"], p["nonce"], uri, algorithm)
+	if qop := p["qop"]; qop != "" {
+		if !digestOffersAuth(qop) {
+			return "", fmt.Errorf("net/http: unsupported Digest qop %q", qop)
+		}
+		st.nc++
+		nc := fmt.Sprintf("%08x", st.nc)
+		fmt.Fprintf(&b, `, response=%q, qop=auth, nc=%s, cnonce=%q`, h(ha1+":"+p["nonce"]+":"+nc+":"+cnonce+":auth:"+ha2), nc, cnonce)
+	} else {
+		fmt.Fprintf(&b, `, response=%q`, h(ha1+":"+p["nonce"]+":"+ha2))
+	}
+	if opaque, ok := p["opaque"]; ok {
+		fmt.Fprintf(&b, `, opaque=%q`, opaque)
+	}
+	return b.String(), nil
+}
+
+// digestOffersAuth reports whether the qop list of a challenge includes
+// "auth".
+func digestOffersAuth(qop string) bool {
+	for o := range strings.SplitSeq(qop, ",") {
+		if strings.EqualFold(strings.TrimSpace(o), "auth") {
+			return true
+		}
+	}
+	return false
+}
+
+// digestChallenge returns the parameters of the Digest challenge among
+// challenges, the Proxy-Authenticate values of a response, preferring
+// the strongest algorithm. It returns nil if there is none.
+func digestChallenge(challenges []string) map[string]string {
+	rank := map[string]int{"": 1, "MD5": 1, "MD5-SESS": 1, "SHA-256": 2, "SHA-256-SESS": 2, "SHA-512-256": 3, "SHA-512-256-SESS": 3}
+	var best map[string]string
+	for _, c := range challenges {
+		scheme, rest, _ := strings.Cut(strings.TrimSpace(c), " ")
+		if !strings.EqualFold(scheme, "Digest") {
+			continue
+		}
+		params := parseAuthParams(rest)
+		if params["nonce"] == "" || rank[strings.ToUpper(params["algorithm"])] == 0 {
+			continue
+		}
+		if best == nil || rank[strings.ToUpper(params["algorithm"])] > rank[strings.ToUpper(best["algorithm"])] {
+			best = params
+		}
+	}
+	return best
+}
+
+// parseAuthParams parses the comma-separated name=value parameters of
+// an authentication challenge, whose values may be quoted strings.
+// Names are lower-cased.
+func parseAuthParams(s string) map[string]string {
+	params := make(map[string]string)
+	for {
+		s = strings.TrimLeft(s, " \t,")
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return params
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		rest = strings.TrimLeft(rest, " \t")
+		var value strings.Builder
+		if strings.HasPrefix(rest, `"`) {
+			i := 1
+			for ; i < len(rest) && rest[i] != '"'; i++ {
+				if rest[i] == '\\' && i+1 < len(rest) {
+					i++
+				}
+				value.WriteByte(rest[i])
+			}
+			s = rest[min(i+1, len(rest)):]
+		} else {
+			v, after, _ := strings.Cut(rest, ",")
+			value.WriteString(strings.TrimSpace(v))
+			s = after
+		}
+		params[name] = value.String()
+	}
+}
@@ -0,0 +1,253 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+)
+
+// digestParams parses the parameters of a Digest credentials value as
+// DigestProxyAuth writes them.
+func digestParams(v string) map[string]string {
+	params := make(map[string]string)
+	v, _ = strings.CutPrefix(v, "Digest ")
+	for p := range strings.SplitSeq(v, ", ") {
+		name, value, _ := strings.Cut(p, "=")
+		params[name] = strings.Trim(value, `"`)
+	}
+	return params
+}
+
+func md5Hex(s string) string {
+	h := md5.Sum([]byte(s))
+	return hex.EncodeToString(h[:])
+}
+
+func TestDigestProxyAuthForwarding(t *testing.T) {
+	const nonce = "n0nce"
+	var (
+		mu         sync.Mutex
+		challenges int
+	)
+	proxy := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		p := digestParams(r.Header.Get("Proxy-Authorization"))
+		ha1 := md5Hex("user:proxy:secret")
+		ha2 := md5Hex(r.Method + ":" + p["uri"])
+		want := md5Hex(ha1 + ":" + nonce + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+		if p["nonce"] != nonce || p["uri"] != r.RequestURI || p["response"] != want || p["opaque"] != "op" {
+			mu.Lock()
+			challenges++
+			mu.Unlock()
+			w.Header().Set("Proxy-Authenticate", `Digest realm="proxy", nonce="`+nonce+`", qop="auth,auth-int", opaque="op"`)
+			w.WriteHeader(StatusProxyAuthRequired)
+			io.WriteString(w, "denied")
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%s %s", r.URL, b)
+	}))
+	defer proxy.Close()
+
+	proxyURL, _ := url.Parse(proxy.URL)
+	proxyURL.User = url.UserPassword("user", "secret")
+	tr := &Transport{Proxy: ProxyURL(proxyURL), ProxyAuthenticator: &DigestProxyAuth{}}
+	defer tr.CloseIdleConnections()
+	c := &Client{Transport: tr}
+
+	for i, body := range []string{"one", "two"} {
+		resp, err := c.Post("http://example.test/p", "text/plain", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if want := "http://example.test/p " + body; resp.StatusCode != StatusOK || string(b) != want {
+			t.Fatalf("request %d: %s %q, want 200 %q", i, resp.Status, b, want)
+		}
+	}
+	// The second request is authenticated upfront.
+	mu.Lock()
+	if challenges != 1 {
+		t.Errorf("proxy sent %d challenges, want 1", challenges)
+	}
+	mu.Unlock()
+
+	tr.ProxyAuthenticator = &DigestProxyAuth{Username: "user", Password: "wrong"}
+	resp, err := c.Get("http://example.test/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != StatusProxyAuthRequired {
+		t.Errorf("wrong password: status %s, want 407", resp.Status)
+	}
+}
+
+// twoLegAuth answers like a connection-oriented scheme such as NTLM: a
+// negotiate message, then a response to the proxy's challenge.
+type twoLegAuth struct{}
+
+func (twoLegAuth) ProxyAuthorization(ctx context.Context, proxyURL *url.URL, req *Request, challenge *Response) (string, error) {
+	if challenge == nil {
+		return "", nil
+	}
+	switch v := challenge.Header.Get("Proxy-Authenticate"); {
+	case v == "Leg":
+		return "Leg negotiate", nil
+	case strings.HasPrefix(v, "Leg "):
+		return "Leg answer " + strings.TrimPrefix(v, "Leg "), nil
+	}
+	return "", nil
+}
+
+// twoLegProxy is a CONNECT proxy that authenticates with twoLegAuth,
+// padding its challenges' bodies with pad bytes.
+type twoLegProxy struct {
+	ln net.Listener
+	wg sync.WaitGroup
+
+	mu    sync.Mutex
+	conns int
+	auths []string
+}
+
+func newTwoLegProxy(t *testing.T, pad int) *twoLegProxy {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &twoLegProxy{ln: ln}
+	body := "denied" + strings.Repeat(" ", pad)
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			p.mu.Lock()
+			p.conns++
+			p.mu.Unlock()
+			br := bufio.NewReader(c)
+			for {
+				req, err := ReadRequest(br)
+				if err != nil {
+					c.Close()
+					break
+				}
+				auth := req.Header.Get("Proxy-Authorization")
+				p.mu.Lock()
+				p.auths = append(p.auths, auth)
+				p.mu.Unlock()
+				challenge := ""
+				switch auth {
+				case "":
+					challenge = "Leg"
+				case "Leg negotiate":
+					challenge = "Leg c1"
+				case "Leg answer c1":
+					backend, err := net.Dial("tcp", req.Host)
+					if err != nil {
+						c.Close()
+						break
+					}
+					io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
+					tunnel(&p.wg, c, backend)
+				default:
+					c.Close()
+				}
+				if challenge == "" {
+					break
+				}
+				fmt.Fprintf(c, "HTTP/1.1 407 Proxy Authentication Required\r\nProxy-Authenticate: %s\r\nContent-Length: %d\r\n\r\n%s", challenge, len(body), body)
+			}
+		}
+	}()
+	t.Cleanup(func() {
+		ln.Close()
+		p.wg.Wait()
+	})
+	return p
+}
+
+func TestProxyAuthenticatorConnect(t *testing.T) {
+	origin := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+	p := newTwoLegProxy(t, 0)
+
+	tr := origin.Client().Transport.(*Transport)
+	tr.Proxy = ProxyURL(&url.URL{Scheme: "http", Host: p.ln.Addr().String()})
+	tr.ProxyAuthenticator = twoLegAuth{}
+	resp, err := (&Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	tr.CloseIdleConnections()
+
+	if string(b) != "origin" {
+		t.Errorf("body = %q, want origin", b)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if want := []string{"", "Leg negotiate", "Leg answer c1"}; p.conns != 1 || strings.Join(p.auths, "|") != strings.Join(want, "|") {
+		t.Errorf("proxy saw %q on %d connections, want %q on 1", p.auths, p.conns, want)
+	}
+}
+
+// ctxAuth is a twoLegAuth recording the contexts it is called with.
+type ctxAuth struct {
+	twoLegAuth
+	ctxs []context.Context
+}
+
+func (a *ctxAuth) ProxyAuthorization(ctx context.Context, proxyURL *url.URL, req *Request, challenge *Response) (string, error) {
+	a.ctxs = append(a.ctxs, ctx)
+	return a.twoLegAuth.ProxyAuthorization(ctx, proxyURL, req, challenge)
+}
+
+func TestProxyAuthenticatorConnectLimit(t *testing.T) {
+	origin := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+	// Each challenge fits the limit; together they do not.
+	const limit = 4 << 10
+	p := newTwoLegProxy(t, limit*3/4)
+
+	auth := &ctxAuth{}
+	tr := origin.Client().Transport.(*Transport)
+	tr.Proxy = ProxyURL(&url.URL{Scheme: "http", Host: p.ln.Addr().String()})
+	tr.ProxyAuthenticator = auth
+	tr.MaxResponseHeaderBytes = limit
+	resp, err := (&Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tr.CloseIdleConnections()
+
+	if len(auth.ctxs) != 3 {
+		t.Fatalf("ProxyAuthorization called %d times, want 3", len(auth.ctxs))
+	}
+	for i, ctx := range auth.ctxs[1:] {
+		if ctx != auth.ctxs[0] {
+			t.Errorf("round %d: ProxyAuthorization got another context than the first round", i+1)
+		}
+	}
+}
@@ -0,0 +1,426 @@
+package http
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ProxySelection selects how a [ProxyPool] chooses among its healthy
+// proxies.
+type ProxySelection int
+
+const (
+	// RoundRobin uses the proxies in turn.
+	RoundRobin ProxySelection = iota
+
+	// Weighted chooses a proxy at random, in proportion to its Weight.
+	Weighted
+
+	// LeastErrors chooses the proxy with the fewest failures so far,
+	// using the proxies in turn among equals.
+	LeastErrors
+)
+
+// A PooledProxy is one proxy of a [ProxyPool].
+type PooledProxy struct {
+	// URL is the proxy, as Transport.Proxy would return it.
+	URL *url.URL
+
+	// Weight is the relative probability of choosing this proxy with
+	// Weighted selection. Zero or negative weights count as 1.
+	Weight int
+}
+
+// A ProxyPool spreads requests over several proxies, keeping track of
+// their health. It plugs into a Transport in two places, and wraps it
+// to see which requests fail:
+//
+//	pool := &http.ProxyPool{Proxies: proxies, Transport: tr}
+//	tr.Proxy = pool.Proxy
+//	tr.OnProxyConnectResponse = pool.OnProxyConnectResponse
+//	client := &http.Client{Transport: pool}
+//
+// A proxy fails when dialing it, its SOCKS handshake or its CONNECT
+// response fails. After MaxFailures consecutive failures, it is left out
+// for Cooldown, then tried again. Requests sent through the pool's
+// RoundTrip that fail because of their proxy are retried on another one
+// when they are idempotent, as the Transport retries requests on a new
+// connection; requests for which only Proxy is called just move on to
+// another proxy next time.
+//
+// Requests whose context carries a session, set with WithProxySession,
+// keep using the proxy first chosen for that session until that proxy
+// starts a cool-down, or until the pool forgets the session; see
+// MaxSessions.
+//
+// A ProxyPool must not be modified or copied after first use.
+type ProxyPool struct {
+	// Proxies is the set to choose from.
+	Proxies []PooledProxy
+
+	// Selection chooses among the healthy proxies.
+	Selection ProxySelection
+
+	// MaxFailures is how many consecutive failures start a proxy's
+	// cool-down. If zero, 3 is used.
+	MaxFailures int
+
+	// Cooldown is how long a failing proxy is left out. If zero,
+	// 30 seconds is used. While every proxy is cooling down, the one
+	// whose cool-down ends first is used.
+	Cooldown time.Duration
+
+	// MaxRetries limits how many other proxies RoundTrip tries an
+	// idempotent request on after a proxy failure. If zero, every other
+	// proxy may be tried; if negative, requests are not retried.
+	MaxRetries int
+
+	// Transport sends the requests given to RoundTrip. Its Proxy must
+	// be the pool's Proxy method. If nil, DefaultTransport is used.
+	Transport RoundTripper
+
+	// MaxSessions bounds the number of sessions the pool remembers a
+	// proxy for. Beyond it, the least recently used session is
+	// forgotten, and its next request chooses a proxy anew. Zero means
+	// 4096.
+	MaxSessions int
+
+	initOnce sync.Once
+	initErr  error
+
+	mu       sync.Mutex
+	state    []proxyState // parallel to Proxies
+	weight   int
+	next     int                   // round-robin position
+	sessions map[any]*list.Element // of *proxySessionEntry, in lru
+	lru      list.List             // most recently used at the front
+}
+
+// defaultMaxProxySessions is the default ProxyPool.MaxSessions.
+const defaultMaxProxySessions = 4096
+
+// proxySessionEntry is the proxy a session of a ProxyPool sticks to.
+type proxySessionEntry struct {
+	session any // in ProxyPool.sessions
+	index   int // into Proxies
+}
+
+// proxyState is the health of one proxy of a ProxyPool.
+type proxyState struct {
+	successes   int
+	failures    int
+	consecutive int
+	coolUntil   time.Time
+}
+
+// ProxyStats reports the health of one proxy of a [ProxyPool].
+type ProxyStats struct {
+	URL       *url.URL
+	Successes int
+	Failures  int
+
+	// CoolingDownUntil is when the proxy's cool-down ends, or the zero
+	// Time if it is not cooling down.
+	CoolingDownUntil time.Time
+}
+
+func (p *ProxyPool) init() error {
+	p.initOnce.Do(func() {
+		if len(p.Proxies) == 0 {
+			p.initErr = errors.New("http: ProxyPool has no proxies")
+			return
+		}
+		for _, px := range p.Proxies {
+			if px.URL == nil {
+				p.initErr = errors.New("http: ProxyPool has a proxy with a nil URL")
+				return
+			}
+			p.weight += max(px.Weight, 1)
+		}
+		p.state = make([]proxyState, len(p.Proxies))
+		p.sessions = make(map[any]*list.Element)
+	})
+	return p.initErr
+}
+
+// Proxy returns the proxy for req. It is meant to be used as
+// Transport.Proxy.
+func (p *ProxyPool) Proxy(req *Request) (*url.URL, error) {
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+	if c, ok := req.Context().Value(proxyPoolContextKey{}).(proxyPoolChoice); ok && c.pool == p {
+		return p.Proxies[c.index].URL, nil
+	}
+	return p.Proxies[p.pick(proxySession(req.Context()), nil)].URL, nil
+}
+
+// OnProxyConnectResponse records a CONNECT response other than 200 as a
+// failure of proxyURL. It is meant to be used as
+// Transport.OnProxyConnectResponse; a function set there instead should
+// call it.
+func (p *ProxyPool) OnProxyConnectResponse(ctx context.Context, proxyURL *url.URL, connectReq *Request, connectRes *Response) error {
+	if connectRes.StatusCode == 200 {
+		return nil
+	}
+	if p.init() == nil {
+		for i, px := range p.Proxies {
+			if px.URL.String() == proxyURL.String() {
+				p.failed(i)
+				break
+			}
+		}
+	}
+	// The Transport would fail the dial with the same message; the
+	// type lets RoundTrip tell it is the proxy's fault.
+	_, text, ok := strings.Cut(connectRes.Status, " ")
+	if !ok {
+		text = "unknown status code"
+	}
+	return proxyConnectError(text)
+}
+
+// RoundTrip sends req with p.Transport through a proxy of the pool,
+// retrying idempotent requests on another proxy when their proxy fails.
+func (p *ProxyPool) RoundTrip(req *Request) (*Response, error) {
+	if err := p.init(); err != nil {
+		req.closeBody()
+		return nil, err
+	}
+	rt := p.Transport
+	if rt == nil {
+		rt = DefaultTransport
+	}
+	retries := p.MaxRetries
+	if retries == 0 {
+		retries = len(p.Proxies) - 1
+	}
+
+	session := proxySession(req.Context())
+	tried := make(map[int]bool)
+	for {
+		i := p.pick(session, tried)
+		r := req.WithContext(context.WithValue(req.Context(), proxyPoolContextKey{}, proxyPoolChoice{p, i}))
+		if len(tried) > 0 && req.Body != nil && req.Body != NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			r.Body = body
+		}
+		resp, err := rt.RoundTrip(r)
+		if err == nil {
+			p.succeeded(i)
+			return resp, nil
+		}
+		if !isProxyFailure(err) {
+			return nil, err
+		}
+		if _, ok := err.(proxyConnectError); !ok {
+			// OnProxyConnectResponse has counted CONNECT failures.
+			p.failed(i)
+		}
+		tried[i] = true
+		if len(tried) > retries || len(tried) == len(p.Proxies) || !req.isReplayable() {
+			return nil, err
+		}
+	}
+}
+
+// Stats returns the health of the pool's proxies, in the order of
+// Proxies.
+func (p *ProxyPool) Stats() []ProxyStats {
+	if p.init() != nil {
+		return nil
+	}
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	stats := make([]ProxyStats, len(p.Proxies))
+	for i, st := range p.state {
+		stats[i] = ProxyStats{URL: p.Proxies[i].URL, Successes: st.successes, Failures: st.failures}
+		if st.coolUntil.After(now) {
+			stats[i].CoolingDownUntil = st.coolUntil
+		}
+	}
+	return stats
+}
+
+// pick returns the index of the proxy for a request of the given
+// session, leaving out the proxies in exclude. Not every proxy may be
+// excluded.
+func (p *ProxyPool) pick(session any, exclude map[int]bool) int {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	usable := func(i int) bool {
+		return !exclude[i] && !p.state[i].coolUntil.After(now)
+	}
+	if session != nil {
+		if e := p.sessions[session]; e != nil && usable(e.Value.(*proxySessionEntry).index) {
+			p.lru.MoveToFront(e)
+			return e.Value.(*proxySessionEntry).index
+		}
+	}
+
+	var candidates []int
+	for i := range p.Proxies {
+		if usable(i) {
+			candidates = append(candidates, i)
+		}
+	}
+	if len(candidates) == 0 {
+		// Every proxy left is cooling down: use the first to recover.
+		best := -1
+		for i := range p.Proxies {
+			if !exclude[i] && (best < 0 || p.state[i].coolUntil.Before(p.state[best].coolUntil)) {
+				best = i
+			}
+		}
+		candidates = []int{best}
+	}
+
+	i := candidates[0]
+	switch p.Selection {
+	case Weighted:
+		total := 0
+		for _, c := range candidates {
+			total += max(p.Proxies[c].Weight, 1)
+		}
+		n := rand.IntN(total)
+		for _, c := range candidates {
+			if n -= max(p.Proxies[c].Weight, 1); n < 0 {
+				i = c
+				break
+			}
+		}
+	case LeastErrors:
+		i = -1
+		for _, c := range p.inTurn(candidates) {
+			if i < 0 || p.state[c].failures < p.state[i].failures {
+				i = c
+			}
+		}
+		p.next = i + 1
+	default:
+		i = p.inTurn(candidates)[0]
+		p.next = i + 1
+	}
+	if session != nil {
+		p.stickLocked(session, i)
+	}
+	return i
+}
+
+// stickLocked makes session use the proxy at index i, forgetting the
+// least recently used sessions beyond MaxSessions.
+func (p *ProxyPool) stickLocked(session any, i int) {
+	if e := p.sessions[session]; e != nil {
+		e.Value.(*proxySessionEntry).index = i
+		p.lru.MoveToFront(e)
+		return
+	}
+	p.sessions[session] = p.lru.PushFront(&proxySessionEntry{session, i})
+	maxSessions := p.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxProxySessions
+	}
+	for p.lru.Len() > maxSessions {
+		delete(p.sessions, p.lru.Remove(p.lru.Back()).(*proxySessionEntry).session)
+	}
+}
+
+// inTurn returns candidates, which are in increasing order, rotated to
+// start at the round-robin position.
+func (p *ProxyPool) inTurn(candidates []int) []int {
+	for k, c := range candidates {
+		if c >= p.next%len(p.Proxies) {
+			return append(candidates[k:len(candidates):len(candidates)], candidates[:k]...)
+		}
+	}
+	return candidates
+}
+
+func (p *ProxyPool) succeeded(i int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.state[i].successes++
+	p.state[i].consecutive = 0
+}
+
+func (p *ProxyPool) failed(i int) {
+	maxFailures := p.MaxFailures
+	if maxFailures <= 0 {
+		maxFailures = 3
+	}
+	cooldown := p.Cooldown
+	if cooldown <= 0 {
+		cooldown = 30 * time.Second
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	st := &p.state[i]
+	st.failures++
+	if st.consecutive++; st.consecutive >= maxFailures {
+		st.consecutive = 0
+		st.coolUntil = time.Now().Add(cooldown)
+		// Its sessions choose anew rather than wait for it.
+		for e := p.lru.Front(); e != nil; {
+			next := e.Next()
+			if se := e.Value.(*proxySessionEntry); se.index == i {
+				p.lru.Remove(e)
+				delete(p.sessions, se.session)
+			}
+			e = next
+		}
+	}
+}
+
+// proxyConnectError is the error for a CONNECT request that a proxy
+// refused, as reported by ProxyPool.OnProxyConnectResponse.
+type proxyConnectError string
+
+func (e proxyConnectError) Error() string { return string(e) }
+
+// isProxyFailure reports whether err, returned by a Transport, is a
+// failure of the request's proxy rather than of the request itself.
+func isProxyFailure(err error) bool {
+	if _, ok := errors.AsType[proxyConnectError](err); ok {
+		return true
+	}
+	if oe, ok := errors.AsType[*net.OpError](err); ok {
+		// Dialing the proxy fails with "proxyconnect", its SOCKS
+		// handshake with "socks connect".
+		return oe.Op == "proxyconnect" || strings.HasPrefix(oe.Op, "socks ")
+	}
+	return false
+}
+
+type proxyPoolContextKey struct{}
+
+// proxyPoolChoice is the proxy ProxyPool.RoundTrip chose for a request.
+type proxyPoolChoice struct {
+	pool  *ProxyPool
+	index int
+}
+
+type proxySessionContextKey struct{}
+
+// WithProxySession returns a copy of ctx that makes requests carrying it
+// stick to one proxy of a [ProxyPool] while that proxy is healthy.
+// session must be comparable.
+func WithProxySession(ctx context.Context, session any) context.Context {
+	return context.WithValue(ctx, proxySessionContextKey{}, session)
+}
+
+func proxySession(ctx context.Context) any {
+	return ctx.Value(proxySessionContextKey{})
+}
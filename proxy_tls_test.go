@@ -0,0 +1,135 @@
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	tls "github.com/refraction-networking/utls"
+)
+
+func TestProxyConnectHeaderOrder(t *testing.T) {
+	origin := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	defer origin.Close()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		wg    sync.WaitGroup
+		names []string
+	)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		c, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		br := bufio.NewReader(c)
+		br.ReadString('\n') // request line
+		for {
+			line, err := br.ReadString('\n')
+			if err != nil {
+				c.Close()
+				return
+			}
+			if line == "\r\n" {
+				break
+			}
+			name, _, _ := strings.Cut(line, ":")
+			names = append(names, name)
+		}
+		backend, err := net.Dial("tcp", origin.Listener.Addr().String())
+		if err != nil {
+			c.Close()
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 200 OK\r\n\r\n")
+		tunnel(&wg, c, backend)
+	}()
+	defer func() {
+		ln.Close()
+		wg.Wait()
+	}()
+
+	tr := origin.Client().Transport.(*Transport)
+	defer tr.CloseIdleConnections()
+	tr.Proxy = ProxyURL(&url.URL{Scheme: "http", User: url.UserPassword("u", "p"), Host: ln.Addr().String()})
+	tr.GetProxyConnectHeader = func(ctx context.Context, proxyURL *url.URL, target string) (Header, error) {
+		return Header{
+			"X-Custom":     {"1"},
+			"User-Agent":   {"agent"},
+			HeaderOrderKey: {"x-custom", "proxy-authorization", "host", "user-agent"},
+		}, nil
+	}
+	resp, err := (&Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	tr.CloseIdleConnections()
+
+	want := []string{"X-Custom", "Proxy-Authorization", "Host", "User-Agent"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("CONNECT header order = %q, want %q", names, want)
+	}
+}
+
+func TestProxyClientHelloSettings(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		hellos = map[string]*tls.ClientHelloInfo{}
+	)
+	record := func(name string) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		return func(h *tls.ClientHelloInfo) (*tls.Config, error) {
+			mu.Lock()
+			hellos[name] = h
+			mu.Unlock()
+			return nil, nil
+		}
+	}
+
+	origin := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "origin")
+	}))
+	origin.TLS = &tls.Config{GetConfigForClient: record("origin")}
+	origin.StartTLS()
+	defer origin.Close()
+
+	proxy := newTestProxy(t, "proxy", false, &tls.Config{GetConfigForClient: record("proxy")})
+
+	tr := origin.Client().Transport.(*Transport)
+	defer tr.CloseIdleConnections()
+	tr.Proxy = ProxyURL(proxy.URL)
+	tr.ClientHelloSettings = ClientHelloSettings{HelloID: tls.HelloChrome_133}
+	tr.ProxyClientHelloSettings = &ClientHelloSettings{HelloID: tls.HelloFirefox_120}
+	resp, err := (&Client{Transport: tr}).Get(origin.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Firefox sends record_size_limit (28), Chrome does not.
+	const recordSizeLimit = 28
+	mu.Lock()
+	defer mu.Unlock()
+	if h := hellos["proxy"]; h == nil || !slices.Contains(h.Extensions, recordSizeLimit) || slices.Contains(h.SupportedProtos, "h2") {
+		t.Errorf("proxy saw hello %+v, want Firefox's without h2", h)
+	}
+	if h := hellos["origin"]; h == nil || slices.Contains(h.Extensions, recordSizeLimit) {
+		t.Errorf("origin saw hello %+v, want Chrome's", h)
+	}
+}
@@ -0,0 +1,142 @@
+//go:build ignore
+
+// This program regenerates table.txt, the public suffix list embedded in
+// package publicsuffix, from a local copy of
+// https://publicsuffix.org/list/public_suffix_list.dat:
+//
+//	go run gen.go -in public_suffix_list.dat -out table.txt
+//
+// It drops comments, converts internationalized rules to Punycode, checks
+// that every rule is well formed and keeps the ICANN and private sections
+// apart.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+var (
+	in  = flag.String("in", "", "public_suffix_list.dat to read")
+	out = flag.String("out", "table.txt", "table file to write")
+)
+
+const (
+	beginICANN   = "// ===BEGIN ICANN DOMAINS==="
+	endICANN     = "// ===END ICANN DOMAINS==="
+	beginPrivate = "// ===BEGIN PRIVATE DOMAINS==="
+	endPrivate   = "// ===END PRIVATE DOMAINS==="
+)
+
+func main() {
+	flag.Parse()
+	if *in == "" {
+		log.Fatal("missing -in; download https://publicsuffix.org/list/public_suffix_list.dat first")
+	}
+	data, err := os.ReadFile(*in)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var header []string
+	header = append(header, "// Code generated by gen.go from public_suffix_list.dat. DO NOT EDIT.")
+	header = append(header, fmt.Sprintf("// SHA256: %x", sha256.Sum256(data)))
+
+	var icann, private []string
+	var section *[]string
+	seen := make(map[string]bool)
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		switch {
+		case line == beginICANN:
+			section = &icann
+			continue
+		case line == beginPrivate:
+			section = &private
+			continue
+		case line == endICANN || line == endPrivate:
+			section = nil
+			continue
+		case strings.HasPrefix(line, "// VERSION: "), strings.HasPrefix(line, "// COMMIT: "):
+			header = append(header, line)
+			continue
+		case line == "" || strings.HasPrefix(line, "//"):
+			continue
+		}
+		if section == nil {
+			log.Fatalf("%s:%d: rule %q outside the ICANN and private sections", *in, n, line)
+		}
+		// Rules end at the first whitespace.
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			line = line[:i]
+		}
+		rule, err := normalize(line)
+		if err != nil {
+			log.Fatalf("%s:%d: %v", *in, n, err)
+		}
+		if seen[rule] {
+			log.Fatalf("%s:%d: duplicate rule %q", *in, n, rule)
+		}
+		seen[rule] = true
+		*section = append(*section, rule)
+	}
+	if err := sc.Err(); err != nil {
+		log.Fatal(err)
+	}
+	if len(icann) == 0 || len(private) == 0 {
+		log.Fatalf("%s: missing ICANN or private section", *in)
+	}
+	header = append(header, fmt.Sprintf("// RULES: %d ICANN, %d private", len(icann), len(private)))
+
+	var b bytes.Buffer
+	for _, l := range header {
+		b.WriteString(l + "\n")
+	}
+	b.WriteString(beginICANN + "\n")
+	for _, r := range icann {
+		b.WriteString(r + "\n")
+	}
+	b.WriteString(beginPrivate + "\n")
+	for _, r := range private {
+		b.WriteString(r + "\n")
+	}
+	if err := os.WriteFile(*out, b.Bytes(), 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// normalize returns rule with its labels converted to lower-case Punycode,
+// keeping a leading "!" or "*." as it is.
+func normalize(rule string) (string, error) {
+	prefix := ""
+	switch {
+	case strings.HasPrefix(rule, "!"):
+		prefix, rule = "!", rule[1:]
+	case strings.HasPrefix(rule, "*."):
+		prefix, rule = "*.", rule[2:]
+	}
+	if rule == "" || strings.Contains(rule, "*") || strings.Contains(rule, "!") {
+		return "", fmt.Errorf("malformed rule %q", prefix+rule)
+	}
+	a, err := idna.ToASCII(rule)
+	if err != nil {
+		return "", fmt.Errorf("rule %q: %v", prefix+rule, err)
+	}
+	a = strings.ToLower(a)
+	if strings.HasPrefix(a, ".") || strings.HasSuffix(a, ".") || strings.Contains(a, "..") {
+		return "", fmt.Errorf("rule %q has an empty label", prefix+rule)
+	}
+	if prefix == "!" && !strings.Contains(a, ".") {
+		return "", fmt.Errorf("exception rule %q has a single label", prefix+rule)
+	}
+	return prefix + a, nil
+}
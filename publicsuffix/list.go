@@ -0,0 +1,153 @@
+//go:generate go run gen.go -in public_suffix_list.dat -out table.txt
+
+// Package publicsuffix provides a public suffix list based on data from
+// https://publicsuffix.org/, embedded in the binary.
+//
+// It is a dependency-free alternative to golang.org/x/net/publicsuffix
+// with the same API, and the list that cookiejar.New uses when
+// Options.UseBuiltinPublicSuffixList is set. The embedded table.txt is
+// regenerated from a local copy of public_suffix_list.dat with gen.go.
+package publicsuffix
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+//go:embed table.txt
+var table string
+
+// List implements the cookiejar.PublicSuffixList interface by calling the
+// PublicSuffix function.
+var List = list{}
+
+type list struct{}
+
+func (list) PublicSuffix(domain string) string {
+	ps, _ := PublicSuffix(domain)
+	return ps
+}
+
+func (list) String() string {
+	return Version()
+}
+
+// Rule kinds, as bits of the value of a rules map entry. Each kind has
+// an ICANN bit, set if the rule is in the ICANN section of the list.
+const (
+	ruleNormal    = 1 << iota // "example.com"
+	ruleWildcard              // "*.example.com", keyed by "example.com"
+	ruleException             // "!www.example.com", keyed by "www.example.com"
+	icannShift    = iota
+)
+
+var (
+	loadOnce sync.Once
+	rules    map[string]uint8
+	version  string
+)
+
+// load parses table into rules.
+func load() {
+	rules = make(map[string]uint8, strings.Count(table, "\n"))
+	var meta []string
+	icann := false
+	for line := range strings.Lines(table) {
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "// ===BEGIN ICANN DOMAINS===":
+			icann = true
+			continue
+		case line == "// ===BEGIN PRIVATE DOMAINS===":
+			icann = false
+			continue
+		case strings.HasPrefix(line, "// SHA256: "):
+			meta = append(meta, "sha256 "+strings.TrimPrefix(line, "// SHA256: ")[:12])
+			continue
+		case strings.HasPrefix(line, "// VERSION: "), strings.HasPrefix(line, "// COMMIT: "):
+			meta = append(meta, strings.ToLower(strings.TrimPrefix(line, "// ")))
+			continue
+		case line == "" || strings.HasPrefix(line, "//"):
+			continue
+		}
+		var kind uint8 = ruleNormal
+		switch {
+		case strings.HasPrefix(line, "!"):
+			kind, line = ruleException, line[1:]
+		case strings.HasPrefix(line, "*."):
+			kind, line = ruleWildcard, line[2:]
+		}
+		if icann {
+			kind |= kind << icannShift
+		}
+		rules[line] |= kind
+	}
+	version = "publicsuffix.org's public_suffix_list.dat, " + strings.Join(meta, ", ")
+}
+
+// Version describes the embedded copy of the list, including the SHA-256
+// of the public_suffix_list.dat it was generated from.
+func Version() string {
+	loadOnce.Do(load)
+	return version
+}
+
+// PublicSuffix returns the public suffix of the domain using the copy of
+// the publicsuffix.org list embedded in this package. domain should be a
+// lower-case ASCII (Punycode) name without leading or trailing dots.
+//
+// icann is whether the public suffix comes from the ICANN section of the
+// list. If not, the public suffix is either a privately managed domain
+// from the private section, such as "github.io", or an unlisted top level
+// domain, which is treated as a public suffix by the list's default "*"
+// rule. For example, "foo.org" and "foo.co.uk" are ICANN domains,
+// "foo.github.io" is a private domain and "foo.unlisted" is neither.
+func PublicSuffix(domain string) (publicSuffix string, icann bool) {
+	loadOnce.Do(load)
+
+	// Try each suffix of domain from the longest. The first one matched
+	// by a rule has the most labels and is the prevailing rule, unless a
+	// shorter exception rule also matches, which cannot happen in the
+	// published list.
+	for s := domain; ; {
+		parent, hasParent := "", false
+		if i := strings.IndexByte(s, '.'); i >= 0 {
+			parent, hasParent = s[i+1:], true
+		}
+		r := rules[s]
+		switch {
+		case r&ruleException != 0:
+			return parent, r&(ruleException<<icannShift) != 0
+		case r&ruleNormal != 0:
+			return s, r&(ruleNormal<<icannShift) != 0
+		}
+		if !hasParent {
+			// The default rule "*": the last label.
+			return s, false
+		}
+		if pr := rules[parent]; pr&ruleWildcard != 0 {
+			return s, pr&(ruleWildcard<<icannShift) != 0
+		}
+		s = parent
+	}
+}
+
+// EffectiveTLDPlusOne returns the effective top level domain plus one more
+// label. For example, the eTLD+1 for "foo.bar.golang.org" is "golang.org".
+func EffectiveTLDPlusOne(domain string) (string, error) {
+	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") || strings.Contains(domain, "..") {
+		return "", fmt.Errorf("publicsuffix: empty label in domain %q", domain)
+	}
+
+	suffix, _ := PublicSuffix(domain)
+	if len(domain) <= len(suffix) {
+		return "", fmt.Errorf("publicsuffix: cannot derive eTLD+1 for domain %q", domain)
+	}
+	i := len(domain) - len(suffix) - 1
+	if domain[i] != '.' {
+		return "", fmt.Errorf("publicsuffix: invalid public suffix %q for domain %q", suffix, domain)
+	}
+	return domain[1+strings.LastIndexByte(domain[:i], '.'):], nil
+}
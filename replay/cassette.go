@@ -0,0 +1,192 @@
+// Package replay provides an http.RoundTripper that serves recorded
+// responses, so that tests built on http.Client run without a network.
+//
+// Recorded exchanges are kept in a Cassette, which is read from and
+// written to JSON files. ReadCassette also accepts HAR files, such as
+// those exported by browser developer tools or written by
+// har.Recorder. A Transport replays a cassette and, depending on its
+// Mode, records the requests it cannot replay:
+//
+//	c, err := replay.Open("testdata/example.json")
+//	if err != nil { ... }
+//	rt := &replay.Transport{Cassette: c, Mode: replay.ModeReplayOrRecord}
+//	client := &http.Client{Transport: rt}
+//	...
+//	err = c.WriteFile("testdata/example.json")
+//
+// Once the cassette is recorded, ModeReplay runs the same test offline.
+package replay
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/har"
+)
+
+// cassetteVersion is the version of the native cassette format.
+const cassetteVersion = 1
+
+// A Cassette is a list of recorded request and response pairs.
+type Cassette struct {
+	Interactions []*Interaction `json:"interactions"`
+}
+
+// An Interaction is one recorded exchange.
+type Interaction struct {
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+}
+
+// Request is a recorded request.
+type Request struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   []byte      `json:"body,omitempty"`
+}
+
+// BodySHA256 returns the hex-encoded SHA-256 of r.Body.
+func (r *Request) BodySHA256() string {
+	sum := sha256.Sum256(r.Body)
+	return hex.EncodeToString(sum[:])
+}
+
+// Response is a recorded response. Its Body is as the client received
+// it: if the Transport decompressed the response, Body is decompressed
+// and Header has no Content-Encoding.
+type Response struct {
+	StatusCode int         `json:"status"`
+	Proto      string      `json:"proto,omitempty"` // defaults to "HTTP/1.1"
+	Header     http.Header `json:"header,omitempty"`
+	Body       []byte      `json:"body,omitempty"`
+}
+
+type cassetteFile struct {
+	Version      int            `json:"version"`
+	Interactions []*Interaction `json:"interactions"`
+
+	// Log is set for HAR files.
+	Log json.RawMessage `json:"log,omitempty"`
+}
+
+// ReadCassette reads a cassette written by Cassette.Write, or converts a
+// HAR file with FromHAR.
+func ReadCassette(r io.Reader) (*Cassette, error) {
+	var f cassetteFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return nil, fmt.Errorf("replay: reading cassette: %w", err)
+	}
+	if f.Log != nil {
+		var h har.HAR
+		if err := json.Unmarshal(f.Log, &h.Log); err != nil {
+			return nil, fmt.Errorf("replay: reading HAR: %w", err)
+		}
+		return FromHAR(&h)
+	}
+	if f.Version != cassetteVersion {
+		return nil, fmt.Errorf("replay: unsupported cassette version %d", f.Version)
+	}
+	return &Cassette{Interactions: f.Interactions}, nil
+}
+
+// Open reads the cassette or HAR file name. If the file does not exist,
+// it returns an empty cassette, to be filled by recording.
+func Open(name string) (*Cassette, error) {
+	f, err := os.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return &Cassette{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ReadCassette(f)
+}
+
+// Write writes c to w in the native cassette format.
+func (c *Cassette) Write(w io.Writer) error {
+	interactions := c.Interactions
+	if interactions == nil {
+		interactions = []*Interaction{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(cassetteFile{Version: cassetteVersion, Interactions: interactions})
+}
+
+// WriteFile writes c to the file name in the native cassette format.
+func (c *Cassette) WriteFile(name string) error {
+	var buf bytes.Buffer
+	if err := c.Write(&buf); err != nil {
+		return err
+	}
+	return os.WriteFile(name, buf.Bytes(), 0o644)
+}
+
+// FromHAR converts the entries of a HAR archive into a cassette. Entries
+// for failed requests are left out. HAR records response bodies after
+// content decoding, so the Content-Encoding and Content-Length headers
+// are dropped; HTTP/2 pseudo-header fields are dropped too. Bodies the
+// archive did not record replay as empty.
+func FromHAR(h *har.HAR) (*Cassette, error) {
+	c := &Cassette{}
+	for i, e := range h.Log.Entries {
+		if e.Response.Status == 0 {
+			continue
+		}
+		in := &Interaction{
+			Request: Request{
+				Method: e.Request.Method,
+				URL:    e.Request.URL,
+				Header: harHeader(e.Request.Headers),
+			},
+			Response: Response{
+				StatusCode: e.Response.Status,
+				Proto:      e.Response.HTTPVersion,
+				Header:     harHeader(e.Response.Headers),
+			},
+		}
+		var err error
+		if pd := e.Request.PostData; pd != nil {
+			if in.Request.Body, err = harBody(pd.Text, pd.Encoding); err != nil {
+				return nil, fmt.Errorf("replay: HAR entry %d: request body: %w", i, err)
+			}
+		}
+		if in.Response.Body, err = harBody(e.Response.Content.Text, e.Response.Content.Encoding); err != nil {
+			return nil, fmt.Errorf("replay: HAR entry %d: response body: %w", i, err)
+		}
+		in.Response.Header.Del("Content-Encoding")
+		in.Response.Header.Del("Content-Length")
+		c.Interactions = append(c.Interactions, in)
+	}
+	return c, nil
+}
+
+func harHeader(hs []har.NameValue) http.Header {
+	h := make(http.Header)
+	for _, nv := range hs {
+		if strings.HasPrefix(nv.Name, ":") {
+			continue
+		}
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
+
+func harBody(text, encoding string) ([]byte, error) {
+	if encoding == "base64" {
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return []byte(text), nil
+}
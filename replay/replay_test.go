@@ -0,0 +1,177 @@
+package replay_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/har"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/replay"
+)
+
+func newServer(t *testing.T) (*httptest.Server, *atomic.Int32) {
+	var hits atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("X-Hit", string(rune('0'+n)))
+		http.SetCookie(w, &http.Cookie{Name: "c", Value: "v"})
+		io.WriteString(w, r.Method+" "+r.URL.RequestURI()+" "+string(b))
+	}))
+	t.Cleanup(ts.Close)
+	return ts, &hits
+}
+
+func send(t *testing.T, rt http.RoundTripper, method, url, body string, header ...string) (*http.Response, string, error) {
+	t.Helper()
+	var rb io.Reader
+	if body != "" {
+		rb = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, rb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(header); i += 2 {
+		req.Header.Set(header[i], header[i+1])
+	}
+	resp, err := (&http.Client{Transport: rt}).Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b), nil
+}
+
+func mustSend(t *testing.T, rt http.RoundTripper, method, url, body string, header ...string) (*http.Response, string) {
+	t.Helper()
+	resp, got, err := send(t, rt, method, url, body, header...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, got
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	ts, hits := newServer(t)
+
+	rec := &replay.Transport{Cassette: &replay.Cassette{}, Mode: replay.ModeReplayOrRecord}
+	mustSend(t, rec, "GET", ts.URL+"/a", "")
+	mustSend(t, rec, "POST", ts.URL+"/b", "payload")
+	mustSend(t, rec, "GET", ts.URL+"/a", "") // replayed
+	if n := hits.Load(); n != 2 {
+		t.Fatalf("server hit %d times while recording, want 2", n)
+	}
+
+	var buf bytes.Buffer
+	if err := rec.Cassette.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+	c, err := replay.ReadCassette(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &replay.Transport{Cassette: c}
+	resp, body := mustSend(t, rt, "POST", ts.URL+"/b", "payload")
+	if body != "POST /b payload" || resp.StatusCode != 200 || resp.Header.Get("X-Hit") != "2" {
+		t.Errorf("replayed %d %q, X-Hit %q", resp.StatusCode, body, resp.Header.Get("X-Hit"))
+	}
+	if cs := resp.Cookies(); len(cs) != 1 || cs[0].Name != "c" {
+		t.Errorf("replayed cookies = %v", cs)
+	}
+	if u := rt.Unplayed(); len(u) != 1 || u[0].Request.URL != ts.URL+"/a" {
+		t.Errorf("Unplayed() = %v, want the GET /a interaction", u)
+	}
+	if _, _, err := send(t, rt, "GET", ts.URL+"/missing", ""); !errors.Is(err, replay.ErrNoInteraction) {
+		t.Errorf("unmatched request: err = %v, want ErrNoInteraction", err)
+	}
+}
+
+func TestStrict(t *testing.T) {
+	ts, _ := newServer(t)
+	c := &replay.Cassette{}
+	rec := &replay.Transport{Cassette: c, Mode: replay.ModeRecord}
+	mustSend(t, rec, "GET", ts.URL+"/", "")
+	mustSend(t, rec, "GET", ts.URL+"/", "")
+
+	for _, strict := range []bool{false, true} {
+		rt := &replay.Transport{Cassette: c, Strict: strict}
+		var hits []string
+		for range 3 {
+			resp, _, err := send(t, rt, "GET", ts.URL+"/", "")
+			if err != nil {
+				hits = append(hits, "err")
+				continue
+			}
+			hits = append(hits, resp.Header.Get("X-Hit"))
+		}
+		want := "1 2 2"
+		if strict {
+			want = "1 2 err"
+		}
+		if got := strings.Join(hits, " "); got != want {
+			t.Errorf("Strict=%v: replayed %s, want %s", strict, got, want)
+		}
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	ts, _ := newServer(t)
+	c := &replay.Cassette{}
+	rec := &replay.Transport{Cassette: c, Mode: replay.ModeRecord}
+	mustSend(t, rec, "POST", ts.URL+"/", "one", "X-Tenant", "a")
+	mustSend(t, rec, "POST", ts.URL+"/", "two", "X-Tenant", "b")
+
+	rt := &replay.Transport{
+		Cassette: c,
+		Match:    replay.MatchAll(replay.DefaultMatcher, replay.MatchBody, replay.MatchHeader("X-Tenant")),
+	}
+	if _, body := mustSend(t, rt, "POST", ts.URL+"/", "two", "X-Tenant", "b"); body != "POST / two" {
+		t.Errorf("body and header match replayed %q", body)
+	}
+	if _, _, err := send(t, rt, "POST", ts.URL+"/", "two", "X-Tenant", "a"); !errors.Is(err, replay.ErrNoInteraction) {
+		t.Errorf("header mismatch: err = %v, want ErrNoInteraction", err)
+	}
+	if _, _, err := send(t, rt, "POST", ts.URL+"/", "three", "X-Tenant", "b"); !errors.Is(err, replay.ErrNoInteraction) {
+		t.Errorf("body mismatch: err = %v, want ErrNoInteraction", err)
+	}
+}
+
+func TestReplayHAR(t *testing.T) {
+	ts, _ := newServer(t)
+	hr := &har.Recorder{Transport: &http.Transport{}}
+	mustSend(t, hr, "POST", ts.URL+"/form?x=1", "k=v")
+	var buf bytes.Buffer
+	if err := hr.WriteHAR(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ts.Close()
+
+	c, err := replay.ReadCassette(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := &replay.Transport{Cassette: c, Match: replay.MatchAll(replay.DefaultMatcher, replay.MatchBody)}
+	resp, body := mustSend(t, rt, "POST", ts.URL+"/form?x=1", "k=v")
+	if body != "POST /form?x=1 k=v" || resp.Header.Get("X-Hit") != "1" || resp.Header.Get("Content-Length") != "" {
+		t.Errorf("replayed %q with header %v", body, resp.Header)
+	}
+}
+
+func TestReadCassetteErrors(t *testing.T) {
+	for _, in := range []string{"", "{", `{"version": 2}`, `{"log": {"entries": [{"response": {"status": 200, "content": {"text": "!", "encoding": "base64"}}}]}}`} {
+		if _, err := replay.ReadCassette(strings.NewReader(in)); err == nil {
+			t.Errorf("ReadCassette(%q) succeeded, want error", in)
+		}
+	}
+}
@@ -297,6 +297,18 @@ type Request struct {
 	// It is an error to set this field in an HTTP client request.
 	RequestURI string
 
+	// [dhttp] RawRequestTarget, if non-empty, is the request-target
+	// the client sends on the HTTP/1.1 request line, or as the HTTP/2
+	// :path pseudo-header, exactly as given instead of derived from
+	// URL, which still selects the scheme and host to connect to. It
+	// carries targets that URL would normalize or cannot express, such
+	// as "/a/../b", "//x", "%2e%2e/", the absolute-form
+	// "http://example.com/" or the asterisk-form "*". Only bytes that
+	// would break the framing are rejected: controls and spaces for
+	// HTTP/1.1, and NUL, CR and LF for HTTP/2. It is not carried over
+	// to redirects, and is ignored by the HTTP server.
+	RawRequestTarget string
+
 	// TLS allows HTTP servers and other software to record
 	// information about the TLS connection on which the request
 	// was received. This field is not filled in by ReadRequest.
@@ -674,6 +686,16 @@ func (r *Request) write(w io.Writer, usingProxy bool, extraHeaders Header, waitF
 			ruri = r.URL.Opaque
 		}
 	}
+	// [dhttp] Send RawRequestTarget verbatim; only controls and spaces
+	// would break the request line.
+	if r.RawRequestTarget != "" {
+		ruri = r.RawRequestTarget
+		for i := 0; i < len(ruri); i++ {
+			if b := ruri[i]; b <= ' ' || b == 0x7f {
+				return fmt.Errorf("net/http: can't write byte %q in Request.RawRequestTarget", b)
+			}
+		}
+	}
 	if stringContainsCTLByte(ruri) {
 		return errors.New("net/http: can't write control character in Request.URL")
 	}
@@ -750,7 +772,17 @@ func (r *Request) write(w io.Writer, usingProxy bool, extraHeaders Header, waitF
 		}
 	}
 
-	err = r.Header.writeSubset(w, reqWriteExcludeHeader, trace)
+	exclude, hdr := reqWriteExcludeHeader, r.Header
+	if r.Header.headerOrderContains("Host") {
+		exclude = nil // [dhttp] Host is ordered like the other fields
+		if _, ok := r.Header.contains("Host"); !ok {
+			// [dhttp] Write Host in its place in the header order,
+			// without adding it to the caller's Header.
+			hdr = r.Header.Clone()
+			hdr["Host"] = []string{host}
+		}
+	}
+	err = hdr.writeSubset(w, exclude, trace)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,99 @@
+package http_test
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+)
+
+func TestRawRequestTargetHTTP1(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 1)
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			// Requests with invalid targets fail before being written.
+			if line, err := bufio.NewReader(c).ReadString('\n'); err == nil {
+				lines <- strings.TrimSuffix(line, "\r\n")
+				c.Write([]byte("HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n"))
+			}
+			c.Close()
+		}
+	}()
+
+	tr := &Transport{}
+	defer tr.CloseIdleConnections()
+	for _, tt := range []struct{ method, target string }{
+		{"GET", "/a/../b"},
+		{"GET", "//x"},
+		{"GET", "%2e%2e/"},
+		{"GET", "http://example.com/abs?q"},
+		{"OPTIONS", "*"},
+		{"GET", "/caf\xc3\xa9"},
+	} {
+		req, _ := NewRequest(tt.method, "http://"+ln.Addr().String()+"/ignored", nil)
+		req.RawRequestTarget = tt.target
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.method, tt.target, err)
+			continue
+		}
+		resp.Body.Close()
+		if got, want := <-lines, tt.method+" "+tt.target+" HTTP/1.1"; got != want {
+			t.Errorf("request line = %q, want %q", got, want)
+		}
+	}
+
+	for _, target := range []string{"/a b", "/a\r\nX-Injected: 1", "/\x00"} {
+		req, _ := NewRequest("GET", "http://"+ln.Addr().String()+"/", nil)
+		req.RawRequestTarget = target
+		if resp, err := tr.RoundTrip(req); err == nil {
+			resp.Body.Close()
+			t.Errorf("RawRequestTarget %q: request sent, want error", target)
+			<-lines
+		}
+	}
+}
+
+func TestRawRequestTargetHTTP2(t *testing.T) {
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Write([]byte(r.RequestURI))
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	c := ts.Client()
+
+	for _, target := range []string{"/a/../b", "//x/./y"} {
+		req, _ := NewRequest("GET", ts.URL+"/ignored", nil)
+		req.RawRequestTarget = target
+		resp, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := make([]byte, 64)
+		n, _ := resp.Body.Read(b)
+		resp.Body.Close()
+		if resp.ProtoMajor != 2 || string(b[:n]) != target {
+			t.Errorf("%s :path = %q, want %q", resp.Proto, b[:n], target)
+		}
+	}
+
+	req, _ := NewRequest("GET", ts.URL+"/", nil)
+	req.RawRequestTarget = "/a\nb"
+	if resp, err := c.Do(req); err == nil {
+		resp.Body.Close()
+		t.Error("RawRequestTarget with LF: request sent, want error")
+	}
+}
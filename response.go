@@ -124,6 +124,17 @@ type Response struct {
 	// The pointer is shared between responses and should not be
 	// modified.
 	TLS *tls.ConnectionState
+
+	// [dhttp] Fingerprint is the name of the Fingerprint the Transport
+	// presented on the connection the response was received on, when
+	// Transport.Fingerprints is set.
+	Fingerprint string
+
+	// [dhttp] Conn describes the connection the response was received
+	// on, including the proxy and CONNECT response it went through. It is
+	// only populated for Client requests. The pointer is shared between
+	// responses and should not be modified.
+	Conn *ConnInfo
 }
 
 // Cookies parses and returns the cookies set in the Set-Cookie headers.
@@ -190,7 +201,7 @@ func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
 	}
 
 	// Parse the response headers.
-	mimeHeader, err := tp.ReadMIMEHeader()
+	mimeHeader, err := readResponseHeader(tp, resp) // [dhttp] GotHeaderField
 	if err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
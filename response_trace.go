@@ -0,0 +1,68 @@
+package http
+
+import (
+	"bufio"
+	"bytes"
+	"net/textproto"
+
+	"github.com/dteh/dhttp/httptrace"
+)
+
+// readResponseHeader reads the header of the HTTP/1 response resp, whose
+// status line has been parsed, from tp. Unless resp is an informational
+// response, it reports each field in wire order to the GotHeaderField
+// hook of the request's ClientTrace, if any.
+//
+// textproto.Reader.ReadMIMEHeader loses the order of the fields, so when
+// the hook is set the header block is read line by line first and then
+// parsed from a copy, keeping ReadMIMEHeader's validation.
+func readResponseHeader(tp *textproto.Reader, resp *Response) (textproto.MIMEHeader, error) {
+	var trace *httptrace.ClientTrace
+	if resp.Request != nil && !is1xxNonTerminal(resp.StatusCode) {
+		trace = httptrace.ContextClientTrace(resp.Request.Context())
+	}
+	if trace == nil || trace.GotHeaderField == nil {
+		return tp.ReadMIMEHeader()
+	}
+
+	var block bytes.Buffer
+	var keys []string
+	for {
+		line, err := tp.ReadLineBytes()
+		if err != nil {
+			return nil, err
+		}
+		block.Write(line)
+		block.WriteString("\r\n")
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			continue // continuation of the previous field
+		}
+		if k, _, ok := bytes.Cut(line, []byte(":")); ok {
+			keys = append(keys, string(k))
+		}
+	}
+	h, err := textproto.NewReader(bufio.NewReader(&block)).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	// The i-th field with a given key holds the i-th value of h[key].
+	n := make(map[string]int, len(keys))
+	for _, k := range keys {
+		ck := textproto.CanonicalMIMEHeaderKey(k)
+		if i := n[ck]; i < len(h[ck]) {
+			trace.GotHeaderField(k, h[ck][i:i+1])
+		}
+		n[ck]++
+	}
+	return h, nil
+}
+
+// is1xxNonTerminal reports whether code is the status of an informational
+// response that precedes the final one. 101 Switching Protocols is final.
+func is1xxNonTerminal(code int) bool {
+	return 100 <= code && code <= 199 && code != StatusSwitchingProtocols
+}
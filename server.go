@@ -1899,10 +1899,22 @@ type connectionStater interface {
 
 // Serve a new connection.
 func (c *conn) serve(ctx context.Context) {
+	// [dhttp] The PROXY header, if any, comes first and sets the
+	// connection's addresses.
+	proxyHeader, proxyErr := proxyHeaderOf(c.rwc)
+	if proxyErr != nil {
+		c.server.logf("http: PROXY header error from %s: %v", c.rwc.RemoteAddr(), proxyErr)
+		c.close()
+		c.setState(c.rwc, StateClosed, runHooks)
+		return
+	}
 	if ra := c.rwc.RemoteAddr(); ra != nil {
 		c.remoteAddr = ra.String()
 	}
 	ctx = context.WithValue(ctx, LocalAddrContextKey, c.rwc.LocalAddr())
+	if proxyHeader != nil {
+		ctx = context.WithValue(ctx, ProxyHeaderContextKey, proxyHeader)
+	}
 	var inFlightResponse *response
 	defer func() {
 		if err := recover(); err != nil && err != ErrAbortHandler {
@@ -3078,6 +3090,16 @@ type Server struct {
 	// the default is HTTP/1 only.
 	Protocols *Protocols
 
+	// [dhttp] ProxyProtocol, if non-nil, makes the server read a PROXY
+	// protocol header, version 1 or 2, at the start of connections from
+	// trusted sources, such as a load balancer. Their Request.RemoteAddr
+	// and LocalAddrContextKey value are then the addresses from the
+	// header, and their requests' contexts carry the header under
+	// ProxyHeaderContextKey. Serve expects a listener of raw
+	// connections; with TLS, use ServeTLS or ListenAndServeTLS, which
+	// read the header before the TLS handshake.
+	ProxyProtocol *ProxyProtocolConfig
+
 	inShutdown atomic.Bool // true when server is in shutdown
 
 	disableKeepAlives atomic.Bool
@@ -3411,12 +3433,15 @@ func (s *Server) Serve(l net.Listener) error {
 	}
 
 	origListener := l
-	l = &onceCloseListener{Listener: l}
+	l = &onceCloseListener{Listener: s.proxyProtocolListener(l)} // [dhttp]
 	defer l.Close()
 
 	if err := s.setupHTTP2_Serve(); err != nil {
 		return err
 	}
+	if err := s.ProxyProtocol.check(); err != nil { // [dhttp]
+		return err
+	}
 
 	if !s.trackListener(&l, true) {
 		return ErrServerClosed
@@ -3503,7 +3528,11 @@ func (s *Server) ServeTLS(l net.Listener, certFile, keyFile string) error {
 		}
 	}
 
-	tlsListener := tls.NewListener(l, config)
+	// [dhttp] PROXY headers precede the TLS handshake.
+	tlsListener := tls.NewListener(s.proxyProtocolListener(l), config)
+	if s.ProxyProtocol != nil {
+		tlsListener = proxyProtoTLSListener{tlsListener}
+	}
 	return s.Serve(tlsListener)
 }
 
@@ -0,0 +1,338 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// isSOCKSProxy reports whether scheme names a SOCKS proxy, which tunnels
+// connections to the target rather than handling requests.
+func isSOCKSProxy(scheme string) bool {
+	switch scheme {
+	case "socks4", "socks4a", "socks5", "socks5h":
+		return true
+	}
+	return false
+}
+
+// newSOCKS5Dialer returns a dialer for SOCKS5 commands on a connection to
+// proxyURL, authenticating with its userinfo.
+func newSOCKS5Dialer(conn net.Conn, proxyURL *url.URL) *socksDialer {
+	d := socksNewDialer("tcp", conn.RemoteAddr().String())
+	if u := proxyURL.User; u != nil {
+		auth := &socksUsernamePassword{
+			Username: u.Username(),
+		}
+		auth.Password, _ = u.Password()
+		d.AuthMethods = []socksAuthMethod{
+			socksAuthMethodNotRequired,
+			socksAuthMethodUsernamePassword,
+		}
+		d.Authenticate = auth.Authenticate
+	}
+	return d
+}
+
+// SOCKS4 wire constants.
+const (
+	socks4Version        = 0x04
+	socks4CmdConnect     = 0x01
+	socks4ReplyVersion   = 0x00
+	socks4StatusGranted  = 90
+	socks4StatusRejected = 91
+	socks4StatusNoIdentd = 92
+	socks4StatusBadUser  = 93
+)
+
+// socks4Tunnel asks the SOCKS4 or SOCKS4a proxy proxyURL, to which conn
+// is connected, to tunnel conn to targetAddr. A "socks4" proxy is given
+// the IPv4 address of the target, resolved here; a "socks4a" proxy
+// resolves host names itself. The user ID is the proxy URL's username.
+func socks4Tunnel(ctx context.Context, conn net.Conn, proxyURL *url.URL, targetAddr string) (ctxErr error) {
+	opErr := func(err error) error {
+		proxy, dst, _ := socksNewDialer("tcp", conn.RemoteAddr().String()).pathAddrs(targetAddr)
+		return &net.OpError{Op: socksCmdConnect.String(), Net: "tcp", Source: proxy, Addr: dst, Err: err}
+	}
+	host, port, err := sockssplitHostPort(targetAddr)
+	if err != nil {
+		return opErr(err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil && proxyURL.Scheme == "socks4" {
+		ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip4", host)
+		if err != nil {
+			return opErr(err)
+		}
+		ip = net.IP(ips[0].AsSlice())
+	}
+	if ip != nil && ip.To4() == nil {
+		return opErr(errors.New("SOCKS4 does not support IPv6 address " + host))
+	}
+	var user string
+	if proxyURL.User != nil {
+		user = proxyURL.User.Username()
+	}
+
+	if deadline, ok := ctx.Deadline(); ok && !deadline.IsZero() {
+		conn.SetDeadline(deadline)
+		defer conn.SetDeadline(socksnoDeadline)
+	}
+	if ctx != context.Background() {
+		errCh := make(chan error, 1)
+		done := make(chan struct{})
+		defer func() {
+			close(done)
+			if ctxErr == nil {
+				ctxErr = <-errCh
+			}
+		}()
+		go func() {
+			select {
+			case <-ctx.Done():
+				conn.SetDeadline(socksaLongTimeAgo)
+				errCh <- ctx.Err()
+			case <-done:
+				errCh <- nil
+			}
+		}()
+	}
+
+	b := make([]byte, 0, 10+len(user)+len(host))
+	b = append(b, socks4Version, socks4CmdConnect, byte(port>>8), byte(port))
+	if ip != nil {
+		b = append(b, ip.To4()...)
+	} else {
+		// SOCKS4a: an invalid IP of 0.0.0.x means the name follows the
+		// user ID.
+		b = append(b, 0, 0, 0, 1)
+	}
+	b = append(b, user...)
+	b = append(b, 0)
+	if ip == nil {
+		b = append(b, host...)
+		b = append(b, 0)
+	}
+	if _, err := conn.Write(b); err != nil {
+		return opErr(err)
+	}
+
+	if _, err := io.ReadFull(conn, b[:8]); err != nil {
+		return opErr(err)
+	}
+	if b[0] != socks4ReplyVersion {
+		return opErr(errors.New("unexpected reply version " + strconv.Itoa(int(b[0]))))
+	}
+	switch b[1] {
+	case socks4StatusGranted:
+		return nil
+	case socks4StatusRejected:
+		return opErr(errors.New("request rejected or failed"))
+	case socks4StatusNoIdentd:
+		return opErr(errors.New("request rejected: proxy cannot reach identd on the client"))
+	case socks4StatusBadUser:
+		return opErr(errors.New("request rejected: identd reports a different user ID"))
+	default:
+		return opErr(errors.New("unknown reply " + strconv.Itoa(int(b[1]))))
+	}
+}
+
+// socksCmdUDPAssociate is the SOCKS5 command setting up a UDP relay.
+const socksCmdUDPAssociate socksCommand = 0x03
+
+// A SOCKS5PacketConn relays UDP datagrams through a SOCKS5 proxy, set up
+// with a UDP ASSOCIATE command by [Transport.DialSOCKS5UDP]. It
+// implements [net.PacketConn]: WriteTo sends a datagram to the given
+// address through the proxy's relay, and ReadFrom returns a datagram
+// relayed back with the address it came from, a [*net.UDPAddr], or, if
+// the proxy reports a host name, an address of network "socks".
+//
+// Fragmented datagrams are not supported; the relay is closed when the
+// proxy closes the TCP connection the association was made on.
+type SOCKS5PacketConn struct {
+	ctrl  net.Conn
+	udp   *net.UDPConn
+	relay *net.UDPAddr
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// DialSOCKS5UDP asks the SOCKS5 proxy proxyURL, of scheme "socks5" or
+// "socks5h" and authenticated with its userinfo, to relay UDP datagrams
+// for a new local UDP socket. The TCP connection to the proxy, which
+// carries the association, is dialed the way the Transport dials it for
+// requests, with its dialer and TCPProfile; the proxy's relay must be
+// reachable directly. Host names given to WriteTo are resolved by the
+// proxy.
+//
+// The caller must close the returned connection.
+func (t *Transport) DialSOCKS5UDP(ctx context.Context, proxyURL *url.URL) (*SOCKS5PacketConn, error) {
+	if proxyURL == nil || (proxyURL.Scheme != "socks5" && proxyURL.Scheme != "socks5h") {
+		return nil, errors.New("net/http: DialSOCKS5UDP needs a socks5 proxy URL")
+	}
+	ctrl, err := t.dial(ctx, "tcp", canonicalAddr(proxyURL))
+	if err != nil {
+		return nil, err
+	}
+
+	// Ask the proxy to relay for the address we send from, on the
+	// interface that reaches it.
+	var laddr *net.UDPAddr
+	if a, ok := ctrl.LocalAddr().(*net.TCPAddr); ok {
+		laddr = &net.UDPAddr{IP: a.IP, Zone: a.Zone}
+	}
+	udp, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	d := newSOCKS5Dialer(ctrl, proxyURL)
+	d.cmd = socksCmdUDPAssociate
+	bound, err := d.connect(ctx, ctrl, udp.LocalAddr().String())
+	if err != nil {
+		ctrl.Close()
+		udp.Close()
+		proxy, dst, _ := d.pathAddrs(udp.LocalAddr().String())
+		return nil, &net.OpError{Op: "socks udp associate", Net: "udp", Source: proxy, Addr: dst, Err: err}
+	}
+
+	// A relay on an unspecified address is on the proxy's own.
+	ba := bound.(*socksAddr)
+	relay := &net.UDPAddr{IP: ba.IP, Port: ba.Port}
+	if ba.IP == nil {
+		ips, err := net.DefaultResolver.LookupIPAddr(ctx, ba.Name)
+		if err != nil {
+			ctrl.Close()
+			udp.Close()
+			return nil, err
+		}
+		relay.IP, relay.Zone = ips[0].IP, ips[0].Zone
+	}
+	if relay.IP.IsUnspecified() {
+		if a, ok := ctrl.RemoteAddr().(*net.TCPAddr); ok {
+			relay.IP, relay.Zone = a.IP, a.Zone
+		}
+	}
+
+	c := &SOCKS5PacketConn{ctrl: ctrl, udp: udp, relay: relay}
+	go c.watch()
+	return c, nil
+}
+
+// watch closes c when the proxy ends the association by closing the
+// control connection.
+func (c *SOCKS5PacketConn) watch() {
+	io.Copy(io.Discard, c.ctrl)
+	c.Close()
+}
+
+// RelayAddr returns the address of the proxy's UDP relay.
+func (c *SOCKS5PacketConn) RelayAddr() net.Addr { return c.relay }
+
+// LocalAddr returns the local address datagrams are sent from.
+func (c *SOCKS5PacketConn) LocalAddr() net.Addr { return c.udp.LocalAddr() }
+
+// WriteTo sends p to addr through the proxy's relay.
+func (c *SOCKS5PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	host, port, err := sockssplitHostPort(addr.String())
+	if err != nil {
+		return 0, &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: err}
+	}
+	b := make([]byte, 0, 22+len(host)+len(p))
+	b = append(b, 0, 0, 0) // RSV, FRAG
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return 0, &net.OpError{Op: "write", Net: "udp", Addr: addr, Err: errors.New("FQDN too long")}
+		}
+		b = append(b, socksAddrTypeFQDN, byte(len(host)))
+		b = append(b, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		b = append(b, socksAddrTypeIPv4)
+		b = append(b, ip4...)
+	} else {
+		b = append(b, socksAddrTypeIPv6)
+		b = append(b, ip.To16()...)
+	}
+	b = append(b, byte(port>>8), byte(port))
+	b = append(b, p...)
+	if _, err := c.udp.WriteToUDP(b, c.relay); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// ReadFrom reads a datagram relayed by the proxy into p, returning its
+// length and where it came from. Datagrams from other senders than the
+// relay, malformed and fragmented ones are skipped.
+func (c *SOCKS5PacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	buf := make([]byte, 262+len(p))
+	for {
+		n, from, err := c.udp.ReadFromUDP(buf)
+		if err != nil {
+			return 0, nil, err
+		}
+		if from.Port != c.relay.Port || !from.IP.Equal(c.relay.IP) {
+			continue
+		}
+		b := buf[:n]
+		if len(b) < 4 || b[2] != 0 {
+			continue
+		}
+		var (
+			a   socksAddr
+			off int
+		)
+		switch b[3] {
+		case socksAddrTypeIPv4:
+			off = 4 + net.IPv4len
+		case socksAddrTypeIPv6:
+			off = 4 + net.IPv6len
+		case socksAddrTypeFQDN:
+			if len(b) < 5 {
+				continue
+			}
+			off = 5 + int(b[4])
+		default:
+			continue
+		}
+		if len(b) < off+2 {
+			continue
+		}
+		a.Port = int(b[off])<<8 | int(b[off+1])
+		if b[3] == socksAddrTypeFQDN {
+			a.Name = string(b[5:off])
+		} else {
+			a.IP = net.IP(append([]byte(nil), b[4:off]...))
+		}
+		n = copy(p, b[off+2:])
+		if a.IP != nil {
+			return n, &net.UDPAddr{IP: a.IP, Port: a.Port}, nil
+		}
+		return n, &a, nil
+	}
+}
+
+// Close ends the association and closes the local UDP socket.
+func (c *SOCKS5PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		c.closeErr = errors.Join(c.udp.Close(), c.ctrl.Close())
+	})
+	return c.closeErr
+}
+
+// SetDeadline sets the read and write deadlines of the UDP socket.
+func (c *SOCKS5PacketConn) SetDeadline(t time.Time) error { return c.udp.SetDeadline(t) }
+
+// SetReadDeadline sets the read deadline of the UDP socket.
+func (c *SOCKS5PacketConn) SetReadDeadline(t time.Time) error { return c.udp.SetReadDeadline(t) }
+
+// SetWriteDeadline sets the write deadline of the UDP socket.
+func (c *SOCKS5PacketConn) SetWriteDeadline(t time.Time) error { return c.udp.SetWriteDeadline(t) }
+
+var _ net.PacketConn = (*SOCKS5PacketConn)(nil)
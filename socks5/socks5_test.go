@@ -0,0 +1,135 @@
+package socks5_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	http "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/socks5"
+)
+
+// recorder collects the requests a Server is asked to allow.
+type recorder struct {
+	mu   sync.Mutex
+	reqs []socks5.Request
+}
+
+func (r *recorder) allow(deny string) func(context.Context, *socks5.Request) bool {
+	return func(ctx context.Context, req *socks5.Request) bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		r.reqs = append(r.reqs, *req)
+		return !strings.HasPrefix(req.Target, deny)
+	}
+}
+
+func (r *recorder) last() socks5.Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reqs[len(r.reqs)-1]
+}
+
+func TestConnect(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "origin "+r.Host)
+	}))
+	defer origin.Close()
+	originAddr := origin.Listener.Addr().String()
+
+	var rec recorder
+	ts := socks5.NewTestServer(&socks5.Server{
+		Credentials: func(u, p string) bool { return u == "user" && p == "secret" },
+		Allow:       rec.allow("forbidden.test:"),
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if strings.HasSuffix(addr, ".test:80") {
+				addr = originAddr // a fake name for the origin
+			}
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+		ErrorLog: log.New(io.Discard, "", 0),
+	})
+	defer ts.Close()
+
+	for _, scheme := range []string{"socks5", "socks5h"} {
+		tr := &http.Transport{Proxy: http.ProxyURL(ts.ProxyURL(scheme, "user", "secret"))}
+		c := &http.Client{Transport: tr}
+		resp, err := c.Get("http://example.test/")
+		if err != nil {
+			t.Fatalf("%s: %v", scheme, err)
+		}
+		b, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(b) != "origin example.test" {
+			t.Errorf("%s: body = %q, want origin example.test", scheme, b)
+		}
+		if got := rec.last(); got.Command != socks5.CmdConnect || got.Username != "user" || got.Target != "example.test:80" {
+			t.Errorf("%s: server saw %+v, want a connect by user to example.test:80", scheme, got)
+		}
+
+		if _, err := c.Get("http://forbidden.test/"); err == nil {
+			t.Errorf("%s: request to a refused target succeeded", scheme)
+		}
+		tr.CloseIdleConnections()
+	}
+
+	tr := &http.Transport{Proxy: http.ProxyURL(ts.ProxyURL("socks5", "user", "wrong"))}
+	if _, err := (&http.Client{Transport: tr}).Get(origin.URL); err == nil {
+		t.Error("request with a wrong password succeeded")
+	}
+}
+
+func TestUDPAssociate(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(append([]byte("echo "), buf[:n]...), from)
+		}
+	}()
+
+	var rec recorder
+	ts := socks5.NewTestServer(&socks5.Server{Allow: rec.allow("127.0.0.2:")})
+	defer ts.Close()
+
+	tr := &http.Transport{}
+	pc, err := tr.DialSOCKS5UDP(context.Background(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	pc.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// Datagrams to refused destinations are dropped.
+	if _, err := pc.WriteTo([]byte("dropped"), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 2), Port: 9}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pc.WriteTo([]byte("ping"), echo.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, from, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "echo ping" || from.String() != echo.LocalAddr().String() {
+		t.Errorf("got %q from %v, want echo ping from %v", buf[:n], from, echo.LocalAddr())
+	}
+	if got := rec.last(); got.Command != socks5.CmdUDPAssociate || got.Target != echo.LocalAddr().String() {
+		t.Errorf("server last saw %+v, want a datagram to %v", got, echo.LocalAddr())
+	}
+}
@@ -0,0 +1,176 @@
+package socks5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+)
+
+// maxDatagram is the largest datagram relayed.
+const maxDatagram = 64 << 10
+
+// A udpAssociation relays the datagrams of one UDP ASSOCIATE request.
+type udpAssociation struct {
+	s     *Server
+	ctx   context.Context
+	req   *Request
+	relay *net.UDPConn   // datagrams to and from the client
+	out   net.PacketConn // datagrams to and from destinations
+
+	mu      sync.Mutex
+	client  *net.UDPAddr // port 0 until the first datagram
+	allowed map[string]bool
+}
+
+// serveUDP answers the UDP ASSOCIATE request req on c and relays
+// datagrams for it until the client closes c.
+func (s *Server) serveUDP(ctx context.Context, c net.Conn, req *Request) error {
+	// The client sends its datagrams to the address it reached us on.
+	laddr := &net.UDPAddr{}
+	if a, ok := c.LocalAddr().(*net.TCPAddr); ok {
+		laddr.IP, laddr.Zone = a.IP, a.Zone
+	}
+	relay, err := net.ListenUDP("udp", laddr)
+	if err != nil {
+		writeReply(c, StatusGeneralFailure, nil)
+		return err
+	}
+	defer relay.Close()
+	listen := s.ListenPacket
+	if listen == nil {
+		listen = (&net.ListenConfig{}).ListenPacket
+	}
+	out, err := listen(ctx, "udp", ":0")
+	if err != nil {
+		writeReply(c, StatusGeneralFailure, nil)
+		return err
+	}
+	defer out.Close()
+
+	// Only datagrams from the address the client announced are relayed.
+	// An unspecified IP is the one of its TCP connection, and a zero port
+	// is the one of its first datagram.
+	client := &net.UDPAddr{}
+	if host, port, err := net.SplitHostPort(req.Target); err == nil {
+		client.IP = net.ParseIP(host)
+		client.Port, _ = strconv.Atoi(port)
+	}
+	if client.IP == nil || client.IP.IsUnspecified() {
+		if a, ok := c.RemoteAddr().(*net.TCPAddr); ok {
+			client.IP, client.Zone = a.IP, a.Zone
+		}
+	}
+
+	if err := writeReply(c, StatusSucceeded, relay.LocalAddr()); err != nil {
+		return err
+	}
+	a := &udpAssociation{s: s, ctx: ctx, req: req, relay: relay, out: out, client: client, allowed: make(map[string]bool)}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a.fromClient()
+	}()
+	go func() {
+		defer wg.Done()
+		a.toClient()
+	}()
+
+	// The association lasts as long as the control connection.
+	io.Copy(io.Discard, c)
+	relay.Close()
+	out.Close()
+	wg.Wait()
+	return nil
+}
+
+// fromClient sends the client's datagrams on to their destinations.
+// Fragmented and malformed datagrams are dropped.
+func (a *udpAssociation) fromClient() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := a.relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !a.fromClientAddr(from) {
+			continue
+		}
+		// RSV RSV FRAG ATYP DST.ADDR DST.PORT DATA
+		b := buf[:n]
+		if len(b) < 4 || b[2] != 0 {
+			continue
+		}
+		r := bytes.NewReader(b[4:])
+		dst, err := readAddr(r, b[3])
+		if err != nil || !a.allow(dst) {
+			continue
+		}
+		addr, err := net.ResolveUDPAddr("udp", dst)
+		if err != nil {
+			continue
+		}
+		a.out.WriteTo(b[n-r.Len():], addr)
+	}
+}
+
+// toClient relays the datagrams received from destinations to the
+// client.
+func (a *udpAssociation) toClient() {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, from, err := a.out.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		a.mu.Lock()
+		client := a.client
+		a.mu.Unlock()
+		if client.Port == 0 {
+			continue // nowhere to send it yet
+		}
+		b, err := appendAddr([]byte{0, 0, 0}, from.String())
+		if err != nil {
+			continue
+		}
+		a.relay.WriteToUDP(append(b, buf[:n]...), client)
+	}
+}
+
+// fromClientAddr reports whether from is the client's address, learning
+// its port from the first datagram if it was not announced.
+func (a *udpAssociation) fromClientAddr(from *net.UDPAddr) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !from.IP.Equal(a.client.IP) {
+		return false
+	}
+	if a.client.Port == 0 {
+		a.client = &net.UDPAddr{IP: a.client.IP, Port: from.Port, Zone: a.client.Zone}
+	}
+	return from.Port == a.client.Port
+}
+
+// allow reports whether datagrams may be sent to dst, asking Allow the
+// first time.
+func (a *udpAssociation) allow(dst string) bool {
+	if a.s.Allow == nil {
+		return true
+	}
+	a.mu.Lock()
+	ok, seen := a.allowed[dst]
+	a.mu.Unlock()
+	if seen {
+		return ok
+	}
+	req := *a.req
+	req.Target = dst
+	ok = a.s.Allow(a.ctx, &req)
+	a.mu.Lock()
+	a.allowed[dst] = ok
+	a.mu.Unlock()
+	return ok
+}
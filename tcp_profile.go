@@ -0,0 +1,91 @@
+package http
+
+import (
+	"context"
+	"net"
+	"syscall"
+)
+
+// A TCPProfile describes the TCP/IP characteristics of outgoing
+// connections, so that passive OS fingerprinting of the SYN (TTL, window
+// size, MSS) agrees with the parroted ClientHello. For example, a Windows
+// Chrome parrot would use a TTL of 128 rather than Linux's 64.
+//
+// Socket options are applied through a net.Dialer control hook before
+// the connection is established. They are only supported on Linux; on
+// other systems a profile that sets TTL, SendBuffer, ReceiveBuffer or MSS
+// makes every dial fail.
+type TCPProfile struct {
+	// TTL is the initial IP TTL (IPv4) or hop limit (IPv6) of
+	// outgoing packets. Zero keeps the system default.
+	TTL int
+
+	// SendBuffer and ReceiveBuffer set SO_SNDBUF and SO_RCVBUF. The
+	// receive buffer determines the window size and window scale
+	// advertised in the SYN. Zero keeps the system default. Linux
+	// doubles the values to allow for bookkeeping overhead.
+	SendBuffer    int
+	ReceiveBuffer int
+
+	// MSS clamps the maximum segment size (TCP_MAXSEG) advertised in
+	// the SYN. Zero keeps the default derived from the route MTU.
+	MSS int
+
+	// Nagle enables Nagle's algorithm by clearing TCP_NODELAY, which
+	// Go sets on every TCP connection by default.
+	Nagle bool
+
+	// KeepAlive configures TCP keep-alive probes as in
+	// net.Dialer.KeepAliveConfig. If KeepAlive.Enable is false, a
+	// negative KeepAlive.Idle disables keep-alive probes and any other
+	// value keeps Go's default.
+	KeepAlive net.KeepAliveConfig
+}
+
+// DialContextFunc returns a dial function, suitable for
+// Transport.DialContext, that dials with a copy of d configured to apply
+// p. If d is nil, a zero net.Dialer is used. A Control or ControlContext
+// hook already set on d runs before p's socket options are applied.
+//
+// Transport.TCPProfile uses this when the Transport dials by itself; use
+// DialContextFunc to combine a profile with a custom net.Dialer.
+func (p *TCPProfile) DialContextFunc(d *net.Dialer) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var d2 net.Dialer
+	if d != nil {
+		d2 = *d
+	}
+	if p.KeepAlive.Enable {
+		d2.KeepAliveConfig = p.KeepAlive
+	} else if p.KeepAlive.Idle < 0 {
+		d2.KeepAlive = -1
+	}
+	prev, prevCtx := d2.Control, d2.ControlContext
+	d2.Control = nil
+	d2.ControlContext = func(ctx context.Context, network, address string, c syscall.RawConn) error {
+		var err error
+		if prevCtx != nil {
+			err = prevCtx(ctx, network, address, c)
+		} else if prev != nil {
+			err = prev(network, address, c)
+		}
+		if err != nil {
+			return err
+		}
+		return p.control(network, c)
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		c, err := d2.DialContext(ctx, network, addr)
+		if err != nil {
+			return nil, err
+		}
+		if tc, ok := c.(*net.TCPConn); ok && p.Nagle {
+			// TCP_NODELAY is set by the net package once the connection
+			// is established, so it cannot be cleared by the control hook.
+			if err := tc.SetNoDelay(false); err != nil {
+				c.Close()
+				return nil, err
+			}
+		}
+		return c, nil
+	}
+}
@@ -0,0 +1,138 @@
+package http_test
+
+import (
+	"context"
+	"io"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/dteh/dhttp"
+	"github.com/dteh/dhttp/httptest"
+	"github.com/dteh/dhttp/httptrace"
+)
+
+func getsockopt(t *testing.T, c net.Conn, level, name int) int {
+	t.Helper()
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v int
+	var serr error
+	if err := rc.Control(func(fd uintptr) {
+		v, serr = syscall.GetsockoptInt(int(fd), level, name)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if serr != nil {
+		t.Fatal(serr)
+	}
+	return v
+}
+
+var testTCPProfile = &TCPProfile{
+	TTL:           128,
+	SendBuffer:    64 << 10,
+	ReceiveBuffer: 64 << 10,
+	MSS:           1200,
+	Nagle:         true,
+	KeepAlive:     net.KeepAliveConfig{Enable: true, Idle: 45 * time.Second, Interval: 5 * time.Second, Count: 3},
+}
+
+func checkTCPProfile(t *testing.T, c net.Conn, p *TCPProfile) {
+	t.Helper()
+	if got := getsockopt(t, c, syscall.IPPROTO_IP, syscall.IP_TTL); got != p.TTL {
+		t.Errorf("IP_TTL = %d, want %d", got, p.TTL)
+	}
+	// Linux doubles the requested buffer sizes.
+	if got := getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_SNDBUF); got != 2*p.SendBuffer {
+		t.Errorf("SO_SNDBUF = %d, want %d", got, 2*p.SendBuffer)
+	}
+	if got := getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_RCVBUF); got != 2*p.ReceiveBuffer {
+		t.Errorf("SO_RCVBUF = %d, want %d", got, 2*p.ReceiveBuffer)
+	}
+	if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_MAXSEG); got > p.MSS {
+		t.Errorf("TCP_MAXSEG = %d, want at most %d", got, p.MSS)
+	}
+	if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_NODELAY); got != 0 {
+		t.Errorf("TCP_NODELAY = %d, want 0", got)
+	}
+	if got := getsockopt(t, c, syscall.SOL_SOCKET, syscall.SO_KEEPALIVE); got != 1 {
+		t.Errorf("SO_KEEPALIVE = %d, want 1", got)
+	}
+	if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE); got != 45 {
+		t.Errorf("TCP_KEEPIDLE = %d, want 45", got)
+	}
+	if got := getsockopt(t, c, syscall.IPPROTO_TCP, syscall.TCP_KEEPCNT); got != 3 {
+		t.Errorf("TCP_KEEPCNT = %d, want 3", got)
+	}
+}
+
+func TestTCPProfileDialContextFunc(t *testing.T) {
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		c, err := ln.Accept()
+		if err != nil {
+			close(accepted)
+			return
+		}
+		accepted <- c
+	}()
+
+	controlCalled := false
+	d := &net.Dialer{Control: func(string, string, syscall.RawConn) error {
+		controlCalled = true
+		return nil
+	}}
+	c, err := testTCPProfile.DialContextFunc(d)(context.Background(), "tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	checkTCPProfile(t, c, testTCPProfile)
+	if !controlCalled {
+		t.Error("net.Dialer.Control was not called")
+	}
+
+	// The clamp is advertised in the SYN, so the listener's side of the
+	// connection never sends segments larger than MSS either.
+	sc, ok := <-accepted
+	if !ok {
+		t.Fatal("Accept failed")
+	}
+	defer sc.Close()
+	if got := getsockopt(t, sc, syscall.IPPROTO_TCP, syscall.TCP_MAXSEG); got > testTCPProfile.MSS {
+		t.Errorf("listener TCP_MAXSEG = %d, want at most %d", got, testTCPProfile.MSS)
+	}
+}
+
+func TestTransportTCPProfile(t *testing.T) {
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+
+	var conn net.Conn
+	trace := &httptrace.ClientTrace{
+		GotConn: func(i httptrace.GotConnInfo) { conn = i.Conn },
+	}
+	req, _ := NewRequest("GET", ts.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+	tr := &Transport{TCPProfile: testTCPProfile}
+	defer tr.CloseIdleConnections()
+	resp, err := tr.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if conn == nil {
+		t.Fatal("GotConn not called")
+	}
+	checkTCPProfile(t, conn, testTCPProfile)
+}
@@ -0,0 +1,177 @@
+package http
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+
+	tls "github.com/refraction-networking/utls"
+)
+
+// withPSKExtension returns a copy of exts whose last extension is a fresh
+// pre_shared_key extension, so that utls can offer a cached TLS 1.3 session.
+// RFC 8446 requires pre_shared_key to be the last extension, so any PSK
+// extension already in the spec is dropped and re-added at the end; the
+// relative order of every other extension is preserved. utls leaves the
+// extension off the wire when there is no session to offer, so a first
+// connection still looks like the unmodified parrot.
+//
+// A spec without psk_key_exchange_modes is returned unchanged: servers must
+// abort a handshake that offers a PSK without it.
+func withPSKExtension(exts []tls.TLSExtension) []tls.TLSExtension {
+	hasModes := false
+	for _, e := range exts {
+		if _, ok := e.(*tls.PSKKeyExchangeModesExtension); ok {
+			hasModes = true
+			break
+		}
+	}
+	if !hasModes {
+		return exts
+	}
+	out := make([]tls.TLSExtension, 0, len(exts)+1)
+	for _, e := range exts {
+		if _, ok := e.(tls.PreSharedKeyExtension); ok {
+			continue
+		}
+		out = append(out, e)
+	}
+	return append(out, &tls.UtlsPreSharedKeyExtension{})
+}
+
+// cloneHelloSpec returns a copy of spec for use by a single connection.
+// utls extensions record per-connection state while a ClientHello is built
+// (generated key shares, GREASE ECH payloads, PSK binders), so applying
+// ClientHelloSettings.Override directly would leak the first connection's
+// state into every later handshake of the Transport.
+//
+// Each extension is copied one level deep: the struct itself and any
+// exported slice fields, which is where utls writes that state.
+func cloneHelloSpec(spec tls.ClientHelloSpec) tls.ClientHelloSpec {
+	spec.CipherSuites = slices.Clone(spec.CipherSuites)
+	spec.CompressionMethods = slices.Clone(spec.CompressionMethods)
+	exts := make([]tls.TLSExtension, len(spec.Extensions))
+	for i, e := range spec.Extensions {
+		exts[i] = cloneExtension(e)
+	}
+	spec.Extensions = exts
+	return spec
+}
+
+func cloneExtension(e tls.TLSExtension) tls.TLSExtension {
+	v := reflect.ValueOf(e)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return e
+	}
+	c := reflect.New(v.Elem().Type())
+	c.Elem().Set(v.Elem())
+	for i := 0; i < c.Elem().NumField(); i++ {
+		f := c.Elem().Field(i)
+		if f.Kind() != reflect.Slice || f.IsNil() || !f.CanSet() {
+			continue
+		}
+		s := reflect.MakeSlice(f.Type(), f.Len(), f.Len())
+		reflect.Copy(s, f)
+		f.Set(s)
+	}
+	return c.Interface().(tls.TLSExtension)
+}
+
+// FileSessionCache is a [tls.ClientSessionCache] that stores each TLS
+// session in its own file, so that session resumption survives process
+// restarts. Set it as [Transport.TLSSessionCache].
+//
+// The files contain session secrets and are created with mode 0600.
+type FileSessionCache struct {
+	dir string
+	mu  sync.Mutex // serialises writes and removals
+}
+
+// NewFileSessionCache returns a FileSessionCache that keeps its sessions in
+// dir, creating the directory if it does not exist.
+func NewFileSessionCache(dir string) (*FileSessionCache, error) {
+	if dir == "" {
+		return nil, errors.New("http: empty FileSessionCache directory")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &FileSessionCache{dir: dir}, nil
+}
+
+func (c *FileSessionCache) path(sessionKey string) string {
+	sum := sha256.Sum256([]byte(sessionKey))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".session")
+}
+
+// Get implements [tls.ClientSessionCache]. Files that cannot be read or
+// parsed are treated as a cache miss.
+func (c *FileSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	b, err := os.ReadFile(c.path(sessionKey))
+	if err != nil || len(b) < 4 {
+		return nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(n) > uint64(len(b)-4) {
+		return nil, false
+	}
+	ticket, rest := b[4:4+n], b[4+n:]
+	state, err := tls.ParseSessionState(rest)
+	if err != nil {
+		return nil, false
+	}
+	cs, err := tls.NewResumptionState(ticket, state)
+	if err != nil {
+		return nil, false
+	}
+	return cs, true
+}
+
+// Put implements [tls.ClientSessionCache]. A nil cs removes the entry.
+// Errors are dropped: a session that fails to persist is simply not
+// resumed later.
+func (c *FileSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	p := c.path(sessionKey)
+	if cs == nil {
+		os.Remove(p)
+		return
+	}
+	ticket, state, err := cs.ResumptionState()
+	if err != nil || state == nil {
+		return
+	}
+	sb, err := state.Bytes()
+	if err != nil {
+		return
+	}
+	b := make([]byte, 4, 4+len(ticket)+len(sb))
+	binary.BigEndian.PutUint32(b, uint32(len(ticket)))
+	b = append(b, ticket...)
+	b = append(b, sb...)
+
+	// Write to a temporary file and rename it into place so that a
+	// concurrent Get never sees a partial session.
+	f, err := os.CreateTemp(c.dir, ".session-*")
+	if err != nil {
+		return
+	}
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return
+	}
+	if err := os.Rename(f.Name(), p); err != nil {
+		os.Remove(f.Name())
+	}
+}
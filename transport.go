@@ -129,13 +129,49 @@ type Transport struct {
 	// "http" is assumed.
 	// "socks5" is treated the same as "socks5h".
 	//
+	// [dhttp] "socks4" and "socks4a" are supported too. A "socks4" proxy
+	// is given the target's IPv4 address, resolved locally, and a
+	// "socks4a" proxy its host name. The user ID sent is the URL's
+	// username.
+	//
 	// If the proxy URL contains a userinfo subcomponent,
 	// the proxy request will pass the username and password
 	// in a Proxy-Authorization header.
 	//
 	// If Proxy is nil or returns a nil *URL, no proxy is used.
+	//
+	// [dhttp] For https requests, Proxy is called before a RoundTripper
+	// registered for "https" with RegisterProtocol sees the request, and
+	// cached HTTP/2 connections are only reused by requests through the
+	// same proxy.
 	Proxy func(*Request) (*url.URL, error)
 
+	// [dhttp] ProxyChain, if non-nil, returns proxies to go through, in
+	// order, before the one returned by Proxy; if Proxy returns nil, the
+	// last proxy of the chain takes its place. Each "http", "https",
+	// "socks4", "socks4a", "socks5" or "socks5h" hop is asked to tunnel
+	// to the next one with a CONNECT request or a SOCKS handshake,
+	// authenticated with the hop URL's userinfo and, for CONNECT,
+	// carrying the header of GetProxyConnectHeader or ProxyConnectHeader.
+	// OnProxyConnectResponse is called for every hop. DialTLS and
+	// DialTLSContext are not used for chained connections.
+	ProxyChain func(*Request) ([]*url.URL, error)
+
+	// [dhttp] ProxyAuthenticator, if non-nil, authenticates requests
+	// with proxies in place of the Basic credentials from proxy URLs'
+	// userinfo, answering 407 challenges to CONNECT requests and to
+	// requests forwarded by "http" and "https" proxies. A forwarded
+	// request with a body is only sent again if GetBody is set.
+	ProxyAuthenticator ProxyAuthenticator
+
+	// [dhttp] ProxyProtocolHeader, if non-nil, returns the PROXY protocol
+	// header to write on each new connection, right after it is dialed
+	// and before any proxy handshake or TLS. A nil header writes none;
+	// one without addresses describes conn itself. Connections are
+	// pooled, so a header holds for every request sent on it. It is not
+	// used for connections from DialTLS and DialTLSContext.
+	ProxyProtocolHeader func(ctx context.Context, conn net.Conn) (*ProxyHeader, error)
+
 	// OnProxyConnectResponse is called when the Transport gets an HTTP response from
 	// a proxy for a CONNECT request. It's called before the check for a 200 OK response.
 	// If it returns an error, the request fails with that error.
@@ -322,6 +358,71 @@ type Transport struct {
 	// [dhttp] ClientHelloID is the UTLS ClientHelloID to use for parroting handshakes.
 	// If this is unset, the default ClientHelloID will be used (HelloChrome_Auto).
 	ClientHelloSettings ClientHelloSettings
+
+	// [dhttp] ProxyClientHelloSettings, if non-nil, is parroted in TLS
+	// handshakes with "https" proxies, including the hops of ProxyChain,
+	// in place of the ClientHelloSettings of the connection. Handshakes
+	// with a proxy that is asked to CONNECT offer only HTTP/1.1 in ALPN,
+	// since the CONNECT request is sent over HTTP/1.1.
+	ProxyClientHelloSettings *ClientHelloSettings
+
+	// [dhttp] TLSSessionCache, if non-nil, enables TLS session resumption
+	// for connections dialed by the Transport and takes precedence over
+	// TLSClientConfig.ClientSessionCache. When a cached TLS 1.3 session
+	// is available, a pre_shared_key extension is appended as the last
+	// extension of the parroted ClientHello, so the parrot's extension
+	// order is otherwise unchanged.
+	//
+	// Use tls.NewLRUClientSessionCache for an in-memory store, or
+	// NewFileSessionCache to keep sessions across process restarts.
+	// The httptrace.ClientTrace.TLSSessionResumption hook reports
+	// whether each handshake offered and resumed a session.
+	TLSSessionCache tls.ClientSessionCache
+
+	// [dhttp] Fingerprints, if non-nil, overrides ClientHelloSettings with
+	// a fingerprint chosen per host or per session by the rotation. The
+	// chosen fingerprint also supplies default header orders and HTTP/2
+	// settings, and its name is reported in Response.Fingerprint.
+	Fingerprints *FingerprintRotation
+
+	// [dhttp] TCPProfile, if non-nil, sets the TTL, socket buffers, MSS,
+	// Nagle and keep-alive behaviour of connections the Transport dials.
+	// It is ignored when DialContext or Dial is set; wrap a custom
+	// net.Dialer with TCPProfile.DialContextFunc instead.
+	TCPProfile *TCPProfile
+
+	// [dhttp] TraceDNS, if true, makes the Transport's own dialer
+	// resolve host names itself while a request's httptrace.ClientTrace
+	// has DNS or connect hooks, so that DNSStart and DNSDone are called
+	// and ConnectStart and ConnectDone are called for each address. The
+	// addresses are then dialed one after another, without net.Dialer's
+	// Happy Eyeballs racing, dual-stack fallback and per-address
+	// timeouts. Otherwise the dial is left to net.Dialer, ConnectStart
+	// and ConnectDone are called once around it with the address as
+	// requested, and the DNS hooks are not called. TraceDNS is ignored
+	// when DialContext or Dial is set.
+	TraceDNS bool
+
+	// [dhttp] BrowserHeaders, if non-nil, fills in the User-Agent, client
+	// hint, Accept and Accept-Language headers and the header order of
+	// the browser parroted by each request's ClientHelloID, where the
+	// request does not set them itself. Client hints are only added to
+	// https requests. A BrowserHeaders that cannot describe the
+	// browser, such as one with an unknown Platform, fails the request.
+	BrowserHeaders *BrowserHeaders
+
+	// fingerprintConns maps a connection being handed to TLSNextProto to
+	// its *fingerprintPin, for the HTTP/2 connection pool.
+	fingerprintConns sync.Map
+
+	// proxyConns maps a connection being handed to TLSNextProto to the
+	// proxy part of its connection method key, for the HTTP/2
+	// connection pool.
+	proxyConns sync.Map
+
+	// connInfos maps a connection being handed to HTTP/2 to its
+	// *ConnInfo, for Response.Conn.
+	connInfos sync.Map
 }
 
 func (t *Transport) writeBufferSize() int {
@@ -349,27 +450,37 @@ func (t *Transport) maxHeaderResponseSize() int64 {
 func (t *Transport) Clone() *Transport {
 	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
 	t2 := &Transport{
-		Proxy:                  t.Proxy,
-		OnProxyConnectResponse: t.OnProxyConnectResponse,
-		DialContext:            t.DialContext,
-		Dial:                   t.Dial,
-		DialTLS:                t.DialTLS,
-		DialTLSContext:         t.DialTLSContext,
-		TLSHandshakeTimeout:    t.TLSHandshakeTimeout,
-		DisableKeepAlives:      t.DisableKeepAlives,
-		DisableCompression:     t.DisableCompression,
-		MaxIdleConns:           t.MaxIdleConns,
-		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
-		MaxConnsPerHost:        t.MaxConnsPerHost,
-		IdleConnTimeout:        t.IdleConnTimeout,
-		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
-		ExpectContinueTimeout:  t.ExpectContinueTimeout,
-		ProxyConnectHeader:     t.ProxyConnectHeader.Clone(),
-		GetProxyConnectHeader:  t.GetProxyConnectHeader,
-		MaxResponseHeaderBytes: t.MaxResponseHeaderBytes,
-		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
-		WriteBufferSize:        t.WriteBufferSize,
-		ReadBufferSize:         t.ReadBufferSize,
+		Proxy:                    t.Proxy,
+		ProxyChain:               t.ProxyChain,
+		ProxyAuthenticator:       t.ProxyAuthenticator,
+		ProxyProtocolHeader:      t.ProxyProtocolHeader,
+		OnProxyConnectResponse:   t.OnProxyConnectResponse,
+		DialContext:              t.DialContext,
+		Dial:                     t.Dial,
+		DialTLS:                  t.DialTLS,
+		DialTLSContext:           t.DialTLSContext,
+		TLSHandshakeTimeout:      t.TLSHandshakeTimeout,
+		DisableKeepAlives:        t.DisableKeepAlives,
+		DisableCompression:       t.DisableCompression,
+		MaxIdleConns:             t.MaxIdleConns,
+		MaxIdleConnsPerHost:      t.MaxIdleConnsPerHost,
+		MaxConnsPerHost:          t.MaxConnsPerHost,
+		IdleConnTimeout:          t.IdleConnTimeout,
+		ResponseHeaderTimeout:    t.ResponseHeaderTimeout,
+		ExpectContinueTimeout:    t.ExpectContinueTimeout,
+		ProxyConnectHeader:       t.ProxyConnectHeader.Clone(),
+		GetProxyConnectHeader:    t.GetProxyConnectHeader,
+		MaxResponseHeaderBytes:   t.MaxResponseHeaderBytes,
+		ForceAttemptHTTP2:        t.ForceAttemptHTTP2,
+		WriteBufferSize:          t.WriteBufferSize,
+		ReadBufferSize:           t.ReadBufferSize,
+		ClientHelloSettings:      t.ClientHelloSettings,
+		ProxyClientHelloSettings: t.ProxyClientHelloSettings,
+		TLSSessionCache:          t.TLSSessionCache,
+		Fingerprints:             t.Fingerprints,
+		TCPProfile:               t.TCPProfile,
+		TraceDNS:                 t.TraceDNS,
+		BrowserHeaders:           t.BrowserHeaders,
 	}
 	if t.TLSClientConfig != nil {
 		t2.TLSClientConfig = t.TLSClientConfig.Clone()
@@ -605,6 +716,10 @@ func validateHeaders(hdrs Header) string {
 // roundTrip implements a RoundTripper over HTTP.
 func (t *Transport) roundTrip(req *Request) (_ *Response, err error) {
 	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+	// [dhttp] Answer the challenges of forwarding proxies.
+	if t.ProxyAuthenticator != nil && req.Context().Value(proxyAuthContextKey{}) == nil {
+		return t.roundTripProxyAuth(req)
+	}
 	ctx := req.Context()
 	trace := httptrace.ContextClientTrace(ctx)
 
@@ -635,6 +750,20 @@ func (t *Transport) roundTrip(req *Request) (_ *Response, err error) {
 	origReq := req
 	req = setupRewindBody(req)
 
+	// [dhttp] Resolve the proxies before an alternate protocol sees the
+	// request, so that a cached HTTP/2 connection is only used if it goes
+	// through the same proxies. The first attempt below uses them too.
+	var proxies *connectMethod
+	if scheme == "https" && (t.Proxy != nil || t.ProxyChain != nil) && t.useRegisteredProtocol(req) {
+		cm := connectMethod{targetScheme: scheme, targetAddr: canonicalAddr(req.URL)}
+		if cm.proxyURL, cm.proxyChain, err = t.proxiesForRequest(req); err != nil {
+			req.closeBody()
+			return nil, err
+		}
+		proxies = &cm
+		req = cm.withHTTP2Proxy(req)
+	}
+
 	if altRT := t.alternateRoundTripper(req); altRT != nil {
 		if resp, err := altRT.RoundTrip(req); err != ErrSkipAltProtocol {
 			return resp, err
@@ -696,11 +825,23 @@ func (t *Transport) roundTrip(req *Request) (_ *Response, err error) {
 
 		// treq gets modified by roundTrip, so we need to recreate for each retry.
 		treq := &transportRequest{Request: req, trace: trace, ctx: ctx, cancel: cancel}
-		cm, err := t.connectMethodForRequest(treq)
+		cm, err := t.connectMethodForRequest(treq, proxies)
+		proxies = nil
+		if err != nil {
+			req.closeBody()
+			return nil, err
+		}
+		treq.Request, err = t.withBrowserHeaders(cm.withHTTP2Proxy(cm.withFingerprint(req)), &cm)
 		if err != nil {
 			req.closeBody()
 			return nil, err
 		}
+		if t.ProxyAuthenticator != nil { // [dhttp]
+			if err := t.addProxyAuthorization(treq, &cm); err != nil {
+				req.closeBody()
+				return nil, err
+			}
+		}
 
 		// Get the cached or newly-created connection to either the
 		// host (for http or https), the http proxy, or the http proxy
@@ -715,7 +856,7 @@ func (t *Transport) roundTrip(req *Request) (_ *Response, err error) {
 		var resp *Response
 		if pconn.alt != nil {
 			// HTTP/2 path.
-			resp, err = pconn.alt.RoundTrip(req)
+			resp, err = pconn.alt.RoundTrip(treq.Request)
 		} else {
 			resp, err = pconn.roundTrip(treq)
 		}
@@ -729,6 +870,12 @@ func (t *Transport) roundTrip(req *Request) (_ *Response, err error) {
 				cancel(errRequestDone)
 			}
 			resp.Request = origReq
+			if cm.fingerprint != nil {
+				resp.Fingerprint = cm.fingerprint.fp.Name
+			}
+			if resp.Conn == nil { // [dhttp] HTTP/2 sets it per connection
+				resp.Conn = pconn.connInfo
+			}
 			return resp, nil
 		}
 
@@ -997,13 +1144,19 @@ func resetProxyConfig() {
 	envProxyFuncValue = nil
 }
 
-func (t *Transport) connectMethodForRequest(treq *transportRequest) (cm connectMethod, err error) {
+func (t *Transport) connectMethodForRequest(treq *transportRequest, proxies *connectMethod) (cm connectMethod, err error) {
 	cm.targetScheme = treq.URL.Scheme
 	cm.targetAddr = canonicalAddr(treq.URL)
-	if t.Proxy != nil {
-		cm.proxyURL, err = t.Proxy(treq.Request)
+	// [dhttp] Proxy chains, unless roundTrip has already resolved them.
+	if proxies != nil {
+		cm.proxyURL, cm.proxyChain = proxies.proxyURL, proxies.proxyChain
+	} else {
+		cm.proxyURL, cm.proxyChain, err = t.proxiesForRequest(treq.Request)
 	}
 	cm.onlyH1 = treq.requiresHTTP1()
+	if err == nil && t.Fingerprints != nil {
+		cm.fingerprint, err = t.Fingerprints.pick(treq.ctx, cm.key())
+	}
 	return cm, err
 }
 
@@ -1333,7 +1486,12 @@ func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, e
 		}
 		return c, err
 	}
-	return zeroDialer.DialContext(ctx, network, addr)
+	// [dhttp] Report DNS and connect events to httptrace; see dialTraced.
+	dial := zeroDialer.DialContext
+	if t.TCPProfile != nil {
+		dial = t.TCPProfile.DialContextFunc(&zeroDialer)
+	}
+	return dialTraced(ctx, dial, network, addr, t.TraceDNS)
 }
 
 // A wantConn records state about a wanted connection
@@ -1728,31 +1886,71 @@ func (t *Transport) decConnsPerHost(key connectMethodKey) {
 // tunnel, this function establishes a nested TLS session inside the encrypted channel.
 // The remote endpoint's name may be overridden by TLSClientConfig.ServerName.
 func (pconn *persistConn) addTLS(ctx context.Context, name string, trace *httptrace.ClientTrace) error {
+	return pconn.addTLSHello(ctx, name, trace, pconn.clientHelloSettings, pconn.raw)
+}
+
+// [dhttp] addTLSHello is addTLS parroting hello. If onlyH1 is set, h2 is
+// left out of the ALPN list.
+func (pconn *persistConn) addTLSHello(ctx context.Context, name string, trace *httptrace.ClientTrace, hello ClientHelloSettings, onlyH1 bool) error {
 	// Initiate TLS and check remote host name against certificate.
 	cfg := cloneTLSConfig(pconn.t.TLSClientConfig)
 	if cfg.ServerName == "" {
 		cfg.ServerName = name
 	}
 
-	if pconn.cacheKey.onlyH1 {
+	if pconn.cacheKey.onlyH1 || onlyH1 {
 		cfg.NextProtos = nil
 	}
+	// [dhttp] Session resumption. A parrot without a session ticket
+	// extension must skip TLS 1.2 resumption rather than panic in utls,
+	// and the PSK extension is left off the wire until there is a session.
+	if c := pconn.t.TLSSessionCache; c != nil {
+		cfg.ClientSessionCache = c
+	}
+	resume := cfg.ClientSessionCache != nil && !cfg.SessionTicketsDisabled
+	if resume {
+		cfg.PreferSkipResumptionOnNilExtension = true
+		cfg.OmitEmptyPsk = true
+	}
 	plainConn := pconn.conn
 
 	// [dhttp] UTLS parroting
 	// If no HelloID is provided, Chrome_Auto is used
 	// If HelloCustom is used, the override is applied
-	chs := pconn.clientHelloSettings
+	chs := hello
 	if chs.HelloID.Client == "" {
 		chs.HelloID = tls.HelloChrome_Auto
 	}
+	if chs.HelloID == tls.HelloCustom {
+		chs.Override = cloneHelloSpec(chs.Override)
+	}
 
 	// If transport.TLSNextProto is nil (ie, h2 is disabled) then we use a custom spec
 	// to disable ALPN and NPN negotiation as the client will interpret h2 as h1
-	if len(pconn.t.TLSNextProto) == 0 {
-		chs.Override, _ = tls.UTLSIdToSpec(chs.HelloID)
-		chs.HelloID = tls.HelloCustom
-		removeH2FromParrotSpec(&chs.Override)
+	if len(pconn.t.TLSNextProto) == 0 || onlyH1 {
+		if chs.HelloID != tls.HelloCustom {
+			if spec, err := tls.UTLSIdToSpec(chs.HelloID); err == nil {
+				chs.Override = spec
+				chs.HelloID = tls.HelloCustom
+			} else {
+				// Randomized parrots have no fixed spec and take
+				// their ALPN list from NextProtos instead.
+				cfg.NextProtos = []string{"http/1.1"}
+			}
+		}
+		if chs.HelloID == tls.HelloCustom {
+			removeH2FromParrotSpec(&chs.Override)
+		}
+	}
+
+	if resume && chs.HelloID != tls.HelloCustom {
+		if spec, err := tls.UTLSIdToSpec(chs.HelloID); err == nil {
+			chs.Override = spec
+			chs.HelloID = tls.HelloCustom
+		}
+	}
+	if resume && chs.HelloID == tls.HelloCustom {
+		chs.Override.Extensions = withPSKExtension(chs.Override.Extensions)
 	}
 
 	tlsConn := tls.UClient(plainConn, cfg, chs.HelloID)
@@ -1796,6 +1994,13 @@ func (pconn *persistConn) addTLS(ctx context.Context, name string, trace *httptr
 	if trace != nil && trace.TLSHandshakeDone != nil {
 		trace.TLSHandshakeDone(cs, nil)
 	}
+	if resume && trace != nil && trace.TLSSessionResumption != nil {
+		trace.TLSSessionResumption(httptrace.TLSSessionResumptionInfo{
+			ServerName: cfg.ServerName,
+			Offered:    tlsConn.HandshakeState.Session != nil,
+			Resumed:    cs.DidResume,
+		})
+	}
 	pconn.tlsState = &cs
 	pconn.conn = tlsConn
 	return nil
@@ -1838,6 +2043,11 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 		isClientConn:        isClientConn,
 		internalStateHook:   internalStateHook,
 		clientHelloSettings: t.ClientHelloSettings,
+		raw:                 cm.raw,
+	}
+	if p := cm.fingerprint; p != nil {
+		pconn.clientHelloSettings = p.fp.ClientHello
+		p.countDial()
 	}
 	trace := httptrace.ContextClientTrace(ctx)
 	wrapErr := func(err error) error {
@@ -1847,7 +2057,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 		}
 		return err
 	}
-	if cm.scheme() == "https" && t.hasCustomTLSDialer() {
+	if cm.scheme() == "https" && t.hasCustomTLSDialer() && cm.proxyChain == nil {
 		var err error
 		pconn.conn, err = t.customDialTLS(ctx, "tcp", cm.addr())
 		if err != nil {
@@ -1873,7 +2083,8 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			pconn.tlsState = &cs
 		}
 	} else {
-		conn, err := t.dial(ctx, "tcp", cm.addr())
+		// [dhttp] Tunnel through a proxy chain to its last proxy.
+		conn, err := t.dialProxyChain(ctx, pconn, cm, trace)
 		if err != nil {
 			return nil, wrapErr(err)
 		}
@@ -1883,120 +2094,40 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			if firstTLSHost, _, err = net.SplitHostPort(cm.addr()); err != nil {
 				return nil, wrapErr(err)
 			}
-			if err = pconn.addTLS(ctx, firstTLSHost, trace); err != nil {
+			// [dhttp] The TLS hop to an "https" proxy has its own ClientHello.
+			if cm.proxyURL != nil {
+				err = pconn.addProxyTLS(ctx, firstTLSHost, trace, cm.targetScheme == "https")
+			} else {
+				err = pconn.addTLS(ctx, firstTLSHost, trace)
+			}
+			if err != nil {
 				return nil, wrapErr(err)
 			}
 		}
 	}
 
 	// Proxy setup.
+	var connectResp *Response // [dhttp] for Response.Conn
 	switch {
 	case cm.proxyURL == nil:
 		// Do nothing. Not using a proxy.
-	case cm.proxyURL.Scheme == "socks5" || cm.proxyURL.Scheme == "socks5h":
-		conn := pconn.conn
-		d := socksNewDialer("tcp", conn.RemoteAddr().String())
-		if u := cm.proxyURL.User; u != nil {
-			auth := &socksUsernamePassword{
-				Username: u.Username(),
-			}
-			auth.Password, _ = u.Password()
-			d.AuthMethods = []socksAuthMethod{
-				socksAuthMethodNotRequired,
-				socksAuthMethodUsernamePassword,
-			}
-			d.Authenticate = auth.Authenticate
-		}
-		if _, err := d.DialWithConn(ctx, conn, "tcp", cm.targetAddr); err != nil {
-			conn.Close()
+	case isSOCKSProxy(cm.proxyURL.Scheme):
+		// [dhttp] Shared with the hops of proxy chains; SOCKS4 too.
+		if _, err := t.proxyTunnel(ctx, pconn.conn, cm.proxyURL, cm.targetAddr); err != nil {
 			return nil, err
 		}
 	case cm.targetScheme == "http":
 		pconn.isProxy = true
-		if pa := cm.proxyAuth(); pa != "" {
+		if pa := cm.proxyAuth(); pa != "" && t.ProxyAuthenticator == nil { // [dhttp]
 			pconn.mutateHeaderFunc = func(h Header) {
 				h.Set("Proxy-Authorization", pa)
 			}
 		}
 	case cm.targetScheme == "https":
-		conn := pconn.conn
-		var hdr Header
-		if t.GetProxyConnectHeader != nil {
-			var err error
-			hdr, err = t.GetProxyConnectHeader(ctx, cm.proxyURL, cm.targetAddr)
-			if err != nil {
-				conn.Close()
-				return nil, err
-			}
-		} else {
-			hdr = t.ProxyConnectHeader
-		}
-		if hdr == nil {
-			hdr = make(Header)
-		}
-		if pa := cm.proxyAuth(); pa != "" {
-			hdr = hdr.Clone()
-			hdr.Set("Proxy-Authorization", pa)
-		}
-		connectReq := &Request{
-			Method: "CONNECT",
-			URL:    &url.URL{Opaque: cm.targetAddr},
-			Host:   cm.targetAddr,
-			Header: hdr,
-		}
-
-		// Set a (long) timeout here to make sure we don't block forever
-		// and leak a goroutine if the connection stops replying after
-		// the TCP connect.
-		connectCtx, cancel := testHookProxyConnectTimeout(ctx, 1*time.Minute)
-		defer cancel()
-
-		didReadResponse := make(chan struct{}) // closed after CONNECT write+read is done or fails
-		var (
-			resp *Response
-			err  error // write or read error
-		)
-		// Write the CONNECT request & read the response.
-		go func() {
-			defer close(didReadResponse)
-			err = connectReq.Write(conn)
-			if err != nil {
-				return
-			}
-			// Okay to use and discard buffered reader here, because
-			// TLS server will not speak until spoken to.
-			br := bufio.NewReader(&io.LimitedReader{R: conn, N: t.maxHeaderResponseSize()})
-			resp, err = ReadResponse(br, connectReq)
-		}()
-		select {
-		case <-connectCtx.Done():
-			conn.Close()
-			<-didReadResponse
-			return nil, connectCtx.Err()
-		case <-didReadResponse:
-			// resp or err now set
-		}
-		if err != nil {
-			conn.Close()
+		// [dhttp] Shared with the hops of proxy chains.
+		if connectResp, err = t.proxyTunnel(ctx, pconn.conn, cm.proxyURL, cm.targetAddr); err != nil {
 			return nil, err
 		}
-
-		if t.OnProxyConnectResponse != nil {
-			err = t.OnProxyConnectResponse(ctx, cm.proxyURL, connectReq, resp)
-			if err != nil {
-				conn.Close()
-				return nil, err
-			}
-		}
-
-		if resp.StatusCode != 200 {
-			_, text, ok := strings.Cut(resp.Status, " ")
-			conn.Close()
-			if !ok {
-				return nil, errors.New("unknown status code")
-			}
-			return nil, errors.New(text)
-		}
 	}
 
 	if cm.proxyURL != nil && cm.targetScheme == "https" {
@@ -2005,12 +2136,42 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 		}
 	}
 
+	// [dhttp] Describe the connection for Response.Conn.
+	pconn.connInfo = newConnInfo(&cm, pconn.conn, connectResp)
+
+	// [dhttp] Raw connections stop here, before any protocol is layered
+	// on the connection.
+	if cm.raw {
+		return pconn, nil
+	}
+
 	// Possible unencrypted HTTP/2 with prior knowledge.
 	unencryptedHTTP2 := pconn.tlsState == nil &&
 		t.Protocols != nil &&
 		t.Protocols.UnencryptedHTTP2() &&
 		!t.Protocols.HTTP1()
 
+	if cm.fingerprint != nil {
+		// [dhttp] Let the HTTP/2 transport pool and configure this
+		// connection by its fingerprint. Handing a connection over to
+		// HTTP/2 is synchronous, so the entry is only needed until return.
+		t.fingerprintConns.Store(pconn.conn, cm.fingerprint)
+		defer t.fingerprintConns.Delete(pconn.conn)
+	}
+	// [dhttp] Only these can be handed to HTTP/2; other connections need
+	// not be hashable.
+	toHTTP2 := unencryptedHTTP2 || pconn.tlsState != nil
+	if cm.proxyURL != nil && toHTTP2 {
+		// [dhttp] Likewise pool it by its proxies.
+		t.proxyConns.Store(pconn.conn, cm.key().proxy)
+		defer t.proxyConns.Delete(pconn.conn)
+	}
+	if toHTTP2 {
+		// [dhttp] Let HTTP/2 report the connection in Response.Conn.
+		t.connInfos.Store(pconn.conn, pconn.connInfo)
+		defer t.connInfos.Delete(pconn.conn)
+	}
+
 	if isClientConn && (unencryptedHTTP2 || (pconn.tlsState != nil && pconn.tlsState.NegotiatedProtocol == "h2")) {
 		altProto, _ := t.altProto.Load().(map[string]RoundTripper)
 		h2, ok := altProto["https"].(newClientConner)
@@ -2022,7 +2183,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			pconn.conn.Close()
 			return nil, err
 		}
-		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt, isClientConn: true}, nil
+		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt, isClientConn: true, connInfo: pconn.connInfo}, nil
 	}
 
 	if unencryptedHTTP2 {
@@ -2035,7 +2196,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 			// pconn.conn was closed by next (http2configureTransports.upgradeFn).
 			return nil, e.RoundTripErr()
 		}
-		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+		return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt, connInfo: pconn.connInfo}, nil
 	}
 
 	if s := pconn.tlsState; s != nil && s.NegotiatedProtocolIsMutual && s.NegotiatedProtocol != "" {
@@ -2045,7 +2206,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod, isClientConn
 				// pconn.conn was closed by next (http2configureTransports.upgradeFn).
 				return nil, e.RoundTripErr()
 			}
-			return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+			return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt, connInfo: pconn.connInfo}, nil
 		}
 	}
 
@@ -2109,22 +2270,31 @@ type connectMethod struct {
 	// be reused for different targetAddr values.
 	targetAddr string
 	onlyH1     bool // whether to disable HTTP/2 and force HTTP/1
+
+	fingerprint *fingerprintPin // [dhttp] nil unless Transport.Fingerprints is set
+	raw         bool            // [dhttp] dialed by Transport.DialRaw
+	proxyChain  []*url.URL      // [dhttp] proxies before proxyURL, in order
 }
 
 func (cm *connectMethod) key() connectMethodKey {
 	proxyStr := ""
 	targetAddr := cm.targetAddr
 	if cm.proxyURL != nil {
-		proxyStr = cm.proxyURL.String()
+		proxyStr = proxyChainKey(cm.proxyChain) + cm.proxyURL.String() // [dhttp]
 		if (cm.proxyURL.Scheme == "http" || cm.proxyURL.Scheme == "https") && cm.targetScheme == "http" {
 			targetAddr = ""
 		}
 	}
+	fp := ""
+	if cm.fingerprint != nil {
+		fp = cm.fingerprint.id
+	}
 	return connectMethodKey{
 		proxy:  proxyStr,
 		scheme: cm.targetScheme,
 		addr:   targetAddr,
 		onlyH1: cm.onlyH1,
+		fp:     fp,
 	}
 }
 
@@ -2160,6 +2330,7 @@ func (cm *connectMethod) tlsHost() string {
 type connectMethodKey struct {
 	proxy, scheme, addr string
 	onlyH1              bool
+	fp                  string // [dhttp] fingerprint pin, if any
 }
 
 func (k connectMethodKey) String() string {
@@ -2168,6 +2339,9 @@ func (k connectMethodKey) String() string {
 	if k.onlyH1 {
 		h1 = ",h1"
 	}
+	if k.fp != "" {
+		return fmt.Sprintf("%s|%s%s|%s|%s", k.proxy, k.scheme, h1, k.addr, k.fp)
+	}
 	return fmt.Sprintf("%s|%s%s|%s", k.proxy, k.scheme, h1, k.addr)
 }
 
@@ -2182,6 +2356,8 @@ type persistConn struct {
 	t            *Transport
 	cacheKey     connectMethodKey
 	conn         net.Conn
+	raw          bool      // [dhttp] dialed by Transport.DialRaw; HTTP/1.1 ALPN only
+	connInfo     *ConnInfo // [dhttp] for Response.Conn; also set with alt
 	tlsState     *tls.ConnectionState
 	br           *bufio.Reader       // from conn
 	bw           *bufio.Writer       // to conn
@@ -3113,7 +3289,7 @@ func schemePort(scheme string) string {
 		return "80"
 	case "https":
 		return "443"
-	case "socks5", "socks5h":
+	case "socks4", "socks4a", "socks5", "socks5h": // [dhttp] socks4
 		return "1080"
 	default:
 		return ""
@@ -5362,7 +5362,7 @@ func skipIfDNSHijacked(t *testing.T) {
 func TestTransportEventTraceRealDNS(t *testing.T) {
 	skipIfDNSHijacked(t)
 	defer afterTest(t)
-	tr := &Transport{}
+	tr := &Transport{TraceDNS: true} // [dhttp]
 	defer tr.CloseIdleConnections()
 	c := &Client{Transport: tr}
 
@@ -6562,8 +6562,18 @@ func TestTransportClone(t *testing.T) {
 		TLSNextProto: map[string]func(authority string, c *tls.UConn) RoundTripper{
 			"foo": func(authority string, c *tls.UConn) RoundTripper { panic("") },
 		},
-		ReadBufferSize:  1,
-		WriteBufferSize: 1,
+		ReadBufferSize:           1,
+		WriteBufferSize:          1,
+		ClientHelloSettings:      ClientHelloSettings{HelloID: tls.HelloChrome_Auto},
+		TLSSessionCache:          tls.NewLRUClientSessionCache(1),
+		Fingerprints:             &FingerprintRotation{},
+		TCPProfile:               &TCPProfile{},
+		BrowserHeaders:           &BrowserHeaders{},
+		ProxyChain:               func(*Request) ([]*url.URL, error) { panic("") },
+		ProxyClientHelloSettings: &ClientHelloSettings{},
+		ProxyAuthenticator:       &BasicProxyAuth{},
+		ProxyProtocolHeader:      func(context.Context, net.Conn) (*ProxyHeader, error) { panic("") },
+		TraceDNS:                 true,
 	}
 	tr.Protocols.SetHTTP1(true)
 	tr.Protocols.SetHTTP2(true)